@@ -43,22 +43,23 @@ func (c *Compiler) emitLookupBoundsCheck(frame *Frame, arrayLen, index llvm.Valu
 	// Fail: this is a nil pointer, exit with a panic.
 	c.builder.SetInsertPointAtEnd(faultBlock)
 	c.createRuntimeCall("lookuppanic", nil, "")
-	c.builder.CreateUnreachable()
+	c.emitPanicTerminator(frame)
 
 	// Ok: this is a valid pointer.
 	c.builder.SetInsertPointAtEnd(nextBlock)
 }
 
 // emitSliceBoundsCheck emits a bounds check before a slicing operation to make
-// sure it is within bounds.
-func (c *Compiler) emitSliceBoundsCheck(frame *Frame, capacity, low, high llvm.Value, lowType, highType *types.Basic) {
+// sure it is within bounds. The max value is the third index of a full slice
+// expression (s[low:high:max]), or the capacity when there is no third index.
+func (c *Compiler) emitSliceBoundsCheck(frame *Frame, capacity, low, high, max llvm.Value, lowType, highType, maxType *types.Basic) {
 	if frame.fn.IsNoBounds() {
 		// The //go:nobounds pragma was added to the function to avoid bounds
 		// checking.
 		return
 	}
 
-	// Extend the capacity integer to be at least as wide as low and high.
+	// Extend the capacity integer to be at least as wide as low, high and max.
 	capacityType := capacity.Type()
 	if low.Type().IntTypeWidth() > capacityType.IntTypeWidth() {
 		capacityType = low.Type()
@@ -66,11 +67,14 @@ func (c *Compiler) emitSliceBoundsCheck(frame *Frame, capacity, low, high llvm.V
 	if high.Type().IntTypeWidth() > capacityType.IntTypeWidth() {
 		capacityType = high.Type()
 	}
+	if max.Type().IntTypeWidth() > capacityType.IntTypeWidth() {
+		capacityType = max.Type()
+	}
 	if capacityType != capacity.Type() {
 		capacity = c.builder.CreateZExt(capacity, capacityType, "")
 	}
 
-	// Extend low and high to be the same size as capacity.
+	// Extend low, high and max to be the same size as capacity.
 	if low.Type().IntTypeWidth() < capacityType.IntTypeWidth() {
 		if lowType.Info()&types.IsUnsigned != 0 {
 			low = c.builder.CreateZExt(low, capacityType, "")
@@ -85,21 +89,30 @@ func (c *Compiler) emitSliceBoundsCheck(frame *Frame, capacity, low, high llvm.V
 			high = c.builder.CreateSExt(high, capacityType, "")
 		}
 	}
+	if max.Type().IntTypeWidth() < capacityType.IntTypeWidth() {
+		if maxType.Info()&types.IsUnsigned != 0 {
+			max = c.builder.CreateZExt(max, capacityType, "")
+		} else {
+			max = c.builder.CreateSExt(max, capacityType, "")
+		}
+	}
 
 	faultBlock := c.ctx.AddBasicBlock(frame.fn.LLVMFn, "slice.outofbounds")
 	nextBlock := c.ctx.AddBasicBlock(frame.fn.LLVMFn, "slice.next")
 	frame.blockExits[frame.currentBlock] = nextBlock // adjust outgoing block for phi nodes
 
-	// Now do the bounds check: low > high || high > capacity
+	// Now do the bounds check: low > high || high > max || max > capacity
 	outOfBounds1 := c.builder.CreateICmp(llvm.IntUGT, low, high, "slice.lowhigh")
-	outOfBounds2 := c.builder.CreateICmp(llvm.IntUGT, high, capacity, "slice.highcap")
-	outOfBounds := c.builder.CreateOr(outOfBounds1, outOfBounds2, "slice.outofbounds")
+	outOfBounds2 := c.builder.CreateICmp(llvm.IntUGT, high, max, "slice.highmax")
+	outOfBounds3 := c.builder.CreateICmp(llvm.IntUGT, max, capacity, "slice.maxcap")
+	outOfBounds := c.builder.CreateOr(outOfBounds1, outOfBounds2, "slice.lowmax")
+	outOfBounds = c.builder.CreateOr(outOfBounds, outOfBounds3, "slice.outofbounds")
 	c.builder.CreateCondBr(outOfBounds, faultBlock, nextBlock)
 
 	// Fail: this is a nil pointer, exit with a panic.
 	c.builder.SetInsertPointAtEnd(faultBlock)
 	c.createRuntimeCall("slicepanic", nil, "")
-	c.builder.CreateUnreachable()
+	c.emitPanicTerminator(frame)
 
 	// Ok: this is a valid pointer.
 	c.builder.SetInsertPointAtEnd(nextBlock)
@@ -122,7 +135,7 @@ func (c *Compiler) emitNilCheck(frame *Frame, ptr llvm.Value, blockPrefix string
 	// Fail: this is a nil pointer, exit with a panic.
 	c.builder.SetInsertPointAtEnd(faultBlock)
 	c.createRuntimeCall("nilpanic", nil, "")
-	c.builder.CreateUnreachable()
+	c.emitPanicTerminator(frame)
 
 	// Ok: this is a valid pointer.
 	c.builder.SetInsertPointAtEnd(nextBlock)
@@ -11,23 +11,40 @@ import (
 )
 
 // emitMakeChan returns a new channel value for the given channel type.
-func (c *Compiler) emitMakeChan(expr *ssa.MakeChan) (llvm.Value, error) {
-	valueType, err := c.getLLVMType(expr.Type().(*types.Chan).Elem())
+func (c *Compiler) emitMakeChan(frame *Frame, expr *ssa.MakeChan) (llvm.Value, error) {
+	valueType, err := c.getLLVMType(expr.Type().Underlying().(*types.Chan).Elem())
 	if err != nil {
 		return llvm.Value{}, err
 	}
-	if c.targetData.TypeAllocSize(valueType) > c.targetData.TypeAllocSize(c.intType) {
-		// Values bigger than int overflow the data part of the coroutine.
-		// TODO: make the coroutine data part big enough to hold these bigger
-		// values.
-		return llvm.Value{}, c.makeError(expr.Pos(), "todo: channel with values bigger than int")
-	}
-	chanType := c.mod.GetTypeByName("runtime.channel")
-	size := c.targetData.TypeAllocSize(chanType)
-	sizeValue := llvm.ConstInt(c.uintptrType, size, false)
-	ptr := c.createRuntimeCall("alloc", []llvm.Value{sizeValue}, "chan.alloc")
-	ptr = c.builder.CreateBitCast(ptr, llvm.PointerType(chanType, 0), "chan")
-	return ptr, nil
+	elementSize := llvm.ConstInt(c.uintptrType, c.targetData.TypeAllocSize(valueType), false)
+	bufSize, err := c.parseExpr(frame, expr.Size)
+	if err != nil {
+		return llvm.Value{}, err
+	}
+
+	// The buffer size may be of any integer type, convert it to int.
+	sizeTypeWidth := bufSize.Type().IntTypeWidth()
+	intTypeWidth := c.intType.IntTypeWidth()
+	switch {
+	case sizeTypeWidth < intTypeWidth:
+		if expr.Size.Type().Underlying().(*types.Basic).Info()&types.IsUnsigned != 0 {
+			bufSize = c.builder.CreateZExt(bufSize, c.intType, "")
+		} else {
+			bufSize = c.builder.CreateSExt(bufSize, c.intType, "")
+		}
+	case sizeTypeWidth > intTypeWidth:
+		// The size may not fit in an int (on a 32-bit system, for example),
+		// check it before truncating.
+		c.createRuntimeCall("chanMakeCheck64", []llvm.Value{bufSize}, "")
+		c.emitPanicCheck(frame)
+		bufSize = c.builder.CreateTrunc(bufSize, c.intType, "")
+	}
+	// An unsigned size of the same width as int that doesn't fit in an int
+	// becomes negative, which is checked by chanMake.
+
+	ch := c.createRuntimeCall("chanMake", []llvm.Value{elementSize, bufSize}, "chan")
+	c.emitPanicCheck(frame)
+	return ch, nil
 }
 
 // emitChanSend emits a pseudo chan send operation. It is lowered to the actual
@@ -45,11 +62,14 @@ func (c *Compiler) emitChanSend(frame *Frame, instr *ssa.Send) error {
 	if err != nil {
 		return err
 	}
-	valueSize := llvm.ConstInt(c.uintptrType, c.targetData.TypeAllocSize(chanValue.Type()), false)
 	valueAlloca := c.builder.CreateAlloca(valueType, "chan.value")
 	c.builder.CreateStore(chanValue, valueAlloca)
 	valueAllocaCast := c.builder.CreateBitCast(valueAlloca, c.i8ptrType, "chan.value.i8ptr")
-	c.createRuntimeCall("chanSendStub", []llvm.Value{llvm.Undef(c.i8ptrType), ch, valueAllocaCast, valueSize}, "")
+	c.createRuntimeCall("chanSendStub", []llvm.Value{llvm.Undef(c.i8ptrType), ch, valueAllocaCast}, "")
+	// The receiver may copy the value from the alloca after the sender has been
+	// suspended, so it must be kept in the coroutine frame until then.
+	c.emitLifetimeEnd(valueAlloca)
+	c.emitPanicCheck(frame)
 	return nil
 }
 
@@ -60,7 +80,6 @@ func (c *Compiler) emitChanRecv(frame *Frame, unop *ssa.UnOp) (llvm.Value, error
 	if err != nil {
 		return llvm.Value{}, err
 	}
-	valueSize := llvm.ConstInt(c.uintptrType, c.targetData.TypeAllocSize(valueType), false)
 	ch, err := c.parseExpr(frame, unop.X)
 	if err != nil {
 		return llvm.Value{}, err
@@ -68,7 +87,7 @@ func (c *Compiler) emitChanRecv(frame *Frame, unop *ssa.UnOp) (llvm.Value, error
 	valueAlloca := c.builder.CreateAlloca(valueType, "chan.value")
 	valueAllocaCast := c.builder.CreateBitCast(valueAlloca, c.i8ptrType, "chan.value.i8ptr")
 	valueOk := c.builder.CreateAlloca(c.ctx.Int1Type(), "chan.comma-ok.alloca")
-	c.createRuntimeCall("chanRecvStub", []llvm.Value{llvm.Undef(c.i8ptrType), ch, valueAllocaCast, valueOk, valueSize}, "")
+	c.createRuntimeCall("chanRecvStub", []llvm.Value{llvm.Undef(c.i8ptrType), ch, valueAllocaCast, valueOk}, "")
 	received := c.builder.CreateLoad(valueAlloca, "chan.received")
 	if unop.CommaOk {
 		commaOk := c.builder.CreateLoad(valueOk, "chan.comma-ok")
@@ -82,16 +101,146 @@ func (c *Compiler) emitChanRecv(frame *Frame, unop *ssa.UnOp) (llvm.Value, error
 }
 
 // emitChanClose closes the given channel.
-func (c *Compiler) emitChanClose(frame *Frame, param ssa.Value) error {
-	valueType, err := c.getLLVMType(param.Type().(*types.Chan).Elem())
-	valueSize := llvm.ConstInt(c.uintptrType, c.targetData.TypeAllocSize(valueType), false)
-	if err != nil {
-		return err
+func (c *Compiler) emitChanClose(frame *Frame, ch llvm.Value) {
+	c.createRuntimeCall("chanClose", []llvm.Value{ch}, "")
+	c.emitPanicCheck(frame)
+}
+
+// emitSelect emits all IR necessary for a select statements. That's a
+// non-trivial amount of code because select is very complex to implement.
+//
+// A non-blocking select (with a default case) is lowered to a direct call to
+// runtime.chanTrySelect. A blocking select is lowered to a pseudo call to
+// runtime.chanSelectStub, which is replaced during goroutine lowering with the
+// actual blocking operation.
+func (c *Compiler) emitSelect(frame *Frame, expr *ssa.Select) (llvm.Value, error) {
+	// Determine the type of the result tuple and the size of the buffer that
+	// a received value is stored in.
+	resultTypes := []llvm.Type{c.intType, c.ctx.Int1Type()}
+	recvbufSize := uint64(0)
+	recvbufAlign := 1
+	for _, state := range expr.States {
+		if state.Dir != types.RecvOnly {
+			continue
+		}
+		valueType, err := c.getLLVMType(state.Chan.Type().Underlying().(*types.Chan).Elem())
+		if err != nil {
+			return llvm.Value{}, err
+		}
+		resultTypes = append(resultTypes, valueType)
+		if size := c.targetData.TypeAllocSize(valueType); size > recvbufSize {
+			recvbufSize = size
+		}
+		if align := c.targetData.ABITypeAlignment(valueType); align > recvbufAlign {
+			recvbufAlign = align
+		}
 	}
-	ch, err := c.parseExpr(frame, param)
-	if err != nil {
-		return err
+	recvbufType := llvm.ArrayType(c.ctx.Int8Type(), int(recvbufSize))
+	recvbufAlloca := c.builder.CreateAlloca(recvbufType, "select.recvbuf.alloca")
+	recvbufAlloca.SetAlignment(recvbufAlign)
+	recvbuf := c.builder.CreateBitCast(recvbufAlloca, c.i8ptrType, "select.recvbuf")
+
+	// Create the list of select states (one for each case).
+	var allocas []llvm.Value // temporary allocas, see below
+	chanSelectStateType := c.mod.GetTypeByName("runtime.chanSelectState")
+	statesType := llvm.ArrayType(chanSelectStateType, len(expr.States))
+	states := llvm.ConstNull(statesType)
+	for i, state := range expr.States {
+		ch, err := c.parseExpr(frame, state.Chan)
+		if err != nil {
+			return llvm.Value{}, err
+		}
+		selectState := llvm.ConstNull(chanSelectStateType)
+		selectState = c.builder.CreateInsertValue(selectState, ch, 0, "")
+		switch state.Dir {
+		case types.RecvOnly:
+			// Make sure the value pointer is nil, to indicate this is a receive
+			// operation.
+		case types.SendOnly:
+			sendValue, err := c.parseExpr(frame, state.Send)
+			if err != nil {
+				return llvm.Value{}, err
+			}
+			sendValueAlloca := c.builder.CreateAlloca(sendValue.Type(), "select.send.value")
+			c.builder.CreateStore(sendValue, sendValueAlloca)
+			sendValuePtr := c.builder.CreateBitCast(sendValueAlloca, c.i8ptrType, "")
+			selectState = c.builder.CreateInsertValue(selectState, sendValuePtr, 1, "")
+			allocas = append(allocas, sendValueAlloca)
+		default:
+			panic("unreachable")
+		}
+		states = c.builder.CreateInsertValue(states, selectState, i, "")
 	}
-	c.createRuntimeCall("chanClose", []llvm.Value{ch, valueSize}, "")
-	return nil
+	statesAlloca := c.builder.CreateAlloca(statesType, "select.states.alloca")
+	c.builder.CreateStore(states, statesAlloca)
+	allocas = append(allocas, statesAlloca)
+	statesSlice := c.emitArraySlice(statesAlloca, len(expr.States), "select.states")
+
+	// Do the select in the runtime.
+	var results llvm.Value
+	if expr.Blocking {
+		// Every case needs an entry in the blocked list of its channel while
+		// the select statement is blocked.
+		opsType := llvm.ArrayType(c.mod.GetTypeByName("runtime.channelBlockedList"), len(expr.States))
+		opsAlloca := c.builder.CreateAlloca(opsType, "select.ops.alloca")
+		allocas = append(allocas, opsAlloca)
+		opsSlice := c.emitArraySlice(opsAlloca, len(expr.States), "select.ops")
+		results = c.createRuntimeCall("chanSelectStub", []llvm.Value{llvm.Undef(c.i8ptrType), recvbuf, statesSlice, opsSlice}, "select.result")
+	} else {
+		results = c.createRuntimeCall("chanTrySelect", []llvm.Value{recvbuf, statesSlice}, "select.result")
+	}
+
+	// End the lifetime of the temporary allocas. Apart from being a hint to
+	// the optimizer, this also makes sure they are stored in the coroutine
+	// frame when the select statement blocks: the runtime may still refer to
+	// them until the goroutine is re-activated.
+	for _, alloca := range allocas {
+		c.emitLifetimeEnd(alloca)
+	}
+	c.emitPanicCheck(frame)
+
+	// Create the result tuple: {index, recvOk, r0, r1, ...}.
+	index := c.builder.CreateExtractValue(results, 0, "select.index")
+	if c.targetData.TypeAllocSize(c.intType) > c.targetData.TypeAllocSize(c.uintptrType) {
+		index = c.builder.CreateSExt(index, c.intType, "")
+	} else if c.targetData.TypeAllocSize(c.intType) < c.targetData.TypeAllocSize(c.uintptrType) {
+		index = c.builder.CreateTrunc(index, c.intType, "")
+	}
+	tuple := llvm.Undef(c.ctx.StructType(resultTypes, false))
+	tuple = c.builder.CreateInsertValue(tuple, index, 0, "")
+	tuple = c.builder.CreateInsertValue(tuple, c.builder.CreateExtractValue(results, 1, "select.ok"), 1, "")
+	for i, valueType := range resultTypes[2:] {
+		// Only the selected receive case stores a value in the receive
+		// buffer, but loading it for the other cases is harmless.
+		recvbufPtr := c.builder.CreateBitCast(recvbufAlloca, llvm.PointerType(valueType, 0), "")
+		received := c.builder.CreateLoad(recvbufPtr, "select.received")
+		tuple = c.builder.CreateInsertValue(tuple, received, i+2, "")
+	}
+	return tuple, nil
+}
+
+// emitArraySlice returns a slice value that refers to the array pointed to by
+// the given pointer (an alloca, for example).
+func (c *Compiler) emitArraySlice(arrayPtr llvm.Value, length int, name string) llvm.Value {
+	zero := llvm.ConstInt(c.ctx.Int32Type(), 0, false)
+	ptr := c.builder.CreateGEP(arrayPtr, []llvm.Value{zero, zero}, name+".ptr")
+	lenValue := llvm.ConstInt(c.uintptrType, uint64(length), false)
+	slice := llvm.Undef(c.ctx.StructType([]llvm.Type{ptr.Type(), c.uintptrType, c.uintptrType}, false))
+	slice = c.builder.CreateInsertValue(slice, ptr, 0, "")
+	slice = c.builder.CreateInsertValue(slice, lenValue, 1, "")
+	slice = c.builder.CreateInsertValue(slice, lenValue, 2, "")
+	return slice
+}
+
+// emitLifetimeEnd signals the end of the lifetime of the given alloca to LLVM,
+// using the llvm.lifetime.end intrinsic.
+func (c *Compiler) emitLifetimeEnd(alloca llvm.Value) {
+	fn := c.mod.NamedFunction("llvm.lifetime.end.p0i8")
+	if fn.IsNil() {
+		fnType := llvm.FunctionType(c.ctx.VoidType(), []llvm.Type{c.ctx.Int64Type(), c.i8ptrType}, false)
+		fn = llvm.AddFunction(c.mod, "llvm.lifetime.end.p0i8", fnType)
+	}
+	size := c.targetData.TypeAllocSize(alloca.Type().ElementType())
+	ptr := c.builder.CreateBitCast(alloca, c.i8ptrType, "")
+	c.builder.CreateCall(fn, []llvm.Value{llvm.ConstInt(c.ctx.Int64Type(), size, false), ptr}, "")
 }
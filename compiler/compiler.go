@@ -30,18 +30,21 @@ func init() {
 
 // Configure the compiler.
 type Config struct {
-	Triple    string   // LLVM target triple, e.g. x86_64-unknown-linux-gnu (empty string means default)
-	CPU       string   // LLVM CPU name, e.g. atmega328p (empty string means default)
-	GOOS      string   //
-	GOARCH    string   //
-	GC        string   // garbage collection strategy
-	CFlags    []string // cflags to pass to cgo
-	LDFlags   []string // ldflags to pass to cgo
-	DumpSSA   bool     // dump Go SSA, for compiler debugging
-	Debug     bool     // add debug symbols for gdb
-	RootDir   string   // GOROOT for TinyGo
-	GOPATH    string   // GOPATH, like `go env GOPATH`
-	BuildTags []string // build tags for TinyGo (empty means {Config.GOOS/Config.GOARCH})
+	Triple      string   // LLVM target triple, e.g. x86_64-unknown-linux-gnu (empty string means default)
+	CPU         string   // LLVM CPU name, e.g. atmega328p (empty string means default)
+	GOOS        string   //
+	GOARCH      string   //
+	GC          string   // garbage collection strategy
+	CFlags      []string // cflags to pass to cgo
+	LDFlags     []string // ldflags to pass to cgo
+	DumpSSA     bool     // dump Go SSA, for compiler debugging
+	PrintAllocs bool     // print heap allocations that could not be moved to the stack
+	Debug       bool     // add debug symbols for gdb
+	NoFuncTable bool     // leave out the function table used for stack traces
+	StackLines  bool     // add the line of every call to the function table, requires Debug
+	RootDir     string   // GOROOT for TinyGo
+	GOPATH      string   // GOPATH, like `go env GOPATH`
+	BuildTags   []string // build tags for TinyGo (empty means {Config.GOOS/Config.GOARCH})
 }
 
 type Compiler struct {
@@ -61,7 +64,13 @@ type Compiler struct {
 	uintptrType             llvm.Type
 	initFuncs               []llvm.Value
 	interfaceInvokeWrappers []interfaceInvokeWrapper
+	mapKeyFuncs             []mapKeyFuncs
+	interfaceTypes          map[string]types.Type    // types used in interfaces
+	reflectInterfaceTypes   map[string]types.Type    // types that may only be put in interfaces by the reflect package
+	reflectTypes            map[string]types.Type    // struct and map types that may need metadata for reflection
+	heapAllocs              map[llvm.Value]token.Pos // source position of runtime.alloc calls, for PrintAllocs
 	ir                      *ir.Program
+	unwinding               bool // unwind the stack on panic, see panic.go
 }
 
 type Frame struct {
@@ -73,11 +82,13 @@ type Frame struct {
 	phis              []Phi
 	taskHandle        llvm.Value
 	deferPtr          llvm.Value
+	unwindBlock       llvm.BasicBlock // created on first use, see panic.go
 	difunc            llvm.Metadata
 	allDeferFuncs     []interface{}
 	deferFuncs        map[*ir.Function]int
 	deferInvokeFuncs  map[string]int
 	deferClosureFuncs map[*ir.Function]int
+	canRecover        llvm.Value // whether recover() may stop a panic, see emitRecoverEntry
 }
 
 type Phi struct {
@@ -93,9 +104,13 @@ func NewCompiler(pkgName string, config Config) (*Compiler, error) {
 		config.BuildTags = []string{config.GOOS, config.GOARCH}
 	}
 	c := &Compiler{
-		Config:  config,
-		difiles: make(map[string]llvm.Metadata),
-		ditypes: make(map[string]llvm.Metadata),
+		Config:                config,
+		difiles:               make(map[string]llvm.Metadata),
+		ditypes:               make(map[string]llvm.Metadata),
+		heapAllocs:            make(map[llvm.Value]token.Pos),
+		interfaceTypes:        make(map[string]types.Type),
+		reflectInterfaceTypes: make(map[string]types.Type),
+		reflectTypes:          make(map[string]types.Type),
 	}
 
 	target, err := llvm.GetTargetFromTriple(config.Triple)
@@ -277,6 +292,13 @@ func (c *Compiler) Compile(mainPath string) error {
 		}
 	}
 
+	// Unwind the stack on panic instead of aborting, if recover() is used
+	// anywhere.
+	c.unwinding = c.needsUnwinding()
+	if c.unwinding {
+		c.mod.NamedGlobal("runtime.panicUnwinding").SetInitializer(llvm.ConstInt(c.ctx.Int1Type(), 1, false))
+	}
+
 	// Declare all functions.
 	for _, f := range c.ir.Functions {
 		frame, err := c.parseFuncDecl(f)
@@ -312,6 +334,21 @@ func (c *Compiler) Compile(mainPath string) error {
 		}
 	}
 
+	// Define the already declared hash and equality functions of map keys.
+	for _, state := range c.mapKeyFuncs {
+		err = c.createMapKeyFuncs(state)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Define the functions that compare and hash values stored in interfaces,
+	// now that all types used in interfaces are known.
+	err = c.createInterfaceFuncs()
+	if err != nil {
+		return err
+	}
+
 	// After all packages are imported, add a synthetic initializer function
 	// that calls the initializer of each package.
 	initFn := c.ir.GetFunction(c.ir.Program.ImportedPackage("runtime").Members["initAll"].(*ssa.Function))
@@ -340,8 +377,18 @@ func (c *Compiler) Compile(mainPath string) error {
 	c.mod.NamedFunction("runtime.free").SetLinkage(llvm.ExternalLinkage)
 	c.mod.NamedFunction("runtime.chanSend").SetLinkage(llvm.ExternalLinkage)
 	c.mod.NamedFunction("runtime.chanRecv").SetLinkage(llvm.ExternalLinkage)
+	c.mod.NamedFunction("runtime.chanSelect").SetLinkage(llvm.ExternalLinkage)
+	c.mod.NamedFunction("runtime.chanSendNoScheduler").SetLinkage(llvm.ExternalLinkage)
+	c.mod.NamedFunction("runtime.chanRecvNoScheduler").SetLinkage(llvm.ExternalLinkage)
+	c.mod.NamedFunction("runtime.chanSelectNoScheduler").SetLinkage(llvm.ExternalLinkage)
+	c.mod.NamedFunction("runtime.semacquire").SetLinkage(llvm.ExternalLinkage)
+	c.mod.NamedFunction("runtime.semacquireNoScheduler").SetLinkage(llvm.ExternalLinkage)
 	c.mod.NamedFunction("runtime.sleepTask").SetLinkage(llvm.ExternalLinkage)
 	c.mod.NamedFunction("runtime.activateTask").SetLinkage(llvm.ExternalLinkage)
+	c.mod.NamedFunction("runtime.returnTask").SetLinkage(llvm.ExternalLinkage)
+	c.mod.NamedFunction("runtime.awaitTask").SetLinkage(llvm.ExternalLinkage)
+	c.mod.NamedFunction("runtime.mainReturned").SetLinkage(llvm.ExternalLinkage)
+	c.mod.NamedFunction("runtime.yieldPanic").SetLinkage(llvm.ExternalLinkage)
 	c.mod.NamedFunction("runtime.scheduler").SetLinkage(llvm.ExternalLinkage)
 
 	// Tell the optimizer that runtime.alloc is an allocator, meaning that it
@@ -654,6 +701,22 @@ func (c *Compiler) parseFuncDecl(f *ir.Function) (*Frame, error) {
 		frame.fn.LLVMFn = llvm.AddFunction(c.mod, name, fnType)
 	}
 
+	if f.IsNoEscape() {
+		// Tell the escape analysis (see OptimizeAllocs) that no pointer
+		// parameter escapes this function.
+		nocapture := c.ctx.CreateEnumAttribute(llvm.AttributeKindID("nocapture"), 0)
+		for i, paramType := range paramTypes {
+			if paramType.TypeKind() == llvm.PointerTypeKind {
+				frame.fn.LLVMFn.AddAttributeAtIndex(i+1, nocapture)
+			}
+		}
+	}
+
+	if f.IsNoInline() {
+		noinline := c.ctx.CreateEnumAttribute(llvm.AttributeKindID("noinline"), 0)
+		frame.fn.LLVMFn.AddFunctionAttr(noinline)
+	}
+
 	return frame, nil
 }
 
@@ -663,13 +726,7 @@ func (c *Compiler) attachDebugInfo(f *ir.Function) (llvm.Metadata, error) {
 }
 
 func (c *Compiler) attachDebugInfoRaw(f *ir.Function, llvmFn llvm.Value, suffix, filename string, line int) (llvm.Metadata, error) {
-	if _, ok := c.difiles[filename]; !ok {
-		dir, file := filepath.Split(filename)
-		if dir != "" {
-			dir = dir[:len(dir)-1]
-		}
-		c.difiles[filename] = c.dibuilder.CreateFile(file, dir)
-	}
+	difile := c.getDIFile(filename)
 
 	// Debug info for this function.
 	diparams := make([]llvm.Metadata, 0, len(f.Params))
@@ -681,14 +738,14 @@ func (c *Compiler) attachDebugInfoRaw(f *ir.Function, llvmFn llvm.Value, suffix,
 		diparams = append(diparams, ditype)
 	}
 	diFuncType := c.dibuilder.CreateSubroutineType(llvm.DISubroutineType{
-		File:       c.difiles[filename],
+		File:       difile,
 		Parameters: diparams,
 		Flags:      0, // ?
 	})
-	difunc := c.dibuilder.CreateFunction(c.difiles[filename], llvm.DIFunction{
+	difunc := c.dibuilder.CreateFunction(difile, llvm.DIFunction{
 		Name:         f.RelString(nil) + suffix,
 		LinkageName:  f.LinkName() + suffix,
-		File:         c.difiles[filename],
+		File:         difile,
 		Line:         line,
 		Type:         diFuncType,
 		LocalToUnit:  true,
@@ -701,10 +758,54 @@ func (c *Compiler) attachDebugInfoRaw(f *ir.Function, llvmFn llvm.Value, suffix,
 	return difunc, nil
 }
 
+// attachSyntheticDebugInfo adds debug info to a function generated by the
+// compiler that has no Go equivalent, and sets the current debug location to
+// the start of this function. It does nothing when debug info is disabled.
+func (c *Compiler) attachSyntheticDebugInfo(llvmFn llvm.Value, pos token.Pos) {
+	if !c.Debug {
+		return
+	}
+	position := c.ir.Program.Fset.Position(pos)
+	difile := c.getDIFile(position.Filename)
+	diFuncType := c.dibuilder.CreateSubroutineType(llvm.DISubroutineType{
+		File: difile,
+	})
+	difunc := c.dibuilder.CreateFunction(difile, llvm.DIFunction{
+		Name:         llvmFn.Name(),
+		LinkageName:  llvmFn.Name(),
+		File:         difile,
+		Line:         position.Line,
+		Type:         diFuncType,
+		LocalToUnit:  true,
+		IsDefinition: true,
+		ScopeLine:    0,
+		Flags:        llvm.FlagPrototyped,
+		Optimized:    true,
+	})
+	llvmFn.SetSubprogram(difunc)
+	c.builder.SetCurrentDebugLocation(uint(position.Line), uint(position.Column), difunc, llvm.Metadata{})
+}
+
+// getDIFile returns the debug info file for the given filename, creating it if
+// it doesn't exist yet.
+func (c *Compiler) getDIFile(filename string) llvm.Metadata {
+	if _, ok := c.difiles[filename]; !ok {
+		dir, file := filepath.Split(filename)
+		if dir != "" {
+			dir = dir[:len(dir)-1]
+		}
+		c.difiles[filename] = c.dibuilder.CreateFile(file, dir)
+	}
+	return c.difiles[filename]
+}
+
 func (c *Compiler) parseFunc(frame *Frame) error {
 	if c.DumpSSA {
 		fmt.Printf("\nfunc %s:\n", frame.fn.Function)
 	}
+	if frame.fn.IsNoEscape() {
+		return c.makeError(frame.fn.Pos(), "can only use //go:noescape with external func implementations")
+	}
 	if !frame.fn.IsExported() {
 		frame.fn.LLVMFn.SetLinkage(llvm.InternalLinkage)
 		frame.fn.LLVMFn.SetUnnamedAddr(true)
@@ -832,6 +933,8 @@ func (c *Compiler) parseFunc(frame *Frame) error {
 		c.deferInitFunc(frame)
 	}
 
+	c.emitRecoverEntry(frame)
+
 	// Fill blocks with instructions.
 	for _, block := range frame.fn.DomPreorder() {
 		if c.DumpSSA {
@@ -860,6 +963,12 @@ func (c *Compiler) parseFunc(frame *Frame) error {
 		}
 	}
 
+	// Add the code that runs when a panic passes through this function.
+	err := c.emitUnwindBlock(frame)
+	if err != nil {
+		return err
+	}
+
 	// Resolve phi nodes
 	for _, phi := range frame.phis {
 		block := phi.ssa.Block()
@@ -892,39 +1001,10 @@ func (c *Compiler) parseInstr(frame *Frame, instr ssa.Instruction) error {
 	case *ssa.Defer:
 		return c.emitDefer(frame, instr)
 	case *ssa.Go:
-		if instr.Call.IsInvoke() {
-			return c.makeError(instr.Pos(), "todo: go on method receiver")
-		}
-		callee := instr.Call.StaticCallee()
-		if callee == nil {
-			return c.makeError(instr.Pos(), "todo: go on non-direct function (function pointer, etc.)")
-		}
-		calleeFn := c.ir.GetFunction(callee)
-
-		// Mark this function as a 'go' invocation and break invalid
-		// interprocedural optimizations. For example, heap-to-stack
-		// transformations are not sound as goroutines can outlive their parent.
-		calleeType := calleeFn.LLVMFn.Type()
-		calleeValue := c.builder.CreateBitCast(calleeFn.LLVMFn, c.i8ptrType, "")
-		calleeValue = c.createRuntimeCall("makeGoroutine", []llvm.Value{calleeValue}, "")
-		calleeValue = c.builder.CreateBitCast(calleeValue, calleeType, "")
-
-		// Get all function parameters to pass to the goroutine.
-		var params []llvm.Value
-		for _, param := range instr.Call.Args {
-			val, err := c.parseExpr(frame, param)
-			if err != nil {
-				return err
-			}
-			params = append(params, val)
+		if _, ok := instr.Call.Value.(*ssa.Builtin); ok {
+			return c.makeError(instr.Pos(), "todo: go on builtin function")
 		}
-		if !calleeFn.IsExported() {
-			params = append(params, llvm.Undef(c.i8ptrType)) // context parameter
-			params = append(params, llvm.Undef(c.i8ptrType)) // parent coroutine handle
-		}
-
-		c.createCall(calleeValue, params, "")
-		return nil
+		return c.emitGo(frame, instr)
 	case *ssa.If:
 		cond, err := c.parseExpr(frame, instr.Cond)
 		if err != nil {
@@ -953,14 +1033,14 @@ func (c *Compiler) parseInstr(frame *Frame, instr ssa.Instruction) error {
 			return err
 		}
 		mapType := instr.Map.Type().Underlying().(*types.Map)
-		return c.emitMapUpdate(mapType.Key(), m, key, value, instr.Pos())
+		return c.emitMapUpdate(frame, mapType.Key(), m, key, value, instr.Pos())
 	case *ssa.Panic:
 		value, err := c.parseExpr(frame, instr.X)
 		if err != nil {
 			return err
 		}
 		c.createRuntimeCall("_panic", []llvm.Value{value}, "")
-		c.builder.CreateUnreachable()
+		c.emitPanicTerminator(frame)
 		return nil
 	case *ssa.Return:
 		if len(instr.Results) == 0 {
@@ -990,7 +1070,7 @@ func (c *Compiler) parseInstr(frame *Frame, instr ssa.Instruction) error {
 			return nil
 		}
 	case *ssa.RunDefers:
-		return c.emitRunDefers(frame)
+		return c.emitRunDefers(frame, false)
 	case *ssa.Send:
 		return c.emitChanSend(frame, instr)
 	case *ssa.Store:
@@ -1018,17 +1098,11 @@ func (c *Compiler) parseInstr(frame *Frame, instr ssa.Instruction) error {
 	}
 }
 
-func (c *Compiler) parseBuiltin(frame *Frame, args []ssa.Value, callName string, pos token.Pos) (llvm.Value, error) {
+func (c *Compiler) parseBuiltin(frame *Frame, argTypes []types.Type, argValues []llvm.Value, callName string, pos token.Pos) (llvm.Value, error) {
 	switch callName {
 	case "append":
-		src, err := c.parseExpr(frame, args[0])
-		if err != nil {
-			return llvm.Value{}, err
-		}
-		elems, err := c.parseExpr(frame, args[1])
-		if err != nil {
-			return llvm.Value{}, err
-		}
+		src := argValues[0]
+		elems := argValues[1]
 		srcBuf := c.builder.CreateExtractValue(src, 0, "append.srcBuf")
 		srcPtr := c.builder.CreateBitCast(srcBuf, c.i8ptrType, "append.srcPtr")
 		srcLen := c.builder.CreateExtractValue(src, 1, "append.srcLen")
@@ -1049,53 +1123,31 @@ func (c *Compiler) parseBuiltin(frame *Frame, args []ssa.Value, callName string,
 		newSlice = c.builder.CreateInsertValue(newSlice, newCap, 2, "")
 		return newSlice, nil
 	case "cap":
-		value, err := c.parseExpr(frame, args[0])
-		if err != nil {
-			return llvm.Value{}, err
-		}
-		switch args[0].Type().(type) {
+		value := argValues[0]
+		switch argTypes[0].Underlying().(type) {
 		case *types.Chan:
-			// Channel. Buffered channels haven't been implemented yet so always
-			// return 0.
-			return llvm.ConstInt(c.intType, 0, false), nil
+			return c.createRuntimeCall("chanCap", []llvm.Value{value}, "cap"), nil
 		case *types.Slice:
 			return c.builder.CreateExtractValue(value, 2, "cap"), nil
 		default:
 			return llvm.Value{}, c.makeError(pos, "todo: cap: unknown type")
 		}
 	case "close":
-		return llvm.Value{}, c.emitChanClose(frame, args[0])
+		c.emitChanClose(frame, argValues[0])
+		return llvm.Value{}, nil
 	case "complex":
-		r, err := c.parseExpr(frame, args[0])
-		if err != nil {
-			return llvm.Value{}, err
-		}
-		i, err := c.parseExpr(frame, args[1])
-		if err != nil {
-			return llvm.Value{}, err
-		}
-		t := args[0].Type().Underlying().(*types.Basic)
-		var cplx llvm.Value
+		r := argValues[0]
+		i := argValues[1]
+		t := argTypes[0].Underlying().(*types.Basic)
 		switch t.Kind() {
-		case types.Float32:
-			cplx = llvm.Undef(c.ctx.StructType([]llvm.Type{c.ctx.FloatType(), c.ctx.FloatType()}, false))
-		case types.Float64:
-			cplx = llvm.Undef(c.ctx.StructType([]llvm.Type{c.ctx.DoubleType(), c.ctx.DoubleType()}, false))
+		case types.Float32, types.Float64:
+			return c.createComplex(c.ctx.StructType([]llvm.Type{r.Type(), r.Type()}, false), r, i), nil
 		default:
 			return llvm.Value{}, c.makeError(pos, "unsupported type in complex builtin: "+t.String())
 		}
-		cplx = c.builder.CreateInsertValue(cplx, r, 0, "")
-		cplx = c.builder.CreateInsertValue(cplx, i, 1, "")
-		return cplx, nil
 	case "copy":
-		dst, err := c.parseExpr(frame, args[0])
-		if err != nil {
-			return llvm.Value{}, err
-		}
-		src, err := c.parseExpr(frame, args[1])
-		if err != nil {
-			return llvm.Value{}, err
-		}
+		dst := argValues[0]
+		src := argValues[1]
 		dstLen := c.builder.CreateExtractValue(dst, 1, "copy.dstLen")
 		srcLen := c.builder.CreateExtractValue(src, 1, "copy.srcLen")
 		dstBuf := c.builder.CreateExtractValue(dst, 0, "copy.dstArray")
@@ -1106,35 +1158,18 @@ func (c *Compiler) parseBuiltin(frame *Frame, args []ssa.Value, callName string,
 		elemSize := llvm.ConstInt(c.uintptrType, c.targetData.TypeAllocSize(elemType), false)
 		return c.createRuntimeCall("sliceCopy", []llvm.Value{dstBuf, srcBuf, dstLen, srcLen, elemSize}, "copy.n"), nil
 	case "delete":
-		m, err := c.parseExpr(frame, args[0])
-		if err != nil {
-			return llvm.Value{}, err
-		}
-		key, err := c.parseExpr(frame, args[1])
-		if err != nil {
-			return llvm.Value{}, err
-		}
-		return llvm.Value{}, c.emitMapDelete(args[1].Type(), m, key, pos)
+		return llvm.Value{}, c.emitMapDelete(frame, argTypes[1], argValues[0], argValues[1], pos)
 	case "imag":
-		cplx, err := c.parseExpr(frame, args[0])
-		if err != nil {
-			return llvm.Value{}, err
-		}
-		return c.builder.CreateExtractValue(cplx, 1, "imag"), nil
+		return c.builder.CreateExtractValue(argValues[0], 1, "imag"), nil
 	case "len":
-		value, err := c.parseExpr(frame, args[0])
-		if err != nil {
-			return llvm.Value{}, err
-		}
+		value := argValues[0]
 		var llvmLen llvm.Value
-		switch args[0].Type().Underlying().(type) {
+		switch argTypes[0].Underlying().(type) {
 		case *types.Basic, *types.Slice:
 			// string or slice
 			llvmLen = c.builder.CreateExtractValue(value, 1, "len")
 		case *types.Chan:
-			// Channel. Buffered channels haven't been implemented yet so always
-			// return 0.
-			llvmLen = llvm.ConstInt(c.intType, 0, false)
+			llvmLen = c.createRuntimeCall("chanLen", []llvm.Value{value}, "len")
 		case *types.Map:
 			llvmLen = c.createRuntimeCall("hashmapLen", []llvm.Value{value}, "len")
 		default:
@@ -1144,16 +1179,17 @@ func (c *Compiler) parseBuiltin(frame *Frame, args []ssa.Value, callName string,
 			llvmLen = c.builder.CreateZExt(llvmLen, c.intType, "len.int")
 		}
 		return llvmLen, nil
+	case "panic":
+		// Only reachable as a deferred call: regular calls to panic are
+		// converted to a *ssa.Panic instruction.
+		c.createRuntimeCall("_panic", []llvm.Value{argValues[0]}, "")
+		return llvm.Value{}, nil
 	case "print", "println":
-		for i, arg := range args {
+		for i, value := range argValues {
 			if i >= 1 && callName == "println" {
 				c.createRuntimeCall("printspace", nil, "")
 			}
-			value, err := c.parseExpr(frame, arg)
-			if err != nil {
-				return llvm.Value{}, err
-			}
-			typ := arg.Type().Underlying()
+			typ := argTypes[i].Underlying()
 			switch typ := typ.(type) {
 			case *types.Basic:
 				switch typ.Kind() {
@@ -1205,16 +1241,16 @@ func (c *Compiler) parseBuiltin(frame *Frame, args []ssa.Value, callName string,
 		}
 		return llvm.Value{}, nil // print() or println() returns void
 	case "real":
-		cplx, err := c.parseExpr(frame, args[0])
-		if err != nil {
-			return llvm.Value{}, err
-		}
-		return c.builder.CreateExtractValue(cplx, 0, "real"), nil
+		return c.builder.CreateExtractValue(argValues[0], 0, "real"), nil
 	case "recover":
-		return c.createRuntimeCall("_recover", nil, ""), nil
+		canRecover := frame.canRecover
+		if canRecover.IsNil() {
+			canRecover = llvm.ConstInt(c.ctx.Int1Type(), 0, false)
+		}
+		return c.createRuntimeCall("_recover", []llvm.Value{canRecover}, ""), nil
 	case "ssa:wrapnilchk":
 		// TODO: do an actual nil check?
-		return c.parseExpr(frame, args[0])
+		return argValues[0], nil
 	default:
 		return llvm.Value{}, c.makeError(pos, "todo: builtin: "+callName)
 	}
@@ -1239,7 +1275,12 @@ func (c *Compiler) parseFunctionCall(frame *Frame, args []ssa.Value, llvmFn, con
 		params = append(params, llvm.Undef(c.i8ptrType))
 	}
 
-	return c.createCall(llvmFn, params, ""), nil
+	result := c.createCall(llvmFn, params, "")
+	if !exported {
+		// Exported functions don't return a panic to the caller.
+		c.emitPanicCheck(frame)
+	}
+	return result, nil
 }
 
 func (c *Compiler) parseCall(frame *Frame, instr *ssa.CallCommon) (llvm.Value, error) {
@@ -1248,7 +1289,9 @@ func (c *Compiler) parseCall(frame *Frame, instr *ssa.CallCommon) (llvm.Value, e
 		if err != nil {
 			return llvm.Value{}, err
 		}
-		return c.createCall(fnCast, args, ""), nil
+		result := c.createCall(fnCast, args, "")
+		c.emitPanicCheck(frame)
+		return result, nil
 	}
 
 	// Try to call the function directly for trivially static calls.
@@ -1397,7 +1440,17 @@ func (c *Compiler) parseCall(frame *Frame, instr *ssa.CallCommon) (llvm.Value, e
 	// Builtin or function pointer.
 	switch call := instr.Value.(type) {
 	case *ssa.Builtin:
-		return c.parseBuiltin(frame, instr.Args, call.Name(), instr.Pos())
+		var argTypes []types.Type
+		var argValues []llvm.Value
+		for _, arg := range instr.Args {
+			value, err := c.parseExpr(frame, arg)
+			if err != nil {
+				return llvm.Value{}, err
+			}
+			argTypes = append(argTypes, arg.Type())
+			argValues = append(argValues, value)
+		}
+		return c.parseBuiltin(frame, argTypes, argValues, call.Name(), instr.Pos())
 	default: // function pointer
 		value, err := c.parseExpr(frame, instr.Value)
 		if err != nil {
@@ -1437,9 +1490,11 @@ func (c *Compiler) parseExpr(frame *Frame, expr ssa.Value) (llvm.Value, error) {
 				// Size would be truncated if truncated to uintptr.
 				return llvm.Value{}, c.makeError(expr.Pos(), fmt.Sprintf("value is too big (%v bytes)", size))
 			}
-			// TODO: escape analysis
+			// Allocate on the heap for now. The escape analysis in
+			// OptimizeAllocs will move it to the stack when possible.
 			sizeValue := llvm.ConstInt(c.uintptrType, size, false)
 			buf = c.createRuntimeCall("alloc", []llvm.Value{sizeValue}, expr.Comment)
+			c.heapAllocs[buf] = expr.Pos()
 			buf = c.builder.CreateBitCast(buf, llvm.PointerType(typ, 0), "")
 		} else {
 			buf = c.builder.CreateAlloca(typ, expr.Comment)
@@ -1461,7 +1516,17 @@ func (c *Compiler) parseExpr(frame *Frame, expr ssa.Value) (llvm.Value, error) {
 		if err != nil {
 			return llvm.Value{}, err
 		}
-		return c.parseBinOp(expr.Op, expr.X.Type(), x, y, expr.Pos())
+		result, err := c.parseBinOp(expr.Op, expr.X.Type(), x, y, expr.Pos())
+		if err != nil {
+			return llvm.Value{}, err
+		}
+		switch expr.X.Type().Underlying().(type) {
+		case *types.Interface, *types.Struct, *types.Array:
+			// Comparing interfaces (possibly inside a struct or array) panics
+			// when their dynamic type is not comparable.
+			c.emitPanicCheck(frame)
+		}
+		return result, nil
 	case *ssa.Call:
 		// Passing the current task here to the subroutine. It is only used when
 		// the subroutine is blocking.
@@ -1646,12 +1711,12 @@ func (c *Compiler) parseExpr(frame *Frame, expr ssa.Value) (llvm.Value, error) {
 			if expr.CommaOk {
 				valueType = valueType.(*types.Tuple).At(0).Type()
 			}
-			return c.emitMapLookup(xType.Key(), valueType, value, index, expr.CommaOk, expr.Pos())
+			return c.emitMapLookup(frame, xType.Key(), valueType, value, index, expr.CommaOk, expr.Pos())
 		default:
 			panic("unknown lookup type: " + expr.String())
 		}
 	case *ssa.MakeChan:
-		return c.emitMakeChan(expr)
+		return c.emitMakeChan(frame, expr)
 	case *ssa.MakeClosure:
 		// A closure returns a function pointer with context:
 		// {context, fp}
@@ -1735,16 +1800,18 @@ func (c *Compiler) parseExpr(frame *Frame, expr ssa.Value) (llvm.Value, error) {
 				maxSliceSize = llvm.ConstSDiv(maxSize, llvm.ConstInt(c.uintptrType, elemSize, false))
 			}
 			c.createRuntimeCall(checkFunc, []llvm.Value{sliceLen, sliceCap, maxSliceSize}, "")
+			c.emitPanicCheck(frame)
 		}
 
-		// Allocate the backing array.
-		// TODO: escape analysis
+		// Allocate the backing array. It may be moved to the stack by
+		// OptimizeAllocs if it is small and has a constant size.
 		sliceCapCast, err := c.parseConvert(expr.Cap.Type(), types.Typ[types.Uintptr], sliceCap, expr.Pos())
 		if err != nil {
 			return llvm.Value{}, err
 		}
 		sliceSize := c.builder.CreateBinOp(llvm.Mul, elemSizeValue, sliceCapCast, "makeslice.cap")
 		slicePtr := c.createRuntimeCall("alloc", []llvm.Value{sliceSize}, "makeslice.buf")
+		c.heapAllocs[slicePtr] = expr.Pos()
 		slicePtr = c.builder.CreateBitCast(slicePtr, llvm.PointerType(llvmElemType, 0), "makeslice.array")
 
 		if c.targetData.TypeAllocSize(sliceLen.Type()) > c.targetData.TypeAllocSize(c.uintptrType) {
@@ -1788,7 +1855,10 @@ func (c *Compiler) parseExpr(frame *Frame, expr ssa.Value) (llvm.Value, error) {
 			mapKeyPtr := c.builder.CreateBitCast(mapKeyAlloca, c.i8ptrType, "range.keyptr")
 			mapValueAlloca := c.builder.CreateAlloca(llvmValueType, "range.value")
 			mapValuePtr := c.builder.CreateBitCast(mapValueAlloca, c.i8ptrType, "range.valueptr")
-			ok := c.createRuntimeCall("hashmapNext", []llvm.Value{llvmRangeVal, it, mapKeyPtr, mapValuePtr}, "range.next")
+			ok, err := c.emitMapNext(frame, rangeVal.Type().Underlying().(*types.Map).Key(), llvmRangeVal, it, mapKeyPtr, mapValuePtr, expr.Pos())
+			if err != nil {
+				return llvm.Value{}, err
+			}
 
 			tuple := llvm.Undef(c.ctx.StructType([]llvm.Type{c.ctx.Int1Type(), llvmKeyType, llvmValueType}, false))
 			tuple = c.builder.CreateInsertValue(tuple, ok, 0, "")
@@ -1821,10 +1891,9 @@ func (c *Compiler) parseExpr(frame *Frame, expr ssa.Value) (llvm.Value, error) {
 		}
 		c.builder.CreateStore(zero, it)
 		return it, nil
+	case *ssa.Select:
+		return c.emitSelect(frame, expr)
 	case *ssa.Slice:
-		if expr.Max != nil {
-			return llvm.Value{}, c.makeError(expr.Pos(), "todo: full slice expressions (with max): "+expr.Type().String())
-		}
 		value, err := c.parseExpr(frame, expr.X)
 		if err != nil {
 			return llvm.Value{}, err
@@ -1868,6 +1937,25 @@ func (c *Compiler) parseExpr(frame *Frame, expr ssa.Value) (llvm.Value, error) {
 			highType = types.Typ[types.Uintptr]
 		}
 
+		var maxType *types.Basic
+		var max llvm.Value
+		if expr.Max != nil {
+			maxType = expr.Max.Type().Underlying().(*types.Basic)
+			max, err = c.parseExpr(frame, expr.Max)
+			if err != nil {
+				return llvm.Value{}, err
+			}
+			if max.Type().IntTypeWidth() < c.uintptrType.IntTypeWidth() {
+				if maxType.Info()&types.IsUnsigned != 0 {
+					max = c.builder.CreateZExt(max, c.uintptrType, "")
+				} else {
+					max = c.builder.CreateSExt(max, c.uintptrType, "")
+				}
+			}
+		} else {
+			maxType = types.Typ[types.Uintptr]
+		}
+
 		switch typ := expr.X.Type().Underlying().(type) {
 		case *types.Pointer: // pointer to array
 			// slice an array
@@ -1876,12 +1964,15 @@ func (c *Compiler) parseExpr(frame *Frame, expr ssa.Value) (llvm.Value, error) {
 			if high.IsNil() {
 				high = llvmLen
 			}
+			if max.IsNil() {
+				max = llvmLen
+			}
 			indices := []llvm.Value{
 				llvm.ConstInt(c.ctx.Int32Type(), 0, false),
 				low,
 			}
 
-			c.emitSliceBoundsCheck(frame, llvmLen, low, high, lowType, highType)
+			c.emitSliceBoundsCheck(frame, llvmLen, low, high, max, lowType, highType, maxType)
 
 			if c.targetData.TypeAllocSize(high.Type()) > c.targetData.TypeAllocSize(c.uintptrType) {
 				high = c.builder.CreateTrunc(high, c.uintptrType, "")
@@ -1889,10 +1980,13 @@ func (c *Compiler) parseExpr(frame *Frame, expr ssa.Value) (llvm.Value, error) {
 			if c.targetData.TypeAllocSize(low.Type()) > c.targetData.TypeAllocSize(c.uintptrType) {
 				low = c.builder.CreateTrunc(low, c.uintptrType, "")
 			}
+			if c.targetData.TypeAllocSize(max.Type()) > c.targetData.TypeAllocSize(c.uintptrType) {
+				max = c.builder.CreateTrunc(max, c.uintptrType, "")
+			}
 
 			sliceLen := c.builder.CreateSub(high, low, "slice.len")
 			slicePtr := c.builder.CreateGEP(value, indices, "slice.ptr")
-			sliceCap := c.builder.CreateSub(llvmLen, low, "slice.cap")
+			sliceCap := c.builder.CreateSub(max, low, "slice.cap")
 
 			slice := c.ctx.ConstStruct([]llvm.Value{
 				llvm.Undef(slicePtr.Type()),
@@ -1912,8 +2006,11 @@ func (c *Compiler) parseExpr(frame *Frame, expr ssa.Value) (llvm.Value, error) {
 			if high.IsNil() {
 				high = oldLen
 			}
+			if max.IsNil() {
+				max = oldCap
+			}
 
-			c.emitSliceBoundsCheck(frame, oldCap, low, high, lowType, highType)
+			c.emitSliceBoundsCheck(frame, oldCap, low, high, max, lowType, highType, maxType)
 
 			if c.targetData.TypeAllocSize(low.Type()) > c.targetData.TypeAllocSize(c.uintptrType) {
 				low = c.builder.CreateTrunc(low, c.uintptrType, "")
@@ -1921,10 +2018,13 @@ func (c *Compiler) parseExpr(frame *Frame, expr ssa.Value) (llvm.Value, error) {
 			if c.targetData.TypeAllocSize(high.Type()) > c.targetData.TypeAllocSize(c.uintptrType) {
 				high = c.builder.CreateTrunc(high, c.uintptrType, "")
 			}
+			if c.targetData.TypeAllocSize(max.Type()) > c.targetData.TypeAllocSize(c.uintptrType) {
+				max = c.builder.CreateTrunc(max, c.uintptrType, "")
+			}
 
 			newPtr := c.builder.CreateGEP(oldPtr, []llvm.Value{low}, "")
 			newLen := c.builder.CreateSub(high, low, "")
-			newCap := c.builder.CreateSub(oldCap, low, "")
+			newCap := c.builder.CreateSub(max, low, "")
 			slice := c.ctx.ConstStruct([]llvm.Value{
 				llvm.Undef(newPtr.Type()),
 				llvm.Undef(c.uintptrType),
@@ -1946,7 +2046,7 @@ func (c *Compiler) parseExpr(frame *Frame, expr ssa.Value) (llvm.Value, error) {
 				high = oldLen
 			}
 
-			c.emitSliceBoundsCheck(frame, oldLen, low, high, lowType, highType)
+			c.emitSliceBoundsCheck(frame, oldLen, low, high, oldLen, lowType, highType, maxType)
 
 			newPtr := c.builder.CreateGEP(oldPtr, []llvm.Value{low}, "")
 			newLen := c.builder.CreateSub(high, low, "")
@@ -2075,7 +2175,7 @@ func (c *Compiler) parseBinOp(op token.Token, typ types.Type, x, y llvm.Value, p
 			case token.QUO: // /
 				return c.builder.CreateFDiv(x, y, ""), nil
 			case token.EQL: // ==
-				return c.builder.CreateFCmp(llvm.FloatUEQ, x, y, ""), nil
+				return c.builder.CreateFCmp(llvm.FloatOEQ, x, y, ""), nil
 			case token.NEQ: // !=
 				return c.builder.CreateFCmp(llvm.FloatUNE, x, y, ""), nil
 			case token.LSS: // <
@@ -2104,8 +2204,29 @@ func (c *Compiler) parseBinOp(op token.Token, typ types.Type, x, y llvm.Value, p
 				ieq := c.builder.CreateFCmp(llvm.FloatOEQ, i1, i2, "")
 				neq := c.builder.CreateAnd(req, ieq, "")
 				return c.builder.CreateNot(neq, ""), nil
+			case token.ADD: // +
+				r := c.builder.CreateFAdd(r1, r2, "")
+				i := c.builder.CreateFAdd(i1, i2, "")
+				return c.createComplex(x.Type(), r, i), nil
+			case token.SUB: // -
+				r := c.builder.CreateFSub(r1, r2, "")
+				i := c.builder.CreateFSub(i1, i2, "")
+				return c.createComplex(x.Type(), r, i), nil
+			case token.MUL: // *
+				// (r1 + i1*i) * (r2 + i2*i) = (r1*r2 - i1*i2) + (r1*i2 + i1*r2)*i
+				r := c.builder.CreateFSub(c.builder.CreateFMul(r1, r2, ""), c.builder.CreateFMul(i1, i2, ""), "")
+				i := c.builder.CreateFAdd(c.builder.CreateFMul(r1, i2, ""), c.builder.CreateFMul(i1, r2, ""), "")
+				return c.createComplex(x.Type(), r, i), nil
+			case token.QUO: // /
+				// Division needs special care for infinities and NaNs, so
+				// leave it to the runtime.
+				if typ.Kind() == types.Complex64 {
+					return c.createRuntimeCall("complex64div", []llvm.Value{x, y}, ""), nil
+				} else {
+					return c.createRuntimeCall("complex128div", []llvm.Value{x, y}, ""), nil
+				}
 			default:
-				return llvm.Value{}, c.makeError(pos, "todo: binop on complex number: "+op.String())
+				return llvm.Value{}, c.makeError(pos, "binop on complex number: "+op.String())
 			}
 		} else if typ.Info()&types.IsBoolean != 0 {
 			// Operations on booleans
@@ -2179,10 +2300,12 @@ func (c *Compiler) parseBinOp(op token.Token, typ types.Type, x, y llvm.Value, p
 		default:
 			return llvm.Value{}, c.makeError(pos, "binop on interface: "+op.String())
 		}
-	case *types.Map, *types.Pointer:
+	case *types.Chan, *types.Map, *types.Pointer:
 		// Maps are in general not comparable, but can be compared against nil
 		// (which is a nil pointer). This means they can be trivially compared
 		// by treating them as a pointer.
+		// Channels are pointers to the channel struct, so they can be
+		// compared in the same way.
 		switch op {
 		case token.EQL: // ==
 			return c.builder.CreateICmp(llvm.IntEQ, x, y, ""), nil
@@ -2311,10 +2434,7 @@ func (c *Compiler) parseConst(prefix string, expr *ssa.Const) (llvm.Value, error
 			if err != nil {
 				return llvm.Value{}, err
 			}
-			cplx := llvm.Undef(c.ctx.StructType([]llvm.Type{c.ctx.FloatType(), c.ctx.FloatType()}, false))
-			cplx = c.builder.CreateInsertValue(cplx, r, 0, "")
-			cplx = c.builder.CreateInsertValue(cplx, i, 1, "")
-			return cplx, nil
+			return c.createComplex(c.ctx.StructType([]llvm.Type{c.ctx.FloatType(), c.ctx.FloatType()}, false), r, i), nil
 		} else if typ.Kind() == types.Complex128 {
 			r, err := c.parseConst(prefix, ssa.NewConst(constant.Real(expr.Value), types.Typ[types.Float64]))
 			if err != nil {
@@ -2324,10 +2444,7 @@ func (c *Compiler) parseConst(prefix string, expr *ssa.Const) (llvm.Value, error
 			if err != nil {
 				return llvm.Value{}, err
 			}
-			cplx := llvm.Undef(c.ctx.StructType([]llvm.Type{c.ctx.DoubleType(), c.ctx.DoubleType()}, false))
-			cplx = c.builder.CreateInsertValue(cplx, r, 0, "")
-			cplx = c.builder.CreateInsertValue(cplx, i, 1, "")
-			return cplx, nil
+			return c.createComplex(c.ctx.StructType([]llvm.Type{c.ctx.DoubleType(), c.ctx.DoubleType()}, false), r, i), nil
 		} else {
 			return llvm.Value{}, errors.New("todo: unknown constant: " + expr.String())
 		}
@@ -2397,6 +2514,15 @@ func (c *Compiler) parseConst(prefix string, expr *ssa.Const) (llvm.Value, error
 	}
 }
 
+// createComplex creates a complex number of the given LLVM type ({float,
+// float} or {double, double}) from a real and an imaginary part.
+func (c *Compiler) createComplex(typ llvm.Type, r, i llvm.Value) llvm.Value {
+	cplx := llvm.Undef(typ)
+	cplx = c.builder.CreateInsertValue(cplx, r, 0, "")
+	cplx = c.builder.CreateInsertValue(cplx, i, 1, "")
+	return cplx
+}
+
 func (c *Compiler) parseConvert(typeFrom, typeTo types.Type, value llvm.Value, pos token.Pos) (llvm.Value, error) {
 	llvmTypeFrom := value.Type()
 	llvmTypeTo, err := c.getLLVMType(typeTo)
@@ -2430,17 +2556,26 @@ func (c *Compiler) parseConvert(typeFrom, typeTo types.Type, value llvm.Value, p
 				// Cast to an i32 value as expected by
 				// runtime.stringFromUnicode.
 				if sizeFrom > 4 {
+					// Values that don't fit in a rune are not valid code
+					// points, and must result in "\uFFFD". Replace them
+					// before truncating so they can't alias a valid code
+					// point. Negative values are also caught by this unsigned
+					// comparison.
+					tooBig := c.builder.CreateICmp(llvm.IntUGT, value, llvm.ConstInt(value.Type(), 0x10ffff, false), "")
+					value = c.builder.CreateSelect(tooBig, llvm.ConstInt(value.Type(), 0xfffd, false), value, "")
 					value = c.builder.CreateTrunc(value, c.ctx.Int32Type(), "")
-				} else if sizeFrom < 4 && typeTo.Info()&types.IsUnsigned != 0 {
+				} else if sizeFrom < 4 && typeFrom.Info()&types.IsUnsigned != 0 {
 					value = c.builder.CreateZExt(value, c.ctx.Int32Type(), "")
 				} else if sizeFrom < 4 {
 					value = c.builder.CreateSExt(value, c.ctx.Int32Type(), "")
 				}
 				return c.createRuntimeCall("stringFromUnicode", []llvm.Value{value}, ""), nil
 			case *types.Slice:
-				switch typeFrom.Elem().(*types.Basic).Kind() {
+				switch typeFrom.Elem().Underlying().(*types.Basic).Kind() {
 				case types.Byte:
 					return c.createRuntimeCall("stringFromBytes", []llvm.Value{value}, ""), nil
+				case types.Rune:
+					return c.createRuntimeCall("stringFromRunes", []llvm.Value{value}, ""), nil
 				default:
 					return llvm.Value{}, c.makeError(pos, "todo: convert to string: "+typeFrom.String())
 				}
@@ -2498,10 +2633,7 @@ func (c *Compiler) parseConvert(typeFrom, typeTo types.Type, value llvm.Value, p
 			i := c.builder.CreateExtractValue(value, 1, "imag.f64")
 			r = c.builder.CreateFPTrunc(r, c.ctx.FloatType(), "real.f32")
 			i = c.builder.CreateFPTrunc(i, c.ctx.FloatType(), "imag.f32")
-			cplx := llvm.Undef(c.ctx.StructType([]llvm.Type{c.ctx.FloatType(), c.ctx.FloatType()}, false))
-			cplx = c.builder.CreateInsertValue(cplx, r, 0, "")
-			cplx = c.builder.CreateInsertValue(cplx, i, 1, "")
-			return cplx, nil
+			return c.createComplex(llvmTypeTo, r, i), nil
 		}
 
 		if typeFrom.Kind() == types.Complex64 && typeTo.Kind() == types.Complex128 {
@@ -2510,10 +2642,13 @@ func (c *Compiler) parseConvert(typeFrom, typeTo types.Type, value llvm.Value, p
 			i := c.builder.CreateExtractValue(value, 1, "imag.f32")
 			r = c.builder.CreateFPExt(r, c.ctx.DoubleType(), "real.f64")
 			i = c.builder.CreateFPExt(i, c.ctx.DoubleType(), "imag.f64")
-			cplx := llvm.Undef(c.ctx.StructType([]llvm.Type{c.ctx.DoubleType(), c.ctx.DoubleType()}, false))
-			cplx = c.builder.CreateInsertValue(cplx, r, 0, "")
-			cplx = c.builder.CreateInsertValue(cplx, i, 1, "")
-			return cplx, nil
+			return c.createComplex(llvmTypeTo, r, i), nil
+		}
+
+		if typeFrom.Info()&types.IsComplex != 0 && typeFrom.Kind() == typeTo.Kind() {
+			// Conversion between two complex types of the same size, for
+			// example to or from a named type.
+			return value, nil
 		}
 
 		return llvm.Value{}, c.makeError(pos, "todo: convert: basic non-integer type: "+typeFrom.String()+" -> "+typeTo.String())
@@ -2527,6 +2662,8 @@ func (c *Compiler) parseConvert(typeFrom, typeTo types.Type, value llvm.Value, p
 		switch elemType.Kind() {
 		case types.Byte:
 			return c.createRuntimeCall("stringToBytes", []llvm.Value{value}, ""), nil
+		case types.Rune:
+			return c.createRuntimeCall("stringToRunes", []llvm.Value{value}, ""), nil
 		default:
 			return llvm.Value{}, c.makeError(pos, "todo: convert from string: "+elemType.String())
 		}
@@ -2570,6 +2707,7 @@ func (c *Compiler) parseMakeClosure(frame *Frame, expr *ssa.MakeClosure) (llvm.V
 		size := c.targetData.TypeAllocSize(contextType)
 		sizeValue := llvm.ConstInt(c.uintptrType, size, false)
 		contextHeapAlloc = c.createRuntimeCall("alloc", []llvm.Value{sizeValue}, "")
+		c.heapAllocs[contextHeapAlloc] = expr.Pos()
 		contextAlloc = c.builder.CreateBitCast(contextHeapAlloc, llvm.PointerType(contextType, 0), "")
 	}
 
@@ -2625,6 +2763,12 @@ func (c *Compiler) parseUnOp(frame *Frame, unop *ssa.UnOp) (llvm.Value, error) {
 				return c.builder.CreateSub(llvm.ConstInt(x.Type(), 0, false), x, ""), nil
 			} else if typ.Info()&types.IsFloat != 0 {
 				return c.builder.CreateFSub(llvm.ConstFloat(x.Type(), 0.0), x, ""), nil
+			} else if typ.Info()&types.IsComplex != 0 {
+				r := c.builder.CreateExtractValue(x, 0, "real")
+				i := c.builder.CreateExtractValue(x, 1, "imag")
+				r = c.builder.CreateFSub(llvm.ConstFloat(r.Type(), 0.0), r, "real.neg")
+				i = c.builder.CreateFSub(llvm.ConstFloat(i.Type(), 0.0), i, "imag.neg")
+				return c.createComplex(x.Type(), r, i), nil
 			} else {
 				return llvm.Value{}, c.makeError(unop.Pos(), "todo: unknown basic type for negate: "+typ.String())
 			}
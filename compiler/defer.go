@@ -12,8 +12,12 @@ package compiler
 //   * On return, runtime.rundefers is called which calls all deferred functions
 //     from the head of the linked list until it has gone through all defer
 //     frames.
+//   * The same happens when a panic passes through this function, when
+//     unwinding is enabled. See panic.go for details.
 
 import (
+	"go/types"
+
 	"github.com/tinygo-org/tinygo/ir"
 	"golang.org/x/tools/go/ssa"
 	"tinygo.org/x/go-llvm"
@@ -46,8 +50,9 @@ func (c *Compiler) emitDefer(frame *Frame, instr *ssa.Defer) error {
 	if instr.Call.IsInvoke() {
 		// Method call on an interface.
 
-		// Get callback type number.
-		methodName := instr.Call.Method.FullName()
+		// Get callback type number. The method set of the interface type is
+		// used to look up the method, so it is part of the key.
+		methodName := instr.Call.Value.Type().String() + "." + instr.Call.Method.FullName()
 		if _, ok := frame.deferInvokeFuncs[methodName]; !ok {
 			frame.deferInvokeFuncs[methodName] = len(frame.allDeferFuncs)
 			frame.allDeferFuncs = append(frame.allDeferFuncs, &instr.Call)
@@ -55,14 +60,15 @@ func (c *Compiler) emitDefer(frame *Frame, instr *ssa.Defer) error {
 		callback := llvm.ConstInt(c.uintptrType, uint64(frame.deferInvokeFuncs[methodName]), false)
 
 		// Collect all values to be put in the struct (starting with
-		// runtime._defer fields, followed by the call parameters).
+		// runtime._defer fields, followed by the interface value and the call
+		// parameters). The whole interface is stored, as the method to call
+		// depends on its dynamic type.
 		itf, err := c.parseExpr(frame, instr.Call.Value) // interface
 		if err != nil {
 			return err
 		}
-		receiverValue := c.builder.CreateExtractValue(itf, 1, "invoke.func.receiver")
-		values = []llvm.Value{callback, next, receiverValue}
-		valueTypes = append(valueTypes, c.i8ptrType)
+		values = []llvm.Value{callback, next, itf}
+		valueTypes = append(valueTypes, itf.Type())
 		for _, arg := range instr.Call.Args {
 			val, err := c.parseExpr(frame, arg)
 			if err != nil {
@@ -130,8 +136,48 @@ func (c *Compiler) emitDefer(frame *Frame, instr *ssa.Defer) error {
 		values = append(values, context)
 		valueTypes = append(valueTypes, context.Type())
 
+	} else if _, ok := instr.Call.Value.(*ssa.Builtin); ok {
+		// Builtin function, like close(ch) or println(...). Every defer
+		// instruction gets its own callback, as the emitted code depends on
+		// the argument types.
+		callback := llvm.ConstInt(c.uintptrType, uint64(len(frame.allDeferFuncs)), false)
+		frame.allDeferFuncs = append(frame.allDeferFuncs, &instr.Call)
+
+		// Collect all values to be put in the struct (starting with
+		// runtime._defer fields, followed by the call parameters).
+		values = []llvm.Value{callback, next}
+		for _, arg := range instr.Call.Args {
+			val, err := c.parseExpr(frame, arg)
+			if err != nil {
+				return err
+			}
+			values = append(values, val)
+			valueTypes = append(valueTypes, val.Type())
+		}
+
 	} else {
-		return c.makeError(instr.Pos(), "todo: defer on uncommon function call type")
+		// Function value: a closure, bound method or function pointer that is
+		// only known at runtime.
+		callback := llvm.ConstInt(c.uintptrType, uint64(len(frame.allDeferFuncs)), false)
+		frame.allDeferFuncs = append(frame.allDeferFuncs, &instr.Call)
+
+		// Collect all values to be put in the struct (starting with
+		// runtime._defer fields, followed by the function value and the call
+		// parameters).
+		closure, err := c.parseExpr(frame, instr.Call.Value)
+		if err != nil {
+			return err
+		}
+		values = []llvm.Value{callback, next, closure}
+		valueTypes = append(valueTypes, closure.Type())
+		for _, arg := range instr.Call.Args {
+			val, err := c.parseExpr(frame, arg)
+			if err != nil {
+				return err
+			}
+			values = append(values, val)
+			valueTypes = append(valueTypes, val.Type())
+		}
 	}
 
 	// Make a struct out of the collected values to put in the defer frame.
@@ -154,8 +200,10 @@ func (c *Compiler) emitDefer(frame *Frame, instr *ssa.Defer) error {
 	return nil
 }
 
-// emitRunDefers emits code to run all deferred functions.
-func (c *Compiler) emitRunDefers(frame *Frame) error {
+// emitRunDefers emits code to run all deferred functions. When the function is
+// unwinding the stack because of a panic, each deferred function is marked as
+// such right before it is called, so that it can call recover().
+func (c *Compiler) emitRunDefers(frame *Frame, unwinding bool) error {
 	// Add a loop like the following:
 	//     for stack != nil {
 	//         _stack := stack
@@ -212,13 +260,20 @@ func (c *Compiler) emitRunDefers(frame *Frame) error {
 		c.builder.SetInsertPointAtEnd(block)
 		switch callback := callback.(type) {
 		case *ssa.CallCommon:
-			// Call on an interface value.
-			if !callback.IsInvoke() {
-				panic("expected an invoke call, not a direct call")
-			}
+			// Call on an interface value, a builtin or a function value.
 
-			// Get the real defer struct type and cast to it.
-			valueTypes := []llvm.Type{c.uintptrType, llvm.PointerType(c.mod.GetTypeByName("runtime._defer"), 0), c.i8ptrType}
+			// Get the real defer struct type and cast to it. The first field
+			// after the runtime._defer fields is the interface or function
+			// value, except for builtins.
+			valueTypes := []llvm.Type{c.uintptrType, llvm.PointerType(c.mod.GetTypeByName("runtime._defer"), 0)}
+			_, isBuiltin := callback.Value.(*ssa.Builtin)
+			if !isBuiltin {
+				llvmType, err := c.getLLVMType(callback.Value.Type())
+				if err != nil {
+					return err
+				}
+				valueTypes = append(valueTypes, llvmType)
+			}
 			for _, arg := range callback.Args {
 				llvmType, err := c.getLLVMType(arg.Type())
 				if err != nil {
@@ -229,28 +284,70 @@ func (c *Compiler) emitRunDefers(frame *Frame) error {
 			deferFrameType := c.ctx.StructType(valueTypes, false)
 			deferFramePtr := c.builder.CreateBitCast(deferData, llvm.PointerType(deferFrameType, 0), "deferFrame")
 
-			// Extract the params from the struct (including receiver).
-			forwardParams := []llvm.Value{}
+			// Extract the values from the struct.
+			values := []llvm.Value{}
 			zero := llvm.ConstInt(c.ctx.Int32Type(), 0, false)
 			for i := 2; i < len(valueTypes); i++ {
 				gep := c.builder.CreateGEP(deferFramePtr, []llvm.Value{zero, llvm.ConstInt(c.ctx.Int32Type(), uint64(i), false)}, "gep")
-				forwardParam := c.builder.CreateLoad(gep, "param")
-				forwardParams = append(forwardParams, forwardParam)
+				value := c.builder.CreateLoad(gep, "param")
+				values = append(values, value)
 			}
 
-			// Add the context parameter. An interface call cannot also be a
-			// closure but we have to supply the parameter anyway for platforms
-			// with a strict calling convention.
-			forwardParams = append(forwardParams, llvm.Undef(c.i8ptrType))
+			if isBuiltin {
+				// Call the builtin with the stored arguments. A deferred
+				// recover() is not called directly by a deferred function, so
+				// it never stops a panic and can be left out entirely.
+				builtin := callback.Value.(*ssa.Builtin)
+				if builtin.Name() != "recover" {
+					argTypes := make([]types.Type, len(callback.Args))
+					for i, arg := range callback.Args {
+						argTypes[i] = arg.Type()
+					}
+					_, err := c.parseBuiltin(frame, argTypes, values, builtin.Name(), callback.Pos())
+					if err != nil {
+						return err
+					}
+					c.emitPanicCheck(frame)
+				}
+				break
+			}
+
+			var fnPtr llvm.Value
+			forwardParams := values[1:]
+			if callback.IsInvoke() {
+				// Look up the method using the dynamic type of the stored
+				// interface value and pass the receiver as first parameter.
+				itf := values[0]
+				var err error
+				fnPtr, err = c.getInvokeFunc(itf, callback)
+				if err != nil {
+					return err
+				}
+				receiverValue := c.builder.CreateExtractValue(itf, 1, "invoke.func.receiver")
+				forwardParams = append([]llvm.Value{receiverValue}, forwardParams...)
+
+				// Add the context parameter. An interface call cannot also be
+				// a closure but we have to supply the parameter anyway for
+				// platforms with a strict calling convention.
+				forwardParams = append(forwardParams, llvm.Undef(c.i8ptrType))
+			} else {
+				// Function value, which is a {context, function pointer}
+				// closure. A nil function value only panics when it is
+				// called, not when the defer statement is executed.
+				context := c.builder.CreateExtractValue(values[0], 0, "")
+				fnPtr = c.builder.CreateExtractValue(values[0], 1, "")
+				c.emitNilCheck(frame, fnPtr, "fpcall")
+				forwardParams = append(forwardParams, context)
+			}
 
 			// Parent coroutine handle.
 			forwardParams = append(forwardParams, llvm.Undef(c.i8ptrType))
 
-			fnPtr, _, err := c.getInvokeCall(frame, callback)
-			if err != nil {
-				return err
+			if unwinding {
+				c.emitDeferCallStart(fnPtr)
 			}
 			c.createCall(fnPtr, forwardParams, "")
+			c.emitPanicCheck(frame)
 
 		case *ir.Function:
 			// Direct call.
@@ -284,7 +381,11 @@ func (c *Compiler) emitRunDefers(frame *Frame) error {
 			forwardParams = append(forwardParams, llvm.Undef(c.i8ptrType))
 
 			// Call real function.
+			if unwinding {
+				c.emitDeferCallStart(callback.LLVMFn)
+			}
 			c.createCall(callback.LLVMFn, forwardParams, "")
+			c.emitPanicCheck(frame)
 
 		case *ssa.MakeClosure:
 			// Get the real defer struct type and cast to it.
@@ -315,7 +416,11 @@ func (c *Compiler) emitRunDefers(frame *Frame) error {
 			forwardParams = append(forwardParams, llvm.Undef(c.i8ptrType))
 
 			// Call deferred function.
+			if unwinding {
+				c.emitDeferCallStart(fn.LLVMFn)
+			}
 			c.createCall(fn.LLVMFn, forwardParams, "")
+			c.emitPanicCheck(frame)
 
 		default:
 			panic("unknown deferred function type")
@@ -334,5 +439,6 @@ func (c *Compiler) emitRunDefers(frame *Frame) error {
 
 	// End of loop.
 	c.builder.SetInsertPointAtEnd(end)
+	frame.blockExits[frame.currentBlock] = end // adjust outgoing block for phi nodes
 	return nil
 }
@@ -0,0 +1,229 @@
+package compiler
+
+// This file emits the function table, which is used by the runtime to print
+// stack traces and to implement runtime.Caller and runtime.FuncForPC. See
+// src/runtime/stack.go for how it is used.
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/tinygo-org/tinygo/ir"
+	"tinygo.org/x/go-llvm"
+)
+
+// Flags stored in the flags field of runtime.Func.
+const (
+	// funcFlagExported is set for functions that may be called from outside
+	// Go, such as the program entry point and interrupt handlers. They are at
+	// the bottom of a Go stack.
+	funcFlagExported = 1 << iota
+)
+
+// CreateFuncTable defines the runtime.funcTable global, if it is used by the
+// runtime. It contains the address, name and source position of every
+// function in the module, terminated by an all-zero entry. It also enables
+// frame pointers in all functions, so that the runtime can walk the stack.
+//
+// Additionally, it defines the runtime.lineTable global. When requested with
+// the StackLines option, it contains the address and source position of every
+// call in the program that has a debug location. As these are only present when
+// debug information is emitted, and emitting them means splitting basic blocks
+// at each call, only the line of the function declaration is known otherwise.
+//
+// This must be run after all optimizations, so that the table only refers to
+// functions that are still present in the program. The tables are left empty
+// when they are stripped or when the target doesn't support walking the stack
+// using frame pointers (AVR and WebAssembly).
+func (c *Compiler) CreateFuncTable() {
+	global := c.mod.NamedGlobal("runtime.funcTable")
+	if global.IsNil() {
+		return
+	}
+	funcType := global.Type().ElementType()
+	fieldTypes := funcType.StructElementTypes()
+	lineGlobal := c.mod.NamedGlobal("runtime.lineTable")
+
+	var table, lines []llvm.Value
+	if !c.NoFuncTable && !strings.HasPrefix(c.Triple, "avr") && !strings.HasPrefix(c.Triple, "wasm") {
+		functions := make(map[string]*ir.Function)
+		for _, f := range c.ir.Functions {
+			functions[f.LinkName()] = f
+		}
+		strs := make(map[string]llvm.Value)
+		framePointer := c.ctx.CreateStringAttribute("no-frame-pointer-elim", "true")
+		for fn := c.mod.FirstFunction(); !fn.IsNil(); fn = llvm.NextFunction(fn) {
+			if fn.IsDeclaration() {
+				continue
+			}
+			fn.AddFunctionAttr(framePointer)
+
+			// Coroutines are split into several functions during goroutine
+			// lowering, which are named after the original function.
+			name := strings.TrimSuffix(fn.Name(), ".resume")
+			filename := ""
+			line := 0
+			flags := 0
+			if f, ok := functions[name]; ok {
+				name = f.RelString(nil)
+				pos := c.ir.Program.Fset.Position(f.Pos())
+				filename = c.relativeFilename(pos.Filename)
+				line = pos.Line
+				if f.IsExported() {
+					flags |= funcFlagExported
+				}
+			}
+			file := llvm.ConstNull(fieldTypes[2])
+			if filename != "" {
+				file = c.getFuncTableString(filename, strs)
+			}
+			table = append(table, llvm.ConstNamedStruct(funcType, []llvm.Value{
+				llvm.ConstPtrToInt(fn, fieldTypes[0]),
+				c.getFuncTableString(name, strs),
+				file,
+				llvm.ConstInt(fieldTypes[3], uint64(line), false),
+				llvm.ConstInt(fieldTypes[4], uint64(flags), false),
+			}))
+			if !lineGlobal.IsNil() && c.StackLines {
+				lines = append(lines, c.createLineEntries(fn, lineGlobal.Type().ElementType(), strs)...)
+			}
+		}
+	}
+	c.replaceTable(global, table)
+	if !lineGlobal.IsNil() {
+		c.replaceTable(lineGlobal, lines)
+	}
+}
+
+// createLineEntries returns an entry for the line table for each call in the
+// given function that has a debug location. The basic block is split right
+// before each such call, so that the address of the call (or rather, of the
+// code that prepares its parameters) can be stored in the table.
+func (c *Compiler) createLineEntries(fn llvm.Value, lineType llvm.Type, strs map[string]llvm.Value) []llvm.Value {
+	fieldTypes := lineType.StructElementTypes()
+
+	// Collect all calls first, as splitting basic blocks moves instructions
+	// around.
+	var calls []llvm.Value
+	for bb := fn.FirstBasicBlock(); !bb.IsNil(); bb = llvm.NextBasicBlock(bb) {
+		for inst := bb.FirstInstruction(); !inst.IsNil(); inst = llvm.NextInstruction(inst) {
+			if inst.IsACallInst().IsNil() || inst.InstructionDebugLoc().IsNil() {
+				continue
+			}
+			callee := inst.CalledValue()
+			if !callee.IsAInlineAsm().IsNil() || strings.HasPrefix(callee.Name(), "llvm.") {
+				// Inline assembly and intrinsics are not real calls.
+				continue
+			}
+			calls = append(calls, inst)
+		}
+	}
+
+	// Don't let the builder overwrite the debug location of the instructions
+	// it moves into a new basic block.
+	c.builder.SetCurrentDebugLocation(0, 0, llvm.Metadata{}, llvm.Metadata{})
+
+	var entries []llvm.Value
+	for _, call := range calls {
+		// Use the position in the function that is in the function table, not
+		// the position inside a function that was inlined into it.
+		loc := call.InstructionDebugLoc()
+		for !loc.LocationInlinedAt().IsNil() {
+			loc = loc.LocationInlinedAt()
+		}
+		file := loc.LocationScope().ScopeFile()
+		if file.FileFilename() == "" || loc.LocationLine() == 0 {
+			continue
+		}
+		filename := c.relativeFilename(filepath.Join(file.FileDirectory(), file.FileFilename()))
+
+		var addr llvm.Value
+		block := call.InstructionParent()
+		prev := llvm.PrevInstruction(call)
+		switch {
+		case prev.IsNil() && block == fn.EntryBasicBlock():
+			// The address of the entry block can't be taken, but it is the
+			// same as the address of the function.
+			addr = fn
+		case prev.IsNil():
+			addr = llvm.BlockAddress(fn, block)
+		default:
+			callBlock := c.splitBasicBlock(prev, block, block.AsValue().Name()+".call")
+			c.builder.SetInsertPointAtEnd(block)
+			c.builder.CreateBr(callBlock)
+			addr = llvm.BlockAddress(fn, callBlock)
+		}
+
+		entries = append(entries, llvm.ConstNamedStruct(lineType, []llvm.Value{
+			llvm.ConstPtrToInt(addr, fieldTypes[0]),
+			c.getFuncTableString(filename, strs),
+			llvm.ConstInt(fieldTypes[2], uint64(loc.LocationLine()), false),
+		}))
+	}
+	return entries
+}
+
+// replaceTable replaces the given external runtime global with a constant
+// array of the given entries, terminated by an all-zero entry.
+func (c *Compiler) replaceTable(global llvm.Value, entries []llvm.Value) {
+	name := global.Name()
+	entryType := global.Type().ElementType()
+	entries = append(entries, llvm.ConstNull(entryType))
+
+	initializer := llvm.ConstArray(entryType, entries)
+	newGlobal := llvm.AddGlobal(c.mod, initializer.Type(), name+".tmp")
+	newGlobal.SetInitializer(initializer)
+	newGlobal.SetLinkage(llvm.InternalLinkage)
+	newGlobal.SetGlobalConstant(true)
+	global.ReplaceAllUsesWith(llvm.ConstBitCast(newGlobal, global.Type()))
+	global.EraseFromParentAsGlobal()
+	newGlobal.SetName(name)
+}
+
+// relativeFilename returns the file name as it is stored in the function
+// table. Files in the current working directory or in a source directory of
+// TinyGo, GOROOT or GOPATH are stored relative to that directory (e.g.
+// "fmt/print.go"), so that the absolute paths of the host system don't end up
+// in the program.
+func (c *Compiler) relativeFilename(filename string) string {
+	var dirs []string
+	if wd, err := os.Getwd(); err == nil {
+		dirs = append(dirs, wd)
+	}
+	dirs = append(dirs, filepath.Join(c.RootDir, "src"), filepath.Join(runtime.GOROOT(), "src"))
+	for _, dir := range filepath.SplitList(c.GOPATH) {
+		dirs = append(dirs, filepath.Join(dir, "src"))
+	}
+	for _, dir := range dirs {
+		if rel, err := filepath.Rel(dir, filename); err == nil && !strings.HasPrefix(rel, "..") {
+			return filepath.ToSlash(rel)
+		}
+	}
+	return filepath.ToSlash(filename)
+}
+
+// getFuncTableString returns a pointer to a global string with the given
+// contents, to be stored in the function table.
+func (c *Compiler) getFuncTableString(s string, strs map[string]llvm.Value) llvm.Value {
+	if value, ok := strs[s]; ok {
+		return value
+	}
+	buf := llvm.AddGlobal(c.mod, llvm.ArrayType(c.ctx.Int8Type(), len(s)), "runtime.funcTable$string")
+	buf.SetInitializer(c.ctx.ConstString(s, false))
+	buf.SetLinkage(llvm.InternalLinkage)
+	buf.SetGlobalConstant(true)
+	buf.SetUnnamedAddr(true)
+	zero := llvm.ConstInt(c.ctx.Int32Type(), 0, false)
+	strPtr := llvm.ConstInBoundsGEP(buf, []llvm.Value{zero, zero})
+	strLen := llvm.ConstInt(c.uintptrType, uint64(len(s)), false)
+	strType := c.mod.GetTypeByName("runtime._string")
+	str := llvm.AddGlobal(c.mod, strType, "runtime.funcTable.string")
+	str.SetInitializer(llvm.ConstNamedStruct(strType, []llvm.Value{strPtr, strLen}))
+	str.SetLinkage(llvm.InternalLinkage)
+	str.SetGlobalConstant(true)
+	str.SetUnnamedAddr(true)
+	strs[s] = str
+	return str
+}
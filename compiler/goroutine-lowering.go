@@ -60,9 +60,11 @@ package compiler
 //         llvm.suspend(hdl)                       // suspend point
 //         println("some other operation")
 //         bar(hdl)                                // await, pass a continuation (hdl) to bar
-//         llvm.suspend(hdl)                       // suspend point, wait for the callee to re-activate
+//         if !runtime.awaitTask() {               // bar didn't return right away
+//             llvm.suspend(hdl)                   // suspend point, wait for the callee to re-activate
+//         }
 //         println("done")
-//         runtime.activateTask(parent)            // re-activate the parent (nop, there is no parent)
+//         runtime.returnTask(hdl, parent)         // re-activate the parent (nop, there is no parent)
 //     }
 //
 //     func foo(parent) {
@@ -79,7 +81,7 @@ package compiler
 //         runtime.sleepTask(hdl, time.Second) // ask the scheduler to re-activate this coroutine at the right time
 //         llvm.suspend(hdl)                   // suspend point
 //         println("blocking operation completed)
-//         runtime.activateTask(parent)        // re-activate the parent coroutine before returning
+//         runtime.returnTask(hdl, parent)     // re-activate the parent coroutine before returning
 //     }
 //
 // The real LLVM code is more complicated, but this is the general idea.
@@ -106,7 +108,7 @@ type asyncFunc struct {
 // into one where all blocking functions are turned into goroutines and blocking
 // calls into await calls.
 func (c *Compiler) LowerGoroutines() error {
-	needsScheduler, err := c.markAsyncFunctions()
+	needsScheduler, mainIsAsync, err := c.markAsyncFunctions()
 	if err != nil {
 		return err
 	}
@@ -123,6 +125,11 @@ func (c *Compiler) LowerGoroutines() error {
 	realMain := c.mod.NamedFunction(c.ir.MainPkg().Pkg.Path() + ".main")
 	c.builder.CreateCall(realMain, []llvm.Value{llvm.Undef(c.i8ptrType), llvm.ConstPointerNull(c.i8ptrType)}, "")
 	if needsScheduler {
+		if !mainIsAsync {
+			// main.main has already returned when the scheduler starts. A
+			// blocking main.main reports this itself when it returns.
+			c.createRuntimeCall("mainReturned", nil, "")
+		}
 		c.createRuntimeCall("scheduler", nil, "")
 	}
 	mainCall.EraseFromParentAsInstruction()
@@ -144,8 +151,18 @@ func (c *Compiler) LowerGoroutines() error {
 	c.mod.NamedFunction("runtime.free").SetLinkage(llvm.InternalLinkage)
 	c.mod.NamedFunction("runtime.chanSend").SetLinkage(llvm.InternalLinkage)
 	c.mod.NamedFunction("runtime.chanRecv").SetLinkage(llvm.InternalLinkage)
+	c.mod.NamedFunction("runtime.chanSelect").SetLinkage(llvm.InternalLinkage)
+	c.mod.NamedFunction("runtime.chanSendNoScheduler").SetLinkage(llvm.InternalLinkage)
+	c.mod.NamedFunction("runtime.chanRecvNoScheduler").SetLinkage(llvm.InternalLinkage)
+	c.mod.NamedFunction("runtime.chanSelectNoScheduler").SetLinkage(llvm.InternalLinkage)
+	c.mod.NamedFunction("runtime.semacquire").SetLinkage(llvm.InternalLinkage)
+	c.mod.NamedFunction("runtime.semacquireNoScheduler").SetLinkage(llvm.InternalLinkage)
 	c.mod.NamedFunction("runtime.sleepTask").SetLinkage(llvm.InternalLinkage)
 	c.mod.NamedFunction("runtime.activateTask").SetLinkage(llvm.InternalLinkage)
+	c.mod.NamedFunction("runtime.returnTask").SetLinkage(llvm.InternalLinkage)
+	c.mod.NamedFunction("runtime.awaitTask").SetLinkage(llvm.InternalLinkage)
+	c.mod.NamedFunction("runtime.mainReturned").SetLinkage(llvm.InternalLinkage)
+	c.mod.NamedFunction("runtime.yieldPanic").SetLinkage(llvm.InternalLinkage)
 	c.mod.NamedFunction("runtime.scheduler").SetLinkage(llvm.InternalLinkage)
 
 	return nil
@@ -157,13 +174,16 @@ func (c *Compiler) LowerGoroutines() error {
 //
 // It does the following operations:
 //    * Find all blocking functions.
-//    * Determine whether a scheduler is necessary. If not, it skips the
-//      following operations.
+//    * Determine whether a scheduler is necessary. If not, it lowers blocking
+//      operations to variants that never block and skips the following
+//      operations.
 //    * Transform call instructions into await calls.
 //    * Transform return instructions into final suspends.
 //    * Set up the coroutine frames for async functions.
 //    * Transform blocking calls into their async equivalents.
-func (c *Compiler) markAsyncFunctions() (needsScheduler bool, err error) {
+//
+// It also returns whether main.main is a blocking function.
+func (c *Compiler) markAsyncFunctions() (needsScheduler, mainIsAsync bool, err error) {
 	var worklist []llvm.Value
 
 	sleep := c.mod.NamedFunction("time.Sleep")
@@ -178,10 +198,18 @@ func (c *Compiler) markAsyncFunctions() (needsScheduler bool, err error) {
 	if !chanRecvStub.IsNil() {
 		worklist = append(worklist, chanRecvStub)
 	}
+	chanSelectStub := c.mod.NamedFunction("runtime.chanSelectStub")
+	if !chanSelectStub.IsNil() {
+		worklist = append(worklist, chanSelectStub)
+	}
+	semacquireStub := c.mod.NamedFunction("runtime.semacquireStub")
+	if !semacquireStub.IsNil() {
+		worklist = append(worklist, semacquireStub)
+	}
 
 	if len(worklist) == 0 {
 		// There are no blocking operations, so no need to transform anything.
-		return false, c.lowerMakeGoroutineCalls()
+		return false, false, c.lowerMakeGoroutineCalls()
 	}
 
 	// Find all async functions.
@@ -189,8 +217,16 @@ func (c *Compiler) markAsyncFunctions() (needsScheduler bool, err error) {
 	// from the worklist and pushing all its parents that are non-async.
 	// This is somewhat similar to a worklist in a mark-sweep garbage collector:
 	// the work items are then grey objects.
+	//
+	// An async function may also be used as a function pointer (in a func
+	// value or in an interface method thunk). It is not known which function
+	// is called by an indirect call, so all indirect calls with the same
+	// function type are treated as async calls and all functions of this type
+	// that are used as a function pointer are made async.
 	asyncFuncs := make(map[llvm.Value]*asyncFunc)
 	asyncList := make([]llvm.Value, 0, 4)
+	asyncFuncTypes := make(map[llvm.Type]struct{})
+	var indirectCalls map[llvm.Type][]llvm.Value
 	for len(worklist) != 0 {
 		// Pick the topmost.
 		f := worklist[len(worklist)-1]
@@ -202,32 +238,38 @@ func (c *Compiler) markAsyncFunctions() (needsScheduler bool, err error) {
 		asyncFuncs[f] = &asyncFunc{}
 		asyncList = append(asyncList, f)
 
-		// Add all callees to the worklist.
-		for _, use := range getUses(f) {
-			if use.IsConstant() && use.Opcode() == llvm.BitCast {
-				bitcastUses := getUses(use)
-				for _, call := range bitcastUses {
-					if call.IsACallInst().IsNil() || call.CalledValue().Name() != "runtime.makeGoroutine" {
-						return false, errors.New("async function " + f.Name() + " incorrectly used in bitcast, expected runtime.makeGoroutine")
-					}
-				}
-				// This is a go statement. Do not mark the parent as async, as
-				// starting a goroutine is not a blocking operation.
+		// Add all callers to the worklist.
+		calls, isFuncPtr := getFunctionCalls(f)
+		for _, call := range calls {
+			worklist = append(worklist, call.InstructionParent().Parent())
+		}
+		if !isFuncPtr {
+			continue
+		}
+
+		// This function is used as a function pointer, so mark all indirect
+		// calls of this function type and all other functions that could be
+		// called by them as async.
+		if f.IsDeclaration() {
+			return false, false, errors.New("async function " + f.Name() + " used as function pointer")
+		}
+		if _, ok := asyncFuncTypes[f.Type()]; ok {
+			continue // already processed
+		}
+		asyncFuncTypes[f.Type()] = struct{}{}
+		if indirectCalls == nil {
+			indirectCalls = c.getIndirectCalls()
+		}
+		for _, call := range indirectCalls[f.Type()] {
+			worklist = append(worklist, call.InstructionParent().Parent())
+		}
+		for fn := c.mod.FirstFunction(); !fn.IsNil(); fn = llvm.NextFunction(fn) {
+			if fn.Type() != f.Type() || fn.IsDeclaration() {
 				continue
 			}
-			if use.IsACallInst().IsNil() {
-				// Not a call instruction. Maybe a store to a global? In any
-				// case, this requires support for async calls across function
-				// pointers which is not yet supported.
-				return false, errors.New("async function " + f.Name() + " used as function pointer")
+			if _, isFuncPtr := getFunctionCalls(fn); isFuncPtr {
+				worklist = append(worklist, fn)
 			}
-			parent := use.InstructionParent().Parent()
-			for i := 0; i < use.OperandsCount()-1; i++ {
-				if use.Operand(i) == f {
-					return false, errors.New("async function " + f.Name() + " used as function pointer in " + parent.Name())
-				}
-			}
-			worklist = append(worklist, parent)
 		}
 	}
 
@@ -260,7 +302,11 @@ func (c *Compiler) markAsyncFunctions() (needsScheduler bool, err error) {
 		// No scheduler is needed. Do not transform all functions here.
 		// However, make sure that all go calls (which are all non-async) are
 		// transformed into regular calls.
-		return false, c.lowerMakeGoroutineCalls()
+		c.lowerBlockingNoScheduler(chanSendStub, "runtime.chanSendNoScheduler")
+		c.lowerBlockingNoScheduler(chanRecvStub, "runtime.chanRecvNoScheduler")
+		c.lowerBlockingNoScheduler(chanSelectStub, "runtime.chanSelectNoScheduler")
+		c.lowerBlockingNoScheduler(semacquireStub, "runtime.semacquireNoScheduler")
+		return false, false, c.lowerMakeGoroutineCalls()
 	}
 
 	// Create a few LLVM intrinsics for coroutine support.
@@ -287,8 +333,10 @@ func (c *Compiler) markAsyncFunctions() (needsScheduler bool, err error) {
 	coroFreeFunc := llvm.AddFunction(c.mod, "llvm.coro.free", coroFreeType)
 
 	// Transform all async functions into coroutines.
+	realMain := c.mod.NamedFunction(c.ir.MainPkg().Pkg.Path() + ".main")
+	_, mainIsAsync = asyncFuncs[realMain]
 	for _, f := range asyncList {
-		if f == sleep || f == chanSendStub || f == chanRecvStub {
+		if f == sleep || f == chanSendStub || f == chanRecvStub || f == chanSelectStub || f == semacquireStub {
 			continue
 		}
 
@@ -305,7 +353,19 @@ func (c *Compiler) markAsyncFunctions() (needsScheduler bool, err error) {
 			for inst := bb.FirstInstruction(); !inst.IsNil(); inst = llvm.NextInstruction(inst) {
 				if !inst.IsACallInst().IsNil() {
 					callee := inst.CalledValue()
-					if _, ok := asyncFuncs[callee]; !ok || callee == sleep || callee == chanSendStub || callee == chanRecvStub {
+					if !callee.IsAConstantExpr().IsNil() && callee.Opcode() == llvm.BitCast {
+						// direct call of a bitcast function
+						callee = callee.Operand(0)
+					}
+					if callee.IsAFunction().IsNil() && callee.IsAInlineAsm().IsNil() {
+						// Indirect call, which must be awaited when it could
+						// call an async function.
+						if _, ok := asyncFuncTypes[callee.Type()]; ok {
+							asyncCalls = append(asyncCalls, inst)
+						}
+						continue
+					}
+					if _, ok := asyncFuncs[callee]; !ok || callee == sleep || callee == chanSendStub || callee == chanRecvStub || callee == chanSelectStub || callee == semacquireStub {
 						continue
 					}
 					asyncCalls = append(asyncCalls, inst)
@@ -336,17 +396,37 @@ func (c *Compiler) markAsyncFunctions() (needsScheduler bool, err error) {
 
 		// Modify async calls so this function suspends right after the child
 		// returns, because the child is probably not finished yet. Wait until
-		// the child reactivates the parent.
+		// the child reactivates the parent. Only when the child has finished
+		// without blocking, continue right away.
 		for _, inst := range asyncCalls {
 			inst.SetOperand(inst.OperandsCount()-2, frame.taskHandle)
 
+			var retvalAlloca llvm.Value
+			if inst.Type().TypeKind() != llvm.VoidTypeKind {
+				// The callee stores its return value in the memory pointed
+				// to by taskState.ptr of this coroutine, right before it
+				// reactivates this coroutine:
+				//     promise := coroutine.promise()
+				//     promise.ptr = &retval
+				//     callee(..., coroutine)
+				c.builder.SetInsertPointBefore(f.EntryBasicBlock().FirstInstruction())
+				retvalAlloca = c.builder.CreateAlloca(inst.Type(), "task.retval")
+				c.builder.SetInsertPointBefore(inst)
+				ptrField := c.getTaskPromisePtr(frame.taskHandle, coroPromiseFunc)
+				c.builder.CreateStore(c.builder.CreateBitCast(retvalAlloca, c.i8ptrType, ""), ptrField)
+			}
+
 			// Split this basic block.
 			await := c.splitBasicBlock(inst, llvm.NextBasicBlock(c.builder.GetInsertBlock()), "task.await")
 
-			// Set task state to TASK_STATE_CALL.
+			// Check whether the child has already finished.
 			c.builder.SetInsertPointAtEnd(inst.InstructionParent())
+			returned := c.createRuntimeCall("awaitTask", nil, "task.returned")
+			suspend := c.ctx.InsertBasicBlock(await, "task.await.suspend")
+			c.builder.CreateCondBr(returned, await, suspend)
 
 			// Suspend.
+			c.builder.SetInsertPointAtEnd(suspend)
 			continuePoint := c.builder.CreateCall(coroSuspendFunc, []llvm.Value{
 				llvm.ConstNull(c.ctx.TokenType()),
 				llvm.ConstInt(c.ctx.Int1Type(), 0, false),
@@ -354,44 +434,77 @@ func (c *Compiler) markAsyncFunctions() (needsScheduler bool, err error) {
 			sw := c.builder.CreateSwitch(continuePoint, frame.suspendBlock, 2)
 			sw.AddCase(llvm.ConstInt(c.ctx.Int8Type(), 0, false), await)
 			sw.AddCase(llvm.ConstInt(c.ctx.Int8Type(), 1, false), frame.cleanupBlock)
+
+			if !retvalAlloca.IsNil() {
+				// Load the return value once the callee has finished.
+				c.builder.SetInsertPointBefore(await.FirstInstruction())
+				retval := c.builder.CreateLoad(retvalAlloca, "task.retval.load")
+				inst.ReplaceAllUsesWith(retval)
+			}
 		}
 
 		// Replace return instructions with suspend points that should
 		// reactivate the parent coroutine.
 		for _, inst := range returns {
-			if inst.OperandsCount() == 0 {
-				// These properties were added by the functionattrs pass.
-				// Remove them, because now we start using the parameter.
-				// https://llvm.org/docs/Passes.html#functionattrs-deduce-function-attributes
-				for _, kind := range []string{"nocapture", "readnone"} {
-					kindID := llvm.AttributeKindID(kind)
-					f.RemoveEnumAttributeAtIndex(f.ParamsCount(), kindID)
-				}
+			// These properties were added by the functionattrs pass.
+			// Remove them, because now we start using the parameter.
+			// https://llvm.org/docs/Passes.html#functionattrs-deduce-function-attributes
+			for _, kind := range []string{"nocapture", "readnone"} {
+				kindID := llvm.AttributeKindID(kind)
+				f.RemoveEnumAttributeAtIndex(f.ParamsCount(), kindID)
+			}
 
-				// Reactivate the parent coroutine. This adds it back to
-				// the run queue, so it is started again by the
-				// scheduler when possible (possibly right after the
-				// following suspend).
+			c.builder.SetInsertPointBefore(inst)
+			parentHandle := f.LastParam()
+
+			if inst.OperandsCount() != 0 {
+				// Store the return value in the memory the parent has set
+				// up for it. There is no parent when this function was
+				// started as a goroutine, in which case the return value
+				// is dropped:
+				//     if parent != nil {
+				//         *(*T)(parent.promise().ptr) = retval
+				//     }
+				isNil := c.builder.CreateICmp(llvm.IntEQ, parentHandle, llvm.ConstPointerNull(c.i8ptrType), "task.parent.isnil")
+				returnBlock := c.splitBasicBlock(isNil, inst.InstructionParent(), "task.return")
+				storeBlock := c.ctx.InsertBasicBlock(returnBlock, "task.return.store")
+				c.builder.SetInsertPointAtEnd(isNil.InstructionParent())
+				c.builder.CreateCondBr(isNil, returnBlock, storeBlock)
+				c.builder.SetInsertPointAtEnd(storeBlock)
+				retvalPtr := c.builder.CreateLoad(c.getTaskPromisePtr(parentHandle, coroPromiseFunc), "task.retval.ptr")
+				retval := inst.Operand(0)
+				retvalPtr = c.builder.CreateBitCast(retvalPtr, llvm.PointerType(retval.Type(), 0), "")
+				c.builder.CreateStore(retval, retvalPtr)
+				c.builder.CreateBr(returnBlock)
 				c.builder.SetInsertPointBefore(inst)
+			}
 
-				parentHandle := f.LastParam()
-				c.createRuntimeCall("activateTask", []llvm.Value{parentHandle}, "")
-
-				// Suspend this coroutine.
-				// It would look like this is unnecessary, but if this
-				// suspend point is left out, it leads to undefined
-				// behavior somehow (with the unreachable instruction).
-				continuePoint := c.builder.CreateCall(coroSuspendFunc, []llvm.Value{
-					llvm.ConstNull(c.ctx.TokenType()),
-					llvm.ConstInt(c.ctx.Int1Type(), 1, false),
-				}, "ret")
-				sw := c.builder.CreateSwitch(continuePoint, frame.suspendBlock, 2)
-				sw.AddCase(llvm.ConstInt(c.ctx.Int8Type(), 0, false), frame.unreachableBlock)
-				sw.AddCase(llvm.ConstInt(c.ctx.Int8Type(), 1, false), frame.cleanupBlock)
-				inst.EraseFromParentAsInstruction()
-			} else {
-				panic("todo: return value from coroutine")
+			if f == realMain {
+				// Tell the scheduler that main.main has returned, so
+				// that it doesn't report a deadlock for goroutines
+				// that are still blocked.
+				c.createRuntimeCall("mainReturned", nil, "")
 			}
+
+			// Reactivate the parent coroutine. This adds it back to
+			// the run queue, so it is started again by the
+			// scheduler when possible (possibly right after the
+			// following suspend). A panic is passed on to the
+			// parent as well.
+			c.createRuntimeCall("returnTask", []llvm.Value{frame.taskHandle, parentHandle}, "")
+
+			// Suspend this coroutine.
+			// It would look like this is unnecessary, but if this
+			// suspend point is left out, it leads to undefined
+			// behavior somehow (with the unreachable instruction).
+			continuePoint := c.builder.CreateCall(coroSuspendFunc, []llvm.Value{
+				llvm.ConstNull(c.ctx.TokenType()),
+				llvm.ConstInt(c.ctx.Int1Type(), 1, false),
+			}, "ret")
+			sw := c.builder.CreateSwitch(continuePoint, frame.suspendBlock, 2)
+			sw.AddCase(llvm.ConstInt(c.ctx.Int8Type(), 0, false), frame.unreachableBlock)
+			sw.AddCase(llvm.ConstInt(c.ctx.Int8Type(), 1, false), frame.cleanupBlock)
+			inst.EraseFromParentAsInstruction()
 		}
 
 		// Coroutine cleanup. Free resources associated with this coroutine.
@@ -440,6 +553,8 @@ func (c *Compiler) markAsyncFunctions() (needsScheduler bool, err error) {
 	}
 
 	// Transform calls to runtime.chanSendStub into channel send operations.
+	// The value to send is stored in an alloca, which is kept in the coroutine
+	// frame while blocked.
 	for _, sendOp := range getUses(chanSendStub) {
 		// sendOp must be a call instruction.
 		frame := asyncFuncs[sendOp.InstructionParent().Parent()]
@@ -448,30 +563,12 @@ func (c *Compiler) markAsyncFunctions() (needsScheduler bool, err error) {
 		sendOp.SetOperand(0, frame.taskHandle)
 		sendOp.SetOperand(sendOp.OperandsCount()-1, c.mod.NamedFunction("runtime.chanSend"))
 
-		// Use taskState.data to store the value to send:
-		//     *(*valueType)(&coroutine.promise().data) = valueToSend
-		//     runtime.chanSend(coroutine, ch)
-		bitcast := sendOp.Operand(2)
-		valueAlloca := bitcast.Operand(0)
-		c.builder.SetInsertPointBefore(valueAlloca)
-		promiseType := c.mod.GetTypeByName("runtime.taskState")
-		promiseRaw := c.builder.CreateCall(coroPromiseFunc, []llvm.Value{
-			frame.taskHandle,
-			llvm.ConstInt(c.ctx.Int32Type(), uint64(c.targetData.PrefTypeAlignment(promiseType)), false),
-			llvm.ConstInt(c.ctx.Int1Type(), 0, false),
-		}, "task.promise.raw")
-		promise := c.builder.CreateBitCast(promiseRaw, llvm.PointerType(promiseType, 0), "task.promise")
-		dataPtr := c.builder.CreateGEP(promise, []llvm.Value{
-			llvm.ConstInt(c.ctx.Int32Type(), 0, false),
-			llvm.ConstInt(c.ctx.Int32Type(), 2, false),
-		}, "task.promise.data")
-		sendOp.SetOperand(2, llvm.Undef(c.i8ptrType))
-		valueAlloca.ReplaceAllUsesWith(c.builder.CreateBitCast(dataPtr, valueAlloca.Type(), ""))
-		bitcast.EraseFromParentAsInstruction()
-		valueAlloca.EraseFromParentAsInstruction()
-
 		// Yield to scheduler.
 		c.builder.SetInsertPointBefore(llvm.NextInstruction(sendOp))
+		if c.unwinding {
+			// Sending on a closed channel panics.
+			c.createRuntimeCall("yieldPanic", []llvm.Value{frame.taskHandle}, "")
+		}
 		continuePoint := c.builder.CreateCall(coroSuspendFunc, []llvm.Value{
 			llvm.ConstNull(c.ctx.TokenType()),
 			llvm.ConstInt(c.ctx.Int1Type(), 0, false),
@@ -483,19 +580,17 @@ func (c *Compiler) markAsyncFunctions() (needsScheduler bool, err error) {
 	}
 
 	// Transform calls to runtime.chanRecvStub into channel receive operations.
+	// The received value is stored directly in an alloca in the coroutine
+	// frame.
 	for _, recvOp := range getUses(chanRecvStub) {
 		// recvOp must be a call instruction.
 		frame := asyncFuncs[recvOp.InstructionParent().Parent()]
 
-		bitcast := recvOp.Operand(2)
 		commaOk := recvOp.Operand(3)
-		valueAlloca := bitcast.Operand(0)
 
 		// Receive the value over the channel, or block.
 		recvOp.SetOperand(0, frame.taskHandle)
 		recvOp.SetOperand(recvOp.OperandsCount()-1, c.mod.NamedFunction("runtime.chanRecv"))
-		recvOp.SetOperand(2, llvm.Undef(c.i8ptrType))
-		bitcast.EraseFromParentAsInstruction()
 
 		// Yield to scheduler.
 		c.builder.SetInsertPointBefore(llvm.NextInstruction(recvOp))
@@ -509,10 +604,9 @@ func (c *Compiler) markAsyncFunctions() (needsScheduler bool, err error) {
 		sw.AddCase(llvm.ConstInt(c.ctx.Int8Type(), 0, false), wakeup)
 		sw.AddCase(llvm.ConstInt(c.ctx.Int8Type(), 1, false), frame.cleanupBlock)
 
-		// The value to receive is stored in taskState.data:
-		//     runtime.chanRecv(coroutine, ch)
+		// The comma-ok value is stored in taskState.commaOk:
+		//     runtime.chanRecv(coroutine, ch, &value)
 		//     promise := coroutine.promise()
-		//     valueReceived := *(*valueType)(&promise.data)
 		//     ok := promise.commaOk
 		c.builder.SetInsertPointBefore(wakeup.FirstInstruction())
 		promiseType := c.mod.GetTypeByName("runtime.taskState")
@@ -522,21 +616,224 @@ func (c *Compiler) markAsyncFunctions() (needsScheduler bool, err error) {
 			llvm.ConstInt(c.ctx.Int1Type(), 0, false),
 		}, "task.promise.raw")
 		promise := c.builder.CreateBitCast(promiseRaw, llvm.PointerType(promiseType, 0), "task.promise")
+		commaOkPtr := c.builder.CreateGEP(promise, []llvm.Value{
+			llvm.ConstInt(c.ctx.Int32Type(), 0, false),
+			llvm.ConstInt(c.ctx.Int32Type(), 1, false),
+		}, "task.promise.comma-ok")
+		commaOk.ReplaceAllUsesWith(commaOkPtr)
+		recvOp.SetOperand(3, llvm.Undef(commaOk.Type()))
+	}
+
+	// Transform calls to runtime.chanSelectStub into blocking select
+	// operations.
+	for _, selectOp := range getUses(chanSelectStub) {
+		// selectOp must be a call instruction.
+		frame := asyncFuncs[selectOp.InstructionParent().Parent()]
+
+		// Select a case that can proceed, or block on all of them:
+		//     runtime.chanSelect(coroutine, recvbuf, states, ops)
+		var params []llvm.Value
+		for i := 0; i < selectOp.OperandsCount()-1; i++ {
+			params = append(params, selectOp.Operand(i))
+		}
+		params[0] = frame.taskHandle
+		c.builder.SetInsertPointBefore(selectOp)
+		c.builder.CreateCall(c.mod.NamedFunction("runtime.chanSelect"), params, "")
+		if c.unwinding {
+			// A send case that is selected right away panics when its channel
+			// is closed.
+			c.createRuntimeCall("yieldPanic", []llvm.Value{frame.taskHandle}, "")
+		}
+
+		// Yield to scheduler.
+		continuePoint := c.builder.CreateCall(coroSuspendFunc, []llvm.Value{
+			llvm.ConstNull(c.ctx.TokenType()),
+			llvm.ConstInt(c.ctx.Int1Type(), 0, false),
+		}, "")
+		sw := c.builder.CreateSwitch(continuePoint, frame.suspendBlock, 2)
+		wakeup := c.splitBasicBlock(sw, llvm.NextBasicBlock(c.builder.GetInsertBlock()), "task.selected")
+		sw.AddCase(llvm.ConstInt(c.ctx.Int8Type(), 0, false), wakeup)
+		sw.AddCase(llvm.ConstInt(c.ctx.Int8Type(), 1, false), frame.cleanupBlock)
+
+		// The selected case is stored in taskState.data and the comma-ok
+		// value in taskState.commaOk:
+		//     promise := coroutine.promise()
+		//     result := {uintptr(promise.data), promise.commaOk}
+		c.builder.SetInsertPointBefore(selectOp)
+		promiseType := c.mod.GetTypeByName("runtime.taskState")
+		promiseRaw := c.builder.CreateCall(coroPromiseFunc, []llvm.Value{
+			frame.taskHandle,
+			llvm.ConstInt(c.ctx.Int32Type(), uint64(c.targetData.PrefTypeAlignment(promiseType)), false),
+			llvm.ConstInt(c.ctx.Int1Type(), 0, false),
+		}, "task.promise.raw")
+		promise := c.builder.CreateBitCast(promiseRaw, llvm.PointerType(promiseType, 0), "task.promise")
 		dataPtr := c.builder.CreateGEP(promise, []llvm.Value{
 			llvm.ConstInt(c.ctx.Int32Type(), 0, false),
-			llvm.ConstInt(c.ctx.Int32Type(), 2, false),
+			llvm.ConstInt(c.ctx.Int32Type(), 3, false),
 		}, "task.promise.data")
-		valueAlloca.ReplaceAllUsesWith(c.builder.CreateBitCast(dataPtr, valueAlloca.Type(), ""))
-		valueAlloca.EraseFromParentAsInstruction()
+		index := c.builder.CreateLoad(dataPtr, "select.index")
+		if c.targetData.TypeAllocSize(index.Type()) > c.targetData.TypeAllocSize(c.uintptrType) {
+			index = c.builder.CreateTrunc(index, c.uintptrType, "")
+		} else if c.targetData.TypeAllocSize(index.Type()) < c.targetData.TypeAllocSize(c.uintptrType) {
+			index = c.builder.CreateZExt(index, c.uintptrType, "")
+		}
 		commaOkPtr := c.builder.CreateGEP(promise, []llvm.Value{
 			llvm.ConstInt(c.ctx.Int32Type(), 0, false),
 			llvm.ConstInt(c.ctx.Int32Type(), 1, false),
 		}, "task.promise.comma-ok")
-		commaOk.ReplaceAllUsesWith(commaOkPtr)
-		recvOp.SetOperand(3, llvm.Undef(commaOk.Type()))
+		commaOk := c.builder.CreateLoad(commaOkPtr, "select.ok")
+		result := llvm.Undef(selectOp.Type())
+		result = c.builder.CreateInsertValue(result, index, 0, "")
+		result = c.builder.CreateInsertValue(result, commaOk, 1, "")
+		selectOp.ReplaceAllUsesWith(result)
+		selectOp.EraseFromParentAsInstruction()
 	}
 
-	return true, c.lowerMakeGoroutineCalls()
+	// Transform calls to runtime.semacquireStub into semaphore acquire
+	// operations. The coroutine is rescheduled immediately when the semaphore
+	// is available, and otherwise once it is handed off by runtime.semrelease.
+	for _, acquireOp := range getUses(semacquireStub) {
+		// acquireOp must be a call instruction.
+		frame := asyncFuncs[acquireOp.InstructionParent().Parent()]
+
+		// Acquire the semaphore, or block.
+		acquireOp.SetOperand(0, frame.taskHandle)
+		acquireOp.SetOperand(acquireOp.OperandsCount()-1, c.mod.NamedFunction("runtime.semacquire"))
+
+		// Yield to scheduler.
+		c.builder.SetInsertPointBefore(llvm.NextInstruction(acquireOp))
+		continuePoint := c.builder.CreateCall(coroSuspendFunc, []llvm.Value{
+			llvm.ConstNull(c.ctx.TokenType()),
+			llvm.ConstInt(c.ctx.Int1Type(), 0, false),
+		}, "")
+		sw := c.builder.CreateSwitch(continuePoint, frame.suspendBlock, 2)
+		wakeup := c.splitBasicBlock(sw, llvm.NextBasicBlock(c.builder.GetInsertBlock()), "task.acquired")
+		sw.AddCase(llvm.ConstInt(c.ctx.Int8Type(), 0, false), wakeup)
+		sw.AddCase(llvm.ConstInt(c.ctx.Int8Type(), 1, false), frame.cleanupBlock)
+	}
+
+	return true, mainIsAsync, c.lowerMakeGoroutineCalls()
+}
+
+// getFunctionCalls returns all calls of the given function, and whether the
+// function is used in any other way than in a call or in a go statement (in
+// which case it may be called indirectly through a function pointer).
+func getFunctionCalls(fn llvm.Value) (calls []llvm.Value, isFuncPtr bool) {
+	for _, use := range getUses(fn) {
+		if !use.IsAConstantExpr().IsNil() && use.Opcode() == llvm.BitCast {
+			for _, call := range getUses(use) {
+				if call.IsACallInst().IsNil() {
+					isFuncPtr = true
+				} else if call.CalledValue().Name() == "runtime.makeGoroutine" {
+					// This is a go statement. Do not mark the parent as
+					// async, as starting a goroutine is not a blocking
+					// operation.
+				} else if isCalleeOnly(call, use) {
+					// Call of a bitcast function, for example a method
+					// with a pointer receiver called through an interface.
+					calls = append(calls, call)
+				} else {
+					isFuncPtr = true
+				}
+			}
+			continue
+		}
+		if use.IsACallInst().IsNil() {
+			if use.IsConstant() && len(getUses(use)) == 0 {
+				// Unused constant expression, for example left over from
+				// an interface method set that has been removed.
+				continue
+			}
+			// Not a call instruction, for example a store of a func value.
+			isFuncPtr = true
+			continue
+		}
+		if !isCalleeOnly(use, fn) {
+			// Passed as a parameter to a function.
+			isFuncPtr = true
+		}
+		if use.CalledValue() == fn {
+			calls = append(calls, use)
+		}
+	}
+	return
+}
+
+// isCalleeOnly returns whether the given value is only used as the called
+// function in the call instruction, and not as one of its parameters.
+func isCalleeOnly(call, fn llvm.Value) bool {
+	for i := 0; i < call.OperandsCount()-1; i++ {
+		if call.Operand(i) == fn {
+			return false
+		}
+	}
+	return true
+}
+
+// getIndirectCalls returns all calls through a function pointer in the module,
+// by function pointer type.
+func (c *Compiler) getIndirectCalls() map[llvm.Type][]llvm.Value {
+	calls := make(map[llvm.Type][]llvm.Value)
+	for fn := c.mod.FirstFunction(); !fn.IsNil(); fn = llvm.NextFunction(fn) {
+		if fn.IsDeclaration() {
+			continue
+		}
+		for bb := fn.EntryBasicBlock(); !bb.IsNil(); bb = llvm.NextBasicBlock(bb) {
+			for inst := bb.FirstInstruction(); !inst.IsNil(); inst = llvm.NextInstruction(inst) {
+				if inst.IsACallInst().IsNil() {
+					continue
+				}
+				callee := inst.CalledValue()
+				if !callee.IsAFunction().IsNil() || !callee.IsAInlineAsm().IsNil() || !callee.IsAConstantExpr().IsNil() {
+					// Not an indirect call.
+					continue
+				}
+				calls[callee.Type()] = append(calls[callee.Type()], inst)
+			}
+		}
+	}
+	return calls
+}
+
+// lowerBlockingNoScheduler replaces all calls to a pseudo function of a
+// blocking operation (like runtime.semacquireStub) with calls to the given
+// runtime function, for programs that don't need a scheduler. Without other
+// goroutines, an operation that cannot proceed immediately would block forever,
+// so these runtime functions abort the program with a deadlock in that case.
+func (c *Compiler) lowerBlockingNoScheduler(stub llvm.Value, name string) {
+	if stub.IsNil() {
+		return
+	}
+	for _, op := range getUses(stub) {
+		// Drop the coroutine parameter:
+		//     runtime.semacquireNoScheduler(sema, context, parentHandle)
+		var params []llvm.Value
+		for i := 1; i < op.OperandsCount()-1; i++ {
+			params = append(params, op.Operand(i))
+		}
+		c.builder.SetInsertPointBefore(op)
+		call := c.builder.CreateCall(c.mod.NamedFunction(name), params, "")
+		if op.Type().TypeKind() != llvm.VoidTypeKind {
+			op.ReplaceAllUsesWith(call)
+		}
+		op.EraseFromParentAsInstruction()
+	}
+}
+
+// getTaskPromisePtr returns a pointer to the ptr field of the promise
+// (runtime.taskState) of the given coroutine.
+func (c *Compiler) getTaskPromisePtr(taskHandle, coroPromiseFunc llvm.Value) llvm.Value {
+	promiseType := c.mod.GetTypeByName("runtime.taskState")
+	promiseRaw := c.builder.CreateCall(coroPromiseFunc, []llvm.Value{
+		taskHandle,
+		llvm.ConstInt(c.ctx.Int32Type(), uint64(c.targetData.PrefTypeAlignment(promiseType)), false),
+		llvm.ConstInt(c.ctx.Int1Type(), 0, false),
+	}, "task.promise.raw")
+	promise := c.builder.CreateBitCast(promiseRaw, llvm.PointerType(promiseType, 0), "task.promise")
+	return c.builder.CreateGEP(promise, []llvm.Value{
+		llvm.ConstInt(c.ctx.Int32Type(), 0, false),
+		llvm.ConstInt(c.ctx.Int32Type(), 4, false),
+	}, "task.promise.ptr")
 }
 
 // Lower runtime.makeGoroutine calls to regular call instructions. This is done
@@ -555,6 +852,11 @@ func (c *Compiler) lowerMakeGoroutineCalls() error {
 	//
 	// This function rewrites it to a direct call:
 	//   call void @main.startedGoroutine(i8* undef, i8* null)
+	//
+	// The started function is always a direct function: go statements on
+	// function values and interface methods start a wrapper function that does
+	// the actual call (see goroutine.go). The context parameter is passed as-is,
+	// so that closures can be started as goroutines.
 
 	makeGoroutine := c.mod.NamedFunction("runtime.makeGoroutine")
 	for _, goroutine := range getUses(makeGoroutine) {
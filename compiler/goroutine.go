@@ -0,0 +1,159 @@
+package compiler
+
+// This file implements the 'go' keyword in Go. A go statement is lowered to a
+// call to a function wrapped in runtime.makeGoroutine, which is lowered during
+// goroutine lowering. See goroutine-lowering.go for details.
+//
+// Only direct function calls can be wrapped in runtime.makeGoroutine. Other
+// calls (on function values and interface methods) are wrapped in a small
+// function that does the actual call, and this wrapper is then started as a
+// goroutine instead.
+
+import (
+	"golang.org/x/tools/go/ssa"
+	"tinygo.org/x/go-llvm"
+)
+
+// emitGo starts the function call in the given go statement as a new
+// goroutine.
+func (c *Compiler) emitGo(frame *Frame, instr *ssa.Go) error {
+	var calleeFn llvm.Value
+	var params []llvm.Value
+	if callee := instr.Call.StaticCallee(); callee != nil {
+		// Static call, either to a regular function or a closure.
+		targetFunc := c.ir.GetFunction(callee)
+		calleeFn = targetFunc.LLVMFn
+		for _, param := range instr.Call.Args {
+			val, err := c.parseExpr(frame, param)
+			if err != nil {
+				return err
+			}
+			params = append(params, val)
+		}
+		if !targetFunc.IsExported() {
+			context := llvm.Undef(c.i8ptrType)
+			if _, ok := instr.Call.Value.(*ssa.MakeClosure); ok {
+				// closure is {context, function pointer}
+				closure, err := c.parseExpr(frame, instr.Call.Value)
+				if err != nil {
+					return err
+				}
+				context = c.builder.CreateExtractValue(closure, 0, "")
+			}
+			params = append(params, context)                 // context parameter
+			params = append(params, llvm.Undef(c.i8ptrType)) // parent coroutine handle
+		}
+	} else {
+		// Function value or interface method. Start a wrapper as a goroutine
+		// that does the actual call.
+		var err error
+		calleeFn, params, err = c.createGoroutineWrapper(frame, instr)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Mark this function as a 'go' invocation and break invalid
+	// interprocedural optimizations. For example, heap-to-stack
+	// transformations are not sound as goroutines can outlive their parent.
+	calleeType := calleeFn.Type()
+	calleeValue := c.builder.CreateBitCast(calleeFn, c.i8ptrType, "")
+	calleeValue = c.createRuntimeCall("makeGoroutine", []llvm.Value{calleeValue}, "")
+	calleeValue = c.builder.CreateBitCast(calleeValue, calleeType, "")
+
+	c.createCall(calleeValue, params, "")
+	c.emitGoroutinePanicCheck(frame)
+	return nil
+}
+
+// createGoroutineWrapper creates a function that calls the function value or
+// interface method of the given go statement, so that the wrapper can be
+// started as a goroutine like a regular function. It returns the wrapper and
+// the parameters to pass to it: the function value or interface, followed by
+// the call arguments.
+func (c *Compiler) createGoroutineWrapper(frame *Frame, instr *ssa.Go) (llvm.Value, []llvm.Value, error) {
+	// Collect the values that are passed to the wrapper.
+	fnValue, err := c.parseExpr(frame, instr.Call.Value)
+	if err != nil {
+		return llvm.Value{}, nil, err
+	}
+	if !instr.Call.IsInvoke() {
+		// Starting a nil function as a goroutine panics in the caller.
+		c.emitNilCheck(frame, c.builder.CreateExtractValue(fnValue, 1, ""), "go")
+	}
+	values := []llvm.Value{fnValue}
+	for _, param := range instr.Call.Args {
+		val, err := c.parseExpr(frame, param)
+		if err != nil {
+			return llvm.Value{}, nil, err
+		}
+		values = append(values, val)
+	}
+
+	// Create the wrapper function, taking all values as parameters.
+	var paramTypes []llvm.Type
+	for _, value := range values {
+		paramTypes = append(paramTypes, c.expandFormalParamType(value.Type())...)
+	}
+	paramTypes = append(paramTypes, c.i8ptrType) // context
+	paramTypes = append(paramTypes, c.i8ptrType) // parent coroutine
+	fnType := llvm.FunctionType(c.ctx.VoidType(), paramTypes, false)
+	wrapper := llvm.AddFunction(c.mod, frame.fn.LinkName()+"$gowrapper", fnType)
+	wrapper.SetLinkage(llvm.InternalLinkage)
+	wrapper.SetUnnamedAddr(true)
+
+	// Add debug info if needed.
+	if c.Debug {
+		pos := c.ir.Program.Fset.Position(instr.Pos())
+		difunc, err := c.attachDebugInfoRaw(frame.fn, wrapper, "$gowrapper", pos.Filename, pos.Line)
+		if err != nil {
+			return llvm.Value{}, nil, err
+		}
+		c.builder.SetCurrentDebugLocation(uint(pos.Line), uint(pos.Column), difunc, llvm.Metadata{})
+	}
+
+	// Load the parameters in the wrapper.
+	callerBlock := c.builder.GetInsertBlock()
+	c.builder.SetInsertPointAtEnd(c.ctx.AddBasicBlock(wrapper, "entry"))
+	wrapperParams := wrapper.Params()
+	var wrapperValues []llvm.Value
+	for _, value := range values {
+		numFields := len(c.expandFormalParamType(value.Type()))
+		wrapperValues = append(wrapperValues, c.collapseFormalParam(value.Type(), wrapperParams[:numFields]))
+		wrapperParams = wrapperParams[numFields:]
+	}
+
+	// Do the actual call.
+	var fnPtr llvm.Value
+	var args []llvm.Value
+	if instr.Call.IsInvoke() {
+		itf := wrapperValues[0]
+		fnPtr, err = c.getInvokeFunc(itf, &instr.Call)
+		if err != nil {
+			return llvm.Value{}, nil, err
+		}
+		args = append(args, c.builder.CreateExtractValue(itf, 1, "invoke.func.receiver"))
+		args = append(args, wrapperValues[1:]...)
+		args = append(args, llvm.Undef(c.i8ptrType)) // context
+	} else {
+		// closure is {context, function pointer}
+		fnPtr = c.builder.CreateExtractValue(wrapperValues[0], 1, "")
+		args = append(args, wrapperValues[1:]...)
+		args = append(args, c.builder.CreateExtractValue(wrapperValues[0], 0, ""))
+	}
+	args = append(args, llvm.Undef(c.i8ptrType)) // parent coroutine handle
+	c.createCall(fnPtr, args, "")
+	c.builder.CreateRetVoid()
+
+	// Continue in the caller.
+	c.builder.SetInsertPointAtEnd(callerBlock)
+	if c.Debug {
+		pos := c.ir.Program.Fset.Position(instr.Pos())
+		c.builder.SetCurrentDebugLocation(uint(pos.Line), uint(pos.Column), frame.difunc, llvm.Metadata{})
+	}
+
+	params := values
+	params = append(params, llvm.Undef(c.i8ptrType)) // context parameter
+	params = append(params, llvm.Undef(c.i8ptrType)) // parent coroutine handle
+	return wrapper, params, nil
+}
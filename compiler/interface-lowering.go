@@ -22,8 +22,9 @@ package compiler
 //     switch. This is very easy to optimize for LLVM: it will often translate a
 //     type switch into a regular switch statement.
 //     When this type assert is not possible (the type is never used in an
-//     interface with makeInterface), this call is replaced with a constant
-//     false to optimize the type assert away completely.
+//     interface with makeInterface and the reflect package can't create one
+//     either), this call is replaced with a constant false to optimize the
+//     type assert away completely.
 //
 // interfaceImplements:
 //     This call is translated into a call that checks whether the underlying
@@ -154,18 +155,18 @@ type lowerInterfacesPass struct {
 // higher-level intrinsics that need some lowering before LLVM can work on them.
 // This is done so that a few cleanup passes can run before assigning the final
 // type codes.
-func (c *Compiler) LowerInterfaces() {
+func (c *Compiler) LowerInterfaces() error {
 	p := &lowerInterfacesPass{
 		Compiler:   c,
 		types:      make(map[string]*typeInfo),
 		signatures: make(map[string]*signatureInfo),
 		interfaces: make(map[string]*interfaceInfo),
 	}
-	p.run()
+	return p.run()
 }
 
 // run runs the pass itself.
-func (p *lowerInterfacesPass) run() {
+func (p *lowerInterfacesPass) run() error {
 	// Count per type how often it is put in an interface. Also, collect all
 	// methods this type has (if it is named).
 	makeInterface := p.mod.NamedFunction("runtime.makeInterface")
@@ -314,33 +315,40 @@ func (p *lowerInterfacesPass) run() {
 			// There are multiple types implementing this interface, thus there
 			// are multiple possible functions to call. Delegate calling the
 			// right function to a special wrapper function.
-			inttoptrs := getUses(use)
-			if len(inttoptrs) != 1 || inttoptrs[0].IsAIntToPtrInst().IsNil() {
-				panic("expected exactly one inttoptr use of runtime.interfaceMethod")
+			inttoptr, addrUses := interfaceMethodUses(use)
+			var calls []llvm.Value
+			for _, user := range getUses(inttoptr) {
+				if user.IsAPtrToIntInst().IsNil() {
+					calls = append(calls, user)
+				}
 			}
-			inttoptr := inttoptrs[0]
-			calls := getUses(inttoptr)
 			if len(calls) != 1 || calls[0].IsACallInst().IsNil() {
 				panic("expected exactly one call use of runtime.interfaceMethod")
 			}
 			call := calls[0]
 
 			// Set up parameters for the call. First copy the regular params...
-			params := make([]llvm.Value, call.OperandsCount())
+			numParams := call.OperandsCount() - 1
+			params := make([]llvm.Value, 0, numParams+1)
+			for i := 0; i < numParams-2; i++ {
+				params = append(params, call.Operand(i))
+			}
+			// then add the typecode, followed by the context and the parent
+			// coroutine handle. The parent handle must be the last parameter,
+			// as the thunk may need to be lowered to a coroutine (see
+			// LowerGoroutines).
+			params = append(params, typecode, call.Operand(numParams-2), call.Operand(numParams-1))
 			paramTypes := make([]llvm.Type, len(params))
-			for i := 0; i < len(params)-1; i++ {
-				params[i] = call.Operand(i)
-				paramTypes[i] = params[i].Type()
+			for i, param := range params {
+				paramTypes[i] = param.Type()
 			}
-			// then add the typecode to the end of the list.
-			params[len(params)-1] = typecode
-			paramTypes[len(params)-1] = p.uintptrType
 
 			// Create a function that redirects the call to the destination
 			// call, after selecting the right concrete type.
 			redirector := p.getInterfaceMethodFunc(itf, signature, call.Type(), paramTypes)
 
 			// Replace the old lookup/inttoptr/call with the new call.
+			p.replaceFuncAddr(use, addrUses, redirector)
 			p.builder.SetInsertPointBefore(call)
 			retval := p.builder.CreateCall(redirector, params, "")
 			if retval.Type().TypeKind() != llvm.VoidTypeKind {
@@ -414,7 +422,10 @@ func (p *lowerInterfacesPass) run() {
 	}
 
 	// Assign a type code for each type.
-	p.assignTypeCodes(typeSlice)
+	err := p.assignTypeCodes(typeSlice)
+	if err != nil {
+		return err
+	}
 
 	// Replace each call to runtime.makeInterface with the constant type code.
 	for _, use := range makeInterfaceUses {
@@ -431,7 +442,7 @@ func (p *lowerInterfacesPass) run() {
 		assertedTypeGlobal := use.Operand(1)
 		t := p.types[assertedTypeGlobal.Name()]
 		var commaOk llvm.Value
-		if t.countMakeInterfaces == 0 {
+		if _, ok := p.reflectInterfaceTypes[t.name[len("type:"):]]; t.countMakeInterfaces == 0 && !ok {
 			// impossible type assert: optimize accordingly
 			commaOk = llvm.ConstInt(p.ctx.Int1Type(), 0, false)
 		} else {
@@ -472,6 +483,7 @@ func (p *lowerInterfacesPass) run() {
 			typ.methodSet = llvm.Value{}
 		}
 	}
+	return nil
 }
 
 // addType retrieves Go type information based on a i16 global variable.
@@ -546,12 +558,9 @@ func (p *lowerInterfacesPass) getSignature(name string) *signatureInfo {
 // concrete method. This can be done when only one type implements the
 // interface.
 func (p *lowerInterfacesPass) replaceInvokeWithCall(use llvm.Value, typ *typeInfo, signature *signatureInfo) {
-	inttoptrs := getUses(use)
-	if len(inttoptrs) != 1 || inttoptrs[0].IsAIntToPtrInst().IsNil() {
-		panic("expected exactly one inttoptr use of runtime.interfaceMethod")
-	}
-	inttoptr := inttoptrs[0]
+	inttoptr, addrUses := interfaceMethodUses(use)
 	function := typ.getMethod(signature).function
+	p.replaceFuncAddr(use, addrUses, function)
 	if inttoptr.Type() != function.Type() {
 		p.builder.SetInsertPointBefore(use)
 		function = p.builder.CreateBitCast(function, inttoptr.Type(), "")
@@ -561,6 +570,47 @@ func (p *lowerInterfacesPass) replaceInvokeWithCall(use llvm.Value, typ *typeInf
 	use.EraseFromParentAsInstruction()
 }
 
+// interfaceMethodUses returns the inttoptr instruction that casts the result
+// of a runtime.interfaceMethod call to a function pointer, and all other
+// instructions that use the result or the function pointer, except for the
+// call of the function pointer. These other instructions store the address of
+// the deferred function that is about to be called, see emitDeferCallStart.
+func interfaceMethodUses(use llvm.Value) (inttoptr llvm.Value, addrUses []llvm.Value) {
+	for _, user := range getUses(use) {
+		if inttoptr.IsNil() && !user.IsAIntToPtrInst().IsNil() {
+			inttoptr = user
+		} else {
+			addrUses = append(addrUses, user)
+		}
+	}
+	if inttoptr.IsNil() {
+		panic("expected exactly one inttoptr use of runtime.interfaceMethod")
+	}
+	for _, user := range getUses(inttoptr) {
+		if !user.IsAPtrToIntInst().IsNil() {
+			addrUses = append(addrUses, user)
+		}
+	}
+	return inttoptr, addrUses
+}
+
+// replaceFuncAddr replaces the function address used by the given instructions
+// (see interfaceMethodUses) with the address of the given function.
+func (p *lowerInterfacesPass) replaceFuncAddr(use llvm.Value, addrUses []llvm.Value, fn llvm.Value) {
+	for _, user := range addrUses {
+		if !user.IsAPtrToIntInst().IsNil() {
+			user.ReplaceAllUsesWith(llvm.ConstPtrToInt(fn, user.Type()))
+			user.EraseFromParentAsInstruction()
+			continue
+		}
+		for i := 0; i < user.OperandsCount(); i++ {
+			if user.Operand(i) == use {
+				user.SetOperand(i, llvm.ConstPtrToInt(fn, use.Type()))
+			}
+		}
+	}
+}
+
 // getInterfaceImplementsFunc returns a function that checks whether a given
 // interface type implements a given interface, by checking all possible types
 // that implement this interface.
@@ -638,7 +688,7 @@ func (p *lowerInterfacesPass) getInterfaceMethodFunc(itf *interfaceInfo, signatu
 	fnName := "(" + itf.id() + ")." + signature.methodName()
 	fnType := llvm.FunctionType(returnType, params, false)
 	fn := llvm.AddFunction(p.mod, fnName, fnType)
-	fn.LastParam().SetName("actualType")
+	fn.Param(len(params) - 3).SetName("actualType")
 	itf.methodFuncs[signature] = fn
 	return fn
 }
@@ -667,16 +717,18 @@ func (p *lowerInterfacesPass) createInterfaceMethodFunc(itf *interfaceInfo, sign
 
 	// Create type switch in entry block.
 	p.builder.SetInsertPointAtEnd(entry)
-	actualType := fn.LastParam()
+	numParams := fn.ParamsCount()
+	actualType := fn.Param(numParams - 3)
 	sw := p.builder.CreateSwitch(actualType, defaultBlock, len(itf.types))
 
 	// Collect the params that will be passed to the functions to call.
 	// These params exclude the receiver (which may actually consist of multiple
-	// parts).
-	params := make([]llvm.Value, fn.ParamsCount()-2)
-	for i := range params {
-		params[i] = fn.Param(i + 1)
+	// parts) and the type code.
+	var params []llvm.Value
+	for i := 1; i < numParams-3; i++ {
+		params = append(params, fn.Param(i))
 	}
+	params = append(params, fn.Param(numParams-2), fn.Param(numParams-1))
 
 	// Define all possible functions that can be called.
 	for _, typ := range itf.types {
@@ -687,6 +739,9 @@ func (p *lowerInterfacesPass) createInterfaceMethodFunc(itf *interfaceInfo, sign
 		function := typ.getMethod(signature).function
 
 		p.builder.SetInsertPointAtEnd(bb)
+		if p.unwinding {
+			p.emitDeferCallForward(fn, function)
+		}
 		receiver := fn.FirstParam()
 		if receiver.Type() != function.FirstParam().Type() {
 			// When the receiver is a pointer, it is not wrapped. This means the
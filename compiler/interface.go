@@ -8,6 +8,7 @@ package compiler
 import (
 	"go/token"
 	"go/types"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -27,9 +28,11 @@ func (c *Compiler) parseMakeInterface(val llvm.Value, typ types.Type, pos token.
 	size := c.targetData.TypeAllocSize(val.Type())
 	if size > c.targetData.TypeAllocSize(c.i8ptrType) {
 		// Allocate on the heap and put a pointer in the interface.
-		// TODO: escape analysis.
+		// This allocation is moved to the stack by OptimizeAllocs when the
+		// interface value does not escape.
 		sizeValue := llvm.ConstInt(c.uintptrType, size, false)
 		alloc := c.createRuntimeCall("alloc", []llvm.Value{sizeValue}, "makeinterface.alloc")
+		c.heapAllocs[alloc] = pos
 		itfValueCast := c.builder.CreateBitCast(alloc, llvm.PointerType(val.Type(), 0), "makeinterface.cast.value")
 		c.builder.CreateStore(val, itfValueCast)
 		itfValue = c.builder.CreateBitCast(itfValueCast, c.i8ptrType, "makeinterface.cast.i8ptr")
@@ -42,7 +45,7 @@ func (c *Compiler) parseMakeInterface(val llvm.Value, typ types.Type, pos token.
 			itfValue = c.builder.CreateIntToPtr(val, c.i8ptrType, "makeinterface.cast.int")
 		case llvm.PointerTypeKind:
 			itfValue = c.builder.CreateBitCast(val, c.i8ptrType, "makeinterface.cast.ptr")
-		case llvm.StructTypeKind, llvm.FloatTypeKind, llvm.DoubleTypeKind:
+		case llvm.StructTypeKind, llvm.ArrayTypeKind, llvm.FloatTypeKind, llvm.DoubleTypeKind:
 			// A bitcast would be useful here, but bitcast doesn't allow
 			// aggregate types. So we'll bitcast it using an alloca.
 			// Hopefully this will get optimized away.
@@ -54,6 +57,9 @@ func (c *Compiler) parseMakeInterface(val llvm.Value, typ types.Type, pos token.
 			return llvm.Value{}, c.makeError(pos, "todo: makeinterface: cast small type to i8*")
 		}
 	}
+	// Interfaces are compared and hashed with functions generated by the
+	// compiler, see createInterfaceFuncs.
+	c.interfaceTypes[getTypeCodeName(typ)] = typ
 	itfTypeCodeGlobal := c.getTypeCode(typ)
 	itfMethodSetGlobal, err := c.getTypeMethodSet(typ)
 	if err != nil {
@@ -66,6 +72,201 @@ func (c *Compiler) parseMakeInterface(val llvm.Value, typ types.Type, pos token.
 	return itf, nil
 }
 
+// createInterfaceFuncs defines runtime.interfaceValueEqual and
+// runtime.interfaceValueHash, which compare and hash values of the same type
+// stored in interfaces. They check each type that is used in an interface in
+// turn, like a type switch, and compare the values the same way as the ==
+// operator does and hash them the same way as map keys.
+//
+// Values that are stored directly in the interface value field and can be
+// compared bit by bit (like integers and pointers) don't need a check of their
+// own: they are compared and hashed as a whole at the end.
+func (c *Compiler) createInterfaceFuncs() error {
+	equalFn := c.mod.NamedFunction("runtime.interfaceValueEqual")
+	equalRuntimeFn := c.ir.GetFunction(c.ir.Program.ImportedPackage("runtime").Members["interfaceValueEqual"].(*ssa.Function))
+	equalFn.SetLinkage(llvm.InternalLinkage)
+	equalFn.SetUnnamedAddr(true)
+	c.attachSyntheticDebugInfo(equalFn, equalRuntimeFn.Pos())
+	equalEntry := c.ctx.AddBasicBlock(equalFn, "entry")
+
+	hashFn := c.mod.NamedFunction("runtime.interfaceValueHash")
+	hashRuntimeFn := c.ir.GetFunction(c.ir.Program.ImportedPackage("runtime").Members["interfaceValueHash"].(*ssa.Function))
+	hashFn.SetLinkage(llvm.InternalLinkage)
+	hashFn.SetUnnamedAddr(true)
+	c.attachSyntheticDebugInfo(hashFn, hashRuntimeFn.Pos())
+	hashEntry := c.ctx.AddBasicBlock(hashFn, "entry")
+
+	// The reflect package can put values of all types that are part of a type
+	// in an interface (e.g. a struct field through reflect.Value.Interface),
+	// even when these types are never used in an interface directly.
+	interfaceTypes := make(map[string]types.Type, len(c.interfaceTypes))
+	for name, typ := range c.interfaceTypes {
+		interfaceTypes[name] = typ
+	}
+	if !c.mod.NamedFunction("reflect.ValueOf").IsNil() {
+		for _, typ := range c.interfaceTypes {
+			c.addReflectInterfaceTypes(typ)
+		}
+		for name, typ := range c.reflectInterfaceTypes {
+			interfaceTypes[name] = typ
+		}
+	}
+
+	// Sort the types to get a deterministic output.
+	names := make([]string, 0, len(interfaceTypes))
+	for name := range interfaceTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	equalBlock := equalEntry
+	hashBlock := hashEntry
+	for _, name := range names {
+		typ := interfaceTypes[name]
+		llvmType, err := c.getLLVMType(typ)
+		if err != nil {
+			return err
+		}
+		if !c.needsInterfaceFuncs(typ, llvmType) {
+			continue
+		}
+
+		// Compare two values of this type.
+		c.builder.SetInsertPointAtEnd(equalBlock)
+		typecode := equalFn.Param(0)
+		isType := c.createRuntimeCall("typeAssert", []llvm.Value{typecode, c.getTypeCode(typ)}, "")
+		compareBlock := c.ctx.AddBasicBlock(equalFn, "compare")
+		equalBlock = c.ctx.AddBasicBlock(equalFn, "next")
+		c.builder.CreateCondBr(isType, compareBlock, equalBlock)
+		c.builder.SetInsertPointAtEnd(compareBlock)
+		if !types.Comparable(typ) {
+			c.createRuntimeCall("interfaceUncomparable", nil, "")
+			c.builder.CreateRet(llvm.ConstInt(c.ctx.Int1Type(), 0, false))
+		} else {
+			x, err := c.loadInterfaceValue(equalFn.Param(1), llvmType)
+			if err != nil {
+				return err
+			}
+			y, err := c.loadInterfaceValue(equalFn.Param(2), llvmType)
+			if err != nil {
+				return err
+			}
+			equal, err := c.parseBinOp(token.EQL, typ, x, y, equalRuntimeFn.Pos())
+			if err != nil {
+				return err
+			}
+			c.builder.CreateRet(equal)
+		}
+
+		// Hash a value of this type.
+		c.builder.SetInsertPointAtEnd(hashBlock)
+		typecode = hashFn.Param(0)
+		isType = c.createRuntimeCall("typeAssert", []llvm.Value{typecode, c.getTypeCode(typ)}, "")
+		hashTypeBlock := c.ctx.AddBasicBlock(hashFn, "hash")
+		hashBlock = c.ctx.AddBasicBlock(hashFn, "next")
+		c.builder.CreateCondBr(isType, hashTypeBlock, hashBlock)
+		c.builder.SetInsertPointAtEnd(hashTypeBlock)
+		if !types.Comparable(typ) {
+			c.createRuntimeCall("hashmapUnhashable", nil, "")
+			c.builder.CreateRet(llvm.ConstInt(c.ctx.Int32Type(), 0, false))
+		} else {
+			value, err := c.loadInterfaceValue(hashFn.Param(1), llvmType)
+			if err != nil {
+				return err
+			}
+			hash, err := c.emitMapKeyHash(typ, value, hashRuntimeFn.Pos())
+			if err != nil {
+				return err
+			}
+			c.builder.CreateRet(hash)
+		}
+	}
+
+	// All other values are stored directly in the interface value field and
+	// can be compared and hashed as plain binary data.
+	i8ptrPtrType := llvm.PointerType(c.i8ptrType, 0)
+	c.builder.SetInsertPointAtEnd(equalBlock)
+	x := c.builder.CreateLoad(c.builder.CreateBitCast(equalFn.Param(1), i8ptrPtrType, ""), "x")
+	y := c.builder.CreateLoad(c.builder.CreateBitCast(equalFn.Param(2), i8ptrPtrType, ""), "y")
+	c.builder.CreateRet(c.builder.CreateICmp(llvm.IntEQ, x, y, ""))
+	c.builder.SetInsertPointAtEnd(hashBlock)
+	valueSize := llvm.ConstInt(c.uintptrType, c.targetData.TypeAllocSize(c.i8ptrType), false)
+	c.builder.CreateRet(c.createRuntimeCall("hashmapHash", []llvm.Value{hashFn.Param(1), valueSize}, ""))
+	return nil
+}
+
+// addReflectInterfaceTypes records all types that are part of the given type
+// (the element, key and field types) and are not used in an interface
+// directly, as the reflect package may still put values of these types in an
+// interface. Type asserts on these types must not be optimized away.
+func (c *Compiler) addReflectInterfaceTypes(typ types.Type) {
+	var parts []types.Type
+	switch t := typ.Underlying().(type) {
+	case *types.Array:
+		parts = append(parts, t.Elem())
+	case *types.Chan:
+		parts = append(parts, t.Elem())
+	case *types.Map:
+		parts = append(parts, t.Key(), t.Elem())
+	case *types.Pointer:
+		parts = append(parts, t.Elem())
+	case *types.Slice:
+		parts = append(parts, t.Elem())
+	case *types.Struct:
+		for i := 0; i < t.NumFields(); i++ {
+			parts = append(parts, t.Field(i).Type())
+		}
+	}
+	for _, part := range parts {
+		id := getTypeCodeName(part)
+		if _, ok := c.interfaceTypes[id]; ok {
+			// Visited through a regular interface type.
+			continue
+		}
+		if _, ok := c.reflectInterfaceTypes[id]; ok {
+			continue
+		}
+		c.reflectInterfaceTypes[id] = part
+		c.addReflectInterfaceTypes(part)
+	}
+}
+
+// needsInterfaceFuncs returns whether values of this type need their own
+// check in the functions created by createInterfaceFuncs, because they can't
+// be compared bit by bit as they're stored in an interface.
+func (c *Compiler) needsInterfaceFuncs(typ types.Type, llvmType llvm.Type) bool {
+	switch typ := typ.Underlying().(type) {
+	case *types.Basic:
+		if typ.Info()&(types.IsBoolean|types.IsInteger) == 0 && typ.Kind() != types.UnsafePointer {
+			// Strings, floats and complex numbers.
+			return true
+		}
+		// Integers that don't fit in the interface value field are stored
+		// behind a pointer.
+		return c.targetData.TypeAllocSize(llvmType) > c.targetData.TypeAllocSize(c.i8ptrType)
+	case *types.Chan, *types.Pointer, *types.Interface:
+		return false
+	default:
+		// Structs and arrays (which may contain padding), and types that
+		// are not comparable at all.
+		return true
+	}
+}
+
+// loadInterfaceValue loads a value of the given type from a pointer to the
+// value field of an interface, the same way as a type assert does: directly
+// from the field if it fits, from the pointed-to buffer otherwise.
+func (c *Compiler) loadInterfaceValue(ptr llvm.Value, llvmType llvm.Type) (llvm.Value, error) {
+	size := c.targetData.TypeAllocSize(llvmType)
+	if size == 0 {
+		return c.getZeroValue(llvmType)
+	}
+	if size > c.targetData.TypeAllocSize(c.i8ptrType) {
+		ptr = c.builder.CreateLoad(c.builder.CreateBitCast(ptr, llvm.PointerType(c.i8ptrType, 0), ""), "")
+	}
+	return c.builder.CreateLoad(c.builder.CreateBitCast(ptr, llvm.PointerType(llvmType, 0), ""), "value"), nil
+}
+
 // getTypeCode returns a reference to a type code.
 // It returns a pointer to an external global which should be replaced with the
 // real type in the interface lowering pass.
@@ -75,22 +276,40 @@ func (c *Compiler) getTypeCode(typ types.Type) llvm.Value {
 	if global.IsNil() {
 		global = llvm.AddGlobal(c.mod, c.ctx.Int8Type(), globalName)
 		global.SetGlobalConstant(true)
+		c.addReflectTypes(typ)
 	}
 	return global
 }
 
+// recursiveTypeCodeName is used in a type code name instead of the contents of
+// a named type that refers to itself, see getTypeCodeName.
+const recursiveTypeCodeName = "<recursive>"
+
 // getTypeCodeName returns a name for this type that can be used in the
 // interface lowering pass to assign type codes as expected by the reflect
 // package. See getTypeCodeNum.
 func getTypeCodeName(t types.Type) string {
+	return getTypeCodeNameVisited(t, make(map[*types.Named]struct{}))
+}
+
+// getTypeCodeNameVisited returns the type code name of this type, like
+// getTypeCodeName. Named types that are currently being visited are referred
+// to by their class and name only, so that types like
+// type T struct{ next *T } have a name of finite length.
+func getTypeCodeNameVisited(t types.Type, visited map[*types.Named]struct{}) string {
 	name := ""
 	if named, ok := t.(*types.Named); ok {
 		name = "~" + named.String() + ":"
 		t = t.Underlying()
+		if _, ok := visited[named]; ok {
+			return getTypeCodeClass(t) + ":" + name + recursiveTypeCodeName
+		}
+		visited[named] = struct{}{}
+		defer delete(visited, named)
 	}
 	switch t := t.(type) {
 	case *types.Array:
-		return "array:" + name + strconv.FormatInt(t.Len(), 10) + ":" + getTypeCodeName(t.Elem())
+		return "array:" + name + strconv.FormatInt(t.Len(), 10) + ":" + getTypeCodeNameVisited(t.Elem(), visited)
 	case *types.Basic:
 		var kind string
 		switch t.Kind() {
@@ -135,35 +354,35 @@ func getTypeCodeName(t types.Type) string {
 		}
 		return "basic:" + name + kind
 	case *types.Chan:
-		return "chan:" + name + getTypeCodeName(t.Elem())
+		return "chan:" + name + getTypeCodeNameVisited(t.Elem(), visited)
 	case *types.Interface:
 		methods := make([]string, t.NumMethods())
 		for i := 0; i < t.NumMethods(); i++ {
-			methods[i] = getTypeCodeName(t.Method(i).Type())
+			methods[i] = getTypeCodeNameVisited(t.Method(i).Type(), visited)
 		}
 		return "interface:" + name + "{" + strings.Join(methods, ",") + "}"
 	case *types.Map:
-		keyType := getTypeCodeName(t.Key())
-		elemType := getTypeCodeName(t.Elem())
+		keyType := getTypeCodeNameVisited(t.Key(), visited)
+		elemType := getTypeCodeNameVisited(t.Elem(), visited)
 		return "map:" + name + "{" + keyType + "," + elemType + "}"
 	case *types.Pointer:
-		return "pointer:" + name + getTypeCodeName(t.Elem())
+		return "pointer:" + name + getTypeCodeNameVisited(t.Elem(), visited)
 	case *types.Signature:
 		params := make([]string, t.Params().Len())
 		for i := 0; i < t.Params().Len(); i++ {
-			params[i] = getTypeCodeName(t.Params().At(i).Type())
+			params[i] = getTypeCodeNameVisited(t.Params().At(i).Type(), visited)
 		}
 		results := make([]string, t.Results().Len())
 		for i := 0; i < t.Results().Len(); i++ {
-			results[i] = getTypeCodeName(t.Results().At(i).Type())
+			results[i] = getTypeCodeNameVisited(t.Results().At(i).Type(), visited)
 		}
 		return "func:" + name + "{" + strings.Join(params, ",") + "}{" + strings.Join(results, ",") + "}"
 	case *types.Slice:
-		return "slice:" + name + getTypeCodeName(t.Elem())
+		return "slice:" + name + getTypeCodeNameVisited(t.Elem(), visited)
 	case *types.Struct:
 		elems := make([]string, t.NumFields())
 		for i := 0; i < t.NumFields(); i++ {
-			elems[i] = getTypeCodeName(t.Field(i).Type())
+			elems[i] = t.Field(i).Name() + ":" + getTypeCodeNameVisited(t.Field(i).Type(), visited)
 		}
 		return "struct:" + name + "{" + strings.Join(elems, ",") + "}"
 	default:
@@ -171,6 +390,33 @@ func getTypeCodeName(t types.Type) string {
 	}
 }
 
+// getTypeCodeClass returns the class of a type code name (the part before the
+// first colon) for the given underlying type.
+func getTypeCodeClass(t types.Type) string {
+	switch t.(type) {
+	case *types.Array:
+		return "array"
+	case *types.Basic:
+		return "basic"
+	case *types.Chan:
+		return "chan"
+	case *types.Interface:
+		return "interface"
+	case *types.Map:
+		return "map"
+	case *types.Pointer:
+		return "pointer"
+	case *types.Signature:
+		return "func"
+	case *types.Slice:
+		return "slice"
+	case *types.Struct:
+		return "struct"
+	default:
+		panic("unknown type: " + t.String())
+	}
+}
+
 // getTypeMethodSet returns a reference (GEP) to a global method set. This
 // method set should be unreferenced after the interface lowering pass.
 func (c *Compiler) getTypeMethodSet(typ types.Type) (llvm.Value, error) {
@@ -371,6 +617,7 @@ func (c *Compiler) parseTypeAssert(frame *Frame, expr *ssa.TypeAssert) (llvm.Val
 		// This is kind of dirty as the branch above becomes mostly useless,
 		// but hopefully this gets optimized away.
 		c.createRuntimeCall("interfaceTypeAssert", []llvm.Value{commaOk}, "")
+		c.emitPanicCheck(frame)
 		return phi, nil
 	}
 }
@@ -384,23 +631,10 @@ func (c *Compiler) getInvokeCall(frame *Frame, instr *ssa.CallCommon) (llvm.Valu
 		return llvm.Value{}, nil, err
 	}
 
-	llvmFnType, err := c.getLLVMType(instr.Method.Type())
+	fnCast, err := c.getInvokeFunc(itf, instr)
 	if err != nil {
 		return llvm.Value{}, nil, err
 	}
-	// getLLVMType() has created a closure type for us, but we don't actually
-	// want a closure type as an interface call can never be a closure call. So
-	// extract the function pointer type from the closure.
-	llvmFnType = llvmFnType.Subtypes()[1]
-
-	typecode := c.builder.CreateExtractValue(itf, 0, "invoke.typecode")
-	values := []llvm.Value{
-		typecode,
-		c.getInterfaceMethodSet(instr.Value.Type().(*types.Named)),
-		c.getMethodSignature(instr.Method),
-	}
-	fn := c.createRuntimeCall("interfaceMethod", values, "invoke.func")
-	fnCast := c.builder.CreateIntToPtr(fn, llvmFnType, "invoke.func.cast")
 	receiverValue := c.builder.CreateExtractValue(itf, 1, "invoke.func.receiver")
 
 	args := []llvm.Value{receiverValue}
@@ -420,6 +654,28 @@ func (c *Compiler) getInvokeCall(frame *Frame, instr *ssa.CallCommon) (llvm.Valu
 	return fnCast, args, nil
 }
 
+// getInvokeFunc returns the function pointer of the method to call on the
+// given interface value. The function pointer must only be used in a call.
+func (c *Compiler) getInvokeFunc(itf llvm.Value, instr *ssa.CallCommon) (llvm.Value, error) {
+	llvmFnType, err := c.getLLVMType(instr.Method.Type())
+	if err != nil {
+		return llvm.Value{}, err
+	}
+	// getLLVMType() has created a closure type for us, but we don't actually
+	// want a closure type as an interface call can never be a closure call. So
+	// extract the function pointer type from the closure.
+	llvmFnType = llvmFnType.Subtypes()[1]
+
+	typecode := c.builder.CreateExtractValue(itf, 0, "invoke.typecode")
+	values := []llvm.Value{
+		typecode,
+		c.getInterfaceMethodSet(instr.Value.Type().(*types.Named)),
+		c.getMethodSignature(instr.Method),
+	}
+	fn := c.createRuntimeCall("interfaceMethod", values, "invoke.func")
+	return c.builder.CreateIntToPtr(fn, llvmFnType, "invoke.func.cast"), nil
+}
+
 // interfaceInvokeWrapper keeps some state between getInterfaceInvokeWrapper and
 // createInterfaceInvokeWrapper. The former is called during IR construction
 // itself and the latter is called when finishing up the IR.
@@ -492,6 +748,9 @@ func (c *Compiler) createInterfaceInvokeWrapper(state interfaceInvokeWrapper) er
 	// set up IR builder
 	block := c.ctx.AddBasicBlock(wrapper, "entry")
 	c.builder.SetInsertPointAtEnd(block)
+	if c.unwinding {
+		c.emitDeferCallForward(wrapper, fn.LLVMFn)
+	}
 
 	var receiverPtr llvm.Value
 	if c.targetData.TypeAllocSize(receiverType) > c.targetData.TypeAllocSize(c.i8ptrType) {
@@ -9,13 +9,32 @@ import (
 	"tinygo.org/x/go-llvm"
 )
 
-func (c *Compiler) emitMapLookup(keyType, valueType types.Type, m, key llvm.Value, commaOk bool, pos token.Pos) (llvm.Value, error) {
+// The signatures of the hash and equality functions that are passed to the
+// runtime for map keys that cannot be compared with runtime.memequal.
+var (
+	hashmapKeyHashSignature = types.NewSignature(nil,
+		types.NewTuple(
+			types.NewVar(token.NoPos, nil, "key", types.Typ[types.UnsafePointer]),
+			types.NewVar(token.NoPos, nil, "n", types.Typ[types.Uintptr])),
+		types.NewTuple(types.NewVar(token.NoPos, nil, "", types.Typ[types.Uint32])),
+		false)
+	hashmapKeyEqualSignature = types.NewSignature(nil,
+		types.NewTuple(
+			types.NewVar(token.NoPos, nil, "x", types.Typ[types.UnsafePointer]),
+			types.NewVar(token.NoPos, nil, "y", types.Typ[types.UnsafePointer]),
+			types.NewVar(token.NoPos, nil, "n", types.Typ[types.Uintptr])),
+		types.NewTuple(types.NewVar(token.NoPos, nil, "", types.Typ[types.Bool])),
+		false)
+)
+
+func (c *Compiler) emitMapLookup(frame *Frame, keyType, valueType types.Type, m, key llvm.Value, commaOk bool, pos token.Pos) (llvm.Value, error) {
 	llvmValueType, err := c.getLLVMType(valueType)
 	if err != nil {
 		return llvm.Value{}, err
 	}
 	mapValueAlloca := c.builder.CreateAlloca(llvmValueType, "hashmap.value")
 	mapValuePtr := c.builder.CreateBitCast(mapValueAlloca, c.i8ptrType, "hashmap.valueptr")
+	keyType = keyType.Underlying()
 	var commaOkValue llvm.Value
 	if t, ok := keyType.(*types.Basic); ok && t.Info()&types.IsString != 0 {
 		// key is a string
@@ -29,8 +48,18 @@ func (c *Compiler) emitMapLookup(keyType, valueType types.Type, m, key llvm.Valu
 		params := []llvm.Value{m, keyPtr, mapValuePtr}
 		commaOkValue = c.createRuntimeCall("hashmapBinaryGet", params, "")
 	} else {
-		return llvm.Value{}, c.makeError(pos, "only strings, bools, ints or structs of bools/ints are supported as map keys, but got: "+keyType.String())
+		// key needs a custom hash and equality function
+		keyHash, keyEqual, err := c.getMapKeyFuncs(keyType, pos)
+		if err != nil {
+			return llvm.Value{}, err
+		}
+		keyAlloca := c.builder.CreateAlloca(key.Type(), "hashmap.key")
+		c.builder.CreateStore(key, keyAlloca)
+		keyPtr := c.builder.CreateBitCast(keyAlloca, c.i8ptrType, "hashmap.keyptr")
+		params := []llvm.Value{m, keyPtr, mapValuePtr, keyHash, keyEqual}
+		commaOkValue = c.createRuntimeCall("hashmapGenericGet", params, "")
 	}
+	c.emitPanicCheck(frame)
 	mapValue := c.builder.CreateLoad(mapValueAlloca, "")
 	if commaOk {
 		tuple := llvm.Undef(c.ctx.StructType([]llvm.Type{llvmValueType, c.ctx.Int1Type()}, false))
@@ -42,7 +71,7 @@ func (c *Compiler) emitMapLookup(keyType, valueType types.Type, m, key llvm.Valu
 	}
 }
 
-func (c *Compiler) emitMapUpdate(keyType types.Type, m, key, value llvm.Value, pos token.Pos) error {
+func (c *Compiler) emitMapUpdate(frame *Frame, keyType types.Type, m, key, value llvm.Value, pos token.Pos) error {
 	valueAlloca := c.builder.CreateAlloca(value.Type(), "hashmap.value")
 	c.builder.CreateStore(value, valueAlloca)
 	valuePtr := c.builder.CreateBitCast(valueAlloca, c.i8ptrType, "hashmap.valueptr")
@@ -51,6 +80,7 @@ func (c *Compiler) emitMapUpdate(keyType types.Type, m, key, value llvm.Value, p
 		// key is a string
 		params := []llvm.Value{m, key, valuePtr}
 		c.createRuntimeCall("hashmapStringSet", params, "")
+		c.emitPanicCheck(frame)
 		return nil
 	} else if hashmapIsBinaryKey(keyType) {
 		// key can be compared with runtime.memequal
@@ -59,18 +89,31 @@ func (c *Compiler) emitMapUpdate(keyType types.Type, m, key, value llvm.Value, p
 		keyPtr := c.builder.CreateBitCast(keyAlloca, c.i8ptrType, "hashmap.keyptr")
 		params := []llvm.Value{m, keyPtr, valuePtr}
 		c.createRuntimeCall("hashmapBinarySet", params, "")
+		c.emitPanicCheck(frame)
 		return nil
 	} else {
-		return c.makeError(pos, "only strings, bools, ints or structs of bools/ints are supported as map keys, but got: "+keyType.String())
+		// key needs a custom hash and equality function
+		keyHash, keyEqual, err := c.getMapKeyFuncs(keyType, pos)
+		if err != nil {
+			return err
+		}
+		keyAlloca := c.builder.CreateAlloca(key.Type(), "hashmap.key")
+		c.builder.CreateStore(key, keyAlloca)
+		keyPtr := c.builder.CreateBitCast(keyAlloca, c.i8ptrType, "hashmap.keyptr")
+		params := []llvm.Value{m, keyPtr, valuePtr, keyHash, keyEqual}
+		c.createRuntimeCall("hashmapGenericSet", params, "")
+		c.emitPanicCheck(frame)
+		return nil
 	}
 }
 
-func (c *Compiler) emitMapDelete(keyType types.Type, m, key llvm.Value, pos token.Pos) error {
+func (c *Compiler) emitMapDelete(frame *Frame, keyType types.Type, m, key llvm.Value, pos token.Pos) error {
 	keyType = keyType.Underlying()
 	if t, ok := keyType.(*types.Basic); ok && t.Info()&types.IsString != 0 {
 		// key is a string
 		params := []llvm.Value{m, key}
 		c.createRuntimeCall("hashmapStringDelete", params, "")
+		c.emitPanicCheck(frame)
 		return nil
 	} else if hashmapIsBinaryKey(keyType) {
 		keyAlloca := c.builder.CreateAlloca(key.Type(), "hashmap.key")
@@ -78,10 +121,215 @@ func (c *Compiler) emitMapDelete(keyType types.Type, m, key llvm.Value, pos toke
 		keyPtr := c.builder.CreateBitCast(keyAlloca, c.i8ptrType, "hashmap.keyptr")
 		params := []llvm.Value{m, keyPtr}
 		c.createRuntimeCall("hashmapBinaryDelete", params, "")
+		c.emitPanicCheck(frame)
+		return nil
+	} else {
+		// key needs a custom hash and equality function
+		keyHash, keyEqual, err := c.getMapKeyFuncs(keyType, pos)
+		if err != nil {
+			return err
+		}
+		keyAlloca := c.builder.CreateAlloca(key.Type(), "hashmap.key")
+		c.builder.CreateStore(key, keyAlloca)
+		keyPtr := c.builder.CreateBitCast(keyAlloca, c.i8ptrType, "hashmap.keyptr")
+		params := []llvm.Value{m, keyPtr, keyHash, keyEqual}
+		c.createRuntimeCall("hashmapGenericDelete", params, "")
+		c.emitPanicCheck(frame)
 		return nil
+	}
+}
+
+// emitMapNext advances a map iterator, storing the next key and value in the
+// memory pointed to by keyPtr and valuePtr. The hash and equality functions of
+// the key are needed to look up the current value when the map has grown
+// during the iteration.
+func (c *Compiler) emitMapNext(frame *Frame, keyType types.Type, m, it, keyPtr, valuePtr llvm.Value, pos token.Pos) (llvm.Value, error) {
+	keyType = keyType.Underlying()
+	var ok llvm.Value
+	if t, isBasic := keyType.(*types.Basic); isBasic && t.Info()&types.IsString != 0 {
+		// key is a string
+		params := []llvm.Value{m, it, keyPtr, valuePtr}
+		ok = c.createRuntimeCall("hashmapStringNext", params, "range.next")
+	} else if hashmapIsBinaryKey(keyType) {
+		params := []llvm.Value{m, it, keyPtr, valuePtr}
+		ok = c.createRuntimeCall("hashmapBinaryNext", params, "range.next")
 	} else {
-		return c.makeError(pos, "only strings, bools, ints or structs of bools/ints are supported as map keys, but got: "+keyType.String())
+		// key needs a custom hash and equality function
+		keyHash, keyEqual, err := c.getMapKeyFuncs(keyType, pos)
+		if err != nil {
+			return llvm.Value{}, err
+		}
+		params := []llvm.Value{m, it, keyPtr, valuePtr, keyHash, keyEqual}
+		ok = c.createRuntimeCall("hashmapGenericNext", params, "range.next")
+	}
+	c.emitPanicCheck(frame)
+	return ok, nil
+}
+
+// mapKeyFuncs is the state of the hash and equality functions of a map key
+// type, which are declared by getMapKeyFuncs and defined by createMapKeyFuncs.
+type mapKeyFuncs struct {
+	keyType   types.Type
+	hashFunc  llvm.Value
+	equalFunc llvm.Value
+	pos       token.Pos
+}
+
+// getMapKeyFuncs returns the hash and equality functions (as function values)
+// for map keys of the given type, for keys that cannot be hashed and compared
+// as plain binary data. The functions are declared on first use, and defined
+// after all other functions have been compiled (see createMapKeyFuncs).
+func (c *Compiler) getMapKeyFuncs(keyType types.Type, pos token.Pos) (llvm.Value, llvm.Value, error) {
+	hashFuncType, err := c.getLLVMType(hashmapKeyHashSignature)
+	if err != nil {
+		return llvm.Value{}, llvm.Value{}, err
 	}
+	equalFuncType, err := c.getLLVMType(hashmapKeyEqualSignature)
+	if err != nil {
+		return llvm.Value{}, llvm.Value{}, err
+	}
+	hashFuncName := "hashmap.hash:" + getTypeCodeName(keyType)
+	equalFuncName := "hashmap.equal:" + getTypeCodeName(keyType)
+
+	hashFunc := c.mod.NamedFunction(hashFuncName)
+	equalFunc := c.mod.NamedFunction(equalFuncName)
+	if hashFunc.IsNil() {
+		// The function types are the function pointer types in the closures.
+		hashFunc = llvm.AddFunction(c.mod, hashFuncName, hashFuncType.StructElementTypes()[1].ElementType())
+		equalFunc = llvm.AddFunction(c.mod, equalFuncName, equalFuncType.StructElementTypes()[1].ElementType())
+		c.mapKeyFuncs = append(c.mapKeyFuncs, mapKeyFuncs{
+			keyType:   keyType,
+			hashFunc:  hashFunc,
+			equalFunc: equalFunc,
+			pos:       pos,
+		})
+	}
+
+	// Create function values (closures) of these functions.
+	// Closure is: {context, function pointer}
+	hashFuncValue := llvm.ConstNull(hashFuncType)
+	hashFuncValue = llvm.ConstInsertValue(hashFuncValue, hashFunc, []uint32{1})
+	equalFuncValue := llvm.ConstNull(equalFuncType)
+	equalFuncValue = llvm.ConstInsertValue(equalFuncValue, equalFunc, []uint32{1})
+	return hashFuncValue, equalFuncValue, nil
+}
+
+// createMapKeyFuncs defines the hash and equality functions declared by
+// getMapKeyFuncs.
+//
+// The hash function hashes each part of the key (each struct field or array
+// element) separately and combines the results, so that padding bytes are
+// ignored and keys that are equal but have a different binary representation
+// (like +0.0 and -0.0) have the same hash.
+func (c *Compiler) createMapKeyFuncs(state mapKeyFuncs) error {
+	llvmKeyType, err := c.getLLVMType(state.keyType)
+	if err != nil {
+		return err
+	}
+	llvmKeyPtrType := llvm.PointerType(llvmKeyType, 0)
+
+	// Create the hash function:
+	//     func(key unsafe.Pointer, n uintptr) uint32
+	hashFunc := state.hashFunc
+	hashFunc.SetLinkage(llvm.InternalLinkage)
+	hashFunc.SetUnnamedAddr(true)
+	c.attachSyntheticDebugInfo(hashFunc, state.pos)
+	c.builder.SetInsertPointAtEnd(c.ctx.AddBasicBlock(hashFunc, "entry"))
+	key := c.builder.CreateLoad(c.builder.CreateBitCast(hashFunc.Param(0), llvmKeyPtrType, ""), "key")
+	hash, err := c.emitMapKeyHash(state.keyType, key, state.pos)
+	if err != nil {
+		return err
+	}
+	c.builder.CreateRet(hash)
+
+	// Create the equality function:
+	//     func(x, y unsafe.Pointer, n uintptr) bool
+	equalFunc := state.equalFunc
+	equalFunc.SetLinkage(llvm.InternalLinkage)
+	equalFunc.SetUnnamedAddr(true)
+	c.attachSyntheticDebugInfo(equalFunc, state.pos)
+	c.builder.SetInsertPointAtEnd(c.ctx.AddBasicBlock(equalFunc, "entry"))
+	x := c.builder.CreateLoad(c.builder.CreateBitCast(equalFunc.Param(0), llvmKeyPtrType, ""), "x")
+	y := c.builder.CreateLoad(c.builder.CreateBitCast(equalFunc.Param(1), llvmKeyPtrType, ""), "y")
+	equal, err := c.parseBinOp(token.EQL, state.keyType, x, y, state.pos)
+	if err != nil {
+		return err
+	}
+	c.builder.CreateRet(equal)
+	return nil
+}
+
+// emitMapKeyHash calculates the hash of the given map key. It is used in the
+// hash function created by createMapKeyFuncs.
+func (c *Compiler) emitMapKeyHash(keyType types.Type, key llvm.Value, pos token.Pos) (llvm.Value, error) {
+	switch typ := keyType.Underlying().(type) {
+	case *types.Basic:
+		switch {
+		case typ.Info()&types.IsString != 0:
+			return c.createRuntimeCall("hashmapStringHash", []llvm.Value{key}, ""), nil
+		case typ.Kind() == types.Float32:
+			return c.createRuntimeCall("hashmapFloat32Hash", []llvm.Value{key}, ""), nil
+		case typ.Kind() == types.Float64:
+			return c.createRuntimeCall("hashmapFloat64Hash", []llvm.Value{key}, ""), nil
+		case typ.Kind() == types.Complex64:
+			realHash := c.createRuntimeCall("hashmapFloat32Hash", []llvm.Value{c.builder.CreateExtractValue(key, 0, "")}, "")
+			imagHash := c.createRuntimeCall("hashmapFloat32Hash", []llvm.Value{c.builder.CreateExtractValue(key, 1, "")}, "")
+			return c.emitMapHashCombine(realHash, imagHash), nil
+		case typ.Kind() == types.Complex128:
+			realHash := c.createRuntimeCall("hashmapFloat64Hash", []llvm.Value{c.builder.CreateExtractValue(key, 0, "")}, "")
+			imagHash := c.createRuntimeCall("hashmapFloat64Hash", []llvm.Value{c.builder.CreateExtractValue(key, 1, "")}, "")
+			return c.emitMapHashCombine(realHash, imagHash), nil
+		case typ.Info()&(types.IsBoolean|types.IsInteger) != 0 || typ.Kind() == types.UnsafePointer:
+			return c.emitMapBinaryHash(key), nil
+		}
+	case *types.Chan, *types.Pointer:
+		return c.emitMapBinaryHash(key), nil
+	case *types.Interface:
+		return c.createRuntimeCall("hashmapInterfaceHash", []llvm.Value{key}, ""), nil
+	case *types.Array:
+		hash := llvm.ConstInt(c.ctx.Int32Type(), 2166136261, false) // FNV offset basis
+		for i := 0; i < int(typ.Len()); i++ {
+			elemHash, err := c.emitMapKeyHash(typ.Elem(), c.builder.CreateExtractValue(key, i, ""), pos)
+			if err != nil {
+				return llvm.Value{}, err
+			}
+			hash = c.emitMapHashCombine(hash, elemHash)
+		}
+		return hash, nil
+	case *types.Struct:
+		hash := llvm.ConstInt(c.ctx.Int32Type(), 2166136261, false) // FNV offset basis
+		for i := 0; i < typ.NumFields(); i++ {
+			if typ.Field(i).Name() == "_" {
+				// Blank fields are ignored in comparisons, so must also be
+				// ignored in the hash.
+				continue
+			}
+			fieldHash, err := c.emitMapKeyHash(typ.Field(i).Type(), c.builder.CreateExtractValue(key, i, ""), pos)
+			if err != nil {
+				return llvm.Value{}, err
+			}
+			hash = c.emitMapHashCombine(hash, fieldHash)
+		}
+		return hash, nil
+	}
+	return llvm.Value{}, c.makeError(pos, "invalid map key type: "+keyType.String())
+}
+
+// emitMapBinaryHash hashes a simple key (integer, pointer, etc.) by hashing its
+// binary representation with runtime.hashmapHash.
+func (c *Compiler) emitMapBinaryHash(key llvm.Value) llvm.Value {
+	keyAlloca := c.builder.CreateAlloca(key.Type(), "hashmap.key")
+	c.builder.CreateStore(key, keyAlloca)
+	keyPtr := c.builder.CreateBitCast(keyAlloca, c.i8ptrType, "hashmap.keyptr")
+	keySize := llvm.ConstInt(c.uintptrType, c.targetData.TypeAllocSize(key.Type()), false)
+	return c.createRuntimeCall("hashmapHash", []llvm.Value{keyPtr, keySize}, "")
+}
+
+// emitMapHashCombine mixes the hash of a part of a key into the hash of the
+// whole key. This is the same operation as runtime.hashmapHashCombine.
+func (c *Compiler) emitMapHashCombine(hash, partHash llvm.Value) llvm.Value {
+	hash = c.builder.CreateXor(hash, partHash, "")
+	return c.builder.CreateMul(hash, llvm.ConstInt(c.ctx.Int32Type(), 16777619, false), "") // FNV prime
 }
 
 // Get FNV-1a hash of this string.
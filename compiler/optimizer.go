@@ -2,6 +2,7 @@ package compiler
 
 import (
 	"errors"
+	"fmt"
 
 	"tinygo.org/x/go-llvm"
 )
@@ -42,7 +43,10 @@ func (c *Compiler) Optimize(optLevel, sizeLevel int, inlinerThreshold uint) erro
 		c.OptimizeMaps()
 		c.OptimizeStringToBytes()
 		c.OptimizeAllocs()
-		c.LowerInterfaces()
+		err := c.LowerInterfaces()
+		if err != nil {
+			return err
+		}
 
 		// After interfaces are lowered, there are many more opportunities for
 		// interprocedural optimizations. To get them to work, function
@@ -53,14 +57,25 @@ func (c *Compiler) Optimize(optLevel, sizeLevel int, inlinerThreshold uint) erro
 		c.OptimizeAllocs()
 		c.OptimizeStringToBytes()
 
-		err := c.LowerGoroutines()
+		if c.PrintAllocs {
+			c.printHeapAllocs()
+		}
+
+		err = c.LowerGoroutines()
 		if err != nil {
 			return err
 		}
 	} else {
+		if c.PrintAllocs {
+			c.printHeapAllocs()
+		}
+
 		// Must be run at any optimization level.
-		c.LowerInterfaces()
-		err := c.LowerGoroutines()
+		err := c.LowerInterfaces()
+		if err != nil {
+			return err
+		}
+		err = c.LowerGoroutines()
 		if err != nil {
 			return err
 		}
@@ -108,6 +123,7 @@ func (c *Compiler) OptimizeMaps() {
 
 	hashmapBinarySet := c.mod.NamedFunction("runtime.hashmapBinarySet")
 	hashmapStringSet := c.mod.NamedFunction("runtime.hashmapStringSet")
+	hashmapGenericSet := c.mod.NamedFunction("runtime.hashmapGenericSet")
 
 	for _, makeInst := range getUses(hashmapMake) {
 		updateInsts := []llvm.Value{}
@@ -116,7 +132,7 @@ func (c *Compiler) OptimizeMaps() {
 		for _, use := range getUses(makeInst) {
 			if use := use.IsACallInst(); !use.IsNil() {
 				switch use.CalledValue() {
-				case hashmapBinarySet, hashmapStringSet:
+				case hashmapBinarySet, hashmapStringSet, hashmapGenericSet:
 					updateInsts = append(updateInsts, use)
 				default:
 					unknownUses = true
@@ -196,99 +212,185 @@ func (c *Compiler) OptimizeAllocs() {
 		return
 	}
 
+	// Find out which pointer parameters do not escape their function, so
+	// that allocations passed to other Go functions can be moved to the
+	// stack as well.
+	c.addNoCaptureFlags()
+
 	heapallocs := getUses(allocator)
 	for _, heapalloc := range heapallocs {
-		nilValue := llvm.Value{}
-		if heapalloc.Operand(0).IsAConstant() == nilValue {
-			// Do not allocate variable length arrays on the stack.
+		if c.heapAllocReason(heapalloc) != "" {
 			continue
 		}
 		size := heapalloc.Operand(0).ZExtValue()
-		if size > 256 {
-			// The maximum value for a stack allocation.
-			// TODO: tune this, this is just a random value.
-			continue
-		}
+		bitcast := c.heapAllocValue(heapalloc)
 
-		// In general the pattern is:
-		//     %0 = call i8* @runtime.alloc(i32 %size)
-		//     %1 = bitcast i8* %0 to type*
-		//     (use %1 only)
-		// But the bitcast might sometimes be dropped when allocating an *i8.
-		// The 'bitcast' variable below is thus usually a bitcast of the
-		// heapalloc but not always.
-		bitcast := heapalloc // instruction that creates the value
-		if uses := getUses(heapalloc); len(uses) == 1 && uses[0].IsABitCastInst() != nilValue {
-			// getting only bitcast use
-			bitcast = uses[0]
+		// Insert alloca in the entry block. Do it here so that mem2reg can
+		// promote it to a SSA value.
+		fn := bitcast.InstructionParent().Parent()
+		c.builder.SetInsertPointBefore(fn.EntryBasicBlock().FirstInstruction())
+		alignment := c.targetData.ABITypeAlignment(c.i8ptrType)
+		sizeInWords := (size + uint64(alignment) - 1) / uint64(alignment)
+		allocaType := llvm.ArrayType(c.ctx.IntType(alignment*8), int(sizeInWords))
+		alloca := c.builder.CreateAlloca(allocaType, "stackalloc.alloca")
+		zero, _ := c.getZeroValue(alloca.Type().ElementType())
+		c.builder.CreateStore(zero, alloca)
+		stackalloc := c.builder.CreateBitCast(alloca, bitcast.Type(), "stackalloc")
+		bitcast.ReplaceAllUsesWith(stackalloc)
+		if heapalloc != bitcast {
+			bitcast.EraseFromParentAsInstruction()
 		}
-		if !c.doesEscape(bitcast) {
-			// Insert alloca in the entry block. Do it here so that mem2reg can
-			// promote it to a SSA value.
-			fn := bitcast.InstructionParent().Parent()
-			c.builder.SetInsertPointBefore(fn.EntryBasicBlock().FirstInstruction())
-			alignment := c.targetData.ABITypeAlignment(c.i8ptrType)
-			sizeInWords := (size + uint64(alignment) - 1) / uint64(alignment)
-			allocaType := llvm.ArrayType(c.ctx.IntType(alignment*8), int(sizeInWords))
-			alloca := c.builder.CreateAlloca(allocaType, "stackalloc.alloca")
-			zero, _ := c.getZeroValue(alloca.Type().ElementType())
-			c.builder.CreateStore(zero, alloca)
-			stackalloc := c.builder.CreateBitCast(alloca, bitcast.Type(), "stackalloc")
-			bitcast.ReplaceAllUsesWith(stackalloc)
-			if heapalloc != bitcast {
-				bitcast.EraseFromParentAsInstruction()
+		heapalloc.EraseFromParentAsInstruction()
+	}
+}
+
+// Interprocedural part of the escape analysis: mark every pointer parameter
+// of a Go function that doesn't escape with the "nocapture" flag. This is
+// repeated until no more flags can be added, as a parameter that is passed
+// on to another function only doesn't escape when the parameter of the
+// called function doesn't escape either.
+//
+// External functions are only marked nocapture when they have a //go:noescape
+// pragma, which is handled in parseFuncDecl.
+func (c *Compiler) addNoCaptureFlags() {
+	nocapture := llvm.AttributeKindID("nocapture")
+	for {
+		changed := false
+		for fn := c.mod.FirstFunction(); !fn.IsNil(); fn = llvm.NextFunction(fn) {
+			if fn.IsDeclaration() {
+				continue
+			}
+			for i, param := range fn.Params() {
+				if param.Type().TypeKind() != llvm.PointerTypeKind {
+					continue
+				}
+				index := i + 1 // param attributes start at 1
+				if fn.GetEnumAttributeAtIndex(index, nocapture) != (llvm.Attribute{}) {
+					// Already marked nocapture.
+					continue
+				}
+				if c.escapeReason(param) != "" {
+					continue
+				}
+				fn.AddAttributeAtIndex(index, c.ctx.CreateEnumAttribute(nocapture, 0))
+				changed = true
 			}
-			heapalloc.EraseFromParentAsInstruction()
 		}
+		if !changed {
+			return
+		}
+	}
+}
+
+// heapAllocValue returns the value that is actually used by the program for a
+// given runtime.alloc call.
+//
+// In general the pattern is:
+//     %0 = call i8* @runtime.alloc(i32 %size)
+//     %1 = bitcast i8* %0 to type*
+//     (use %1 only)
+// But the bitcast might sometimes be dropped when allocating an *i8.
+// The returned value is thus usually a bitcast of the heapalloc but not
+// always.
+func (c *Compiler) heapAllocValue(heapalloc llvm.Value) llvm.Value {
+	if uses := getUses(heapalloc); len(uses) == 1 && !uses[0].IsABitCastInst().IsNil() {
+		// getting only bitcast use
+		return uses[0]
+	}
+	return heapalloc
+}
+
+// heapAllocReason returns why the given runtime.alloc call cannot be
+// converted to a stack allocation, or the empty string if it can.
+func (c *Compiler) heapAllocReason(heapalloc llvm.Value) string {
+	if heapalloc.Operand(0).IsAConstant().IsNil() {
+		// Do not allocate variable length arrays on the stack.
+		return "size is not constant"
+	}
+	size := heapalloc.Operand(0).ZExtValue()
+	if size > 256 {
+		// The maximum value for a stack allocation.
+		// TODO: tune this, this is just a random value.
+		return fmt.Sprintf("too big for the stack (%d bytes)", size)
 	}
+	return c.escapeReason(c.heapAllocValue(heapalloc))
 }
 
-// Very basic escape analysis.
-func (c *Compiler) doesEscape(value llvm.Value) bool {
+// Very basic escape analysis. It returns a human readable reason why the given
+// pointer value escapes, or the empty string when it doesn't escape.
+func (c *Compiler) escapeReason(value llvm.Value) string {
 	uses := getUses(value)
 	for _, use := range uses {
-		nilValue := llvm.Value{}
-		if use.IsAGetElementPtrInst() != nilValue {
-			if c.doesEscape(use) {
-				return true
+		if !use.IsAGetElementPtrInst().IsNil() {
+			if reason := c.escapeReason(use); reason != "" {
+				return reason
 			}
-		} else if use.IsABitCastInst() != nilValue {
+		} else if !use.IsABitCastInst().IsNil() {
 			// A bitcast escapes if the casted-to value escapes.
-			if c.doesEscape(use) {
-				return true
+			if reason := c.escapeReason(use); reason != "" {
+				return reason
 			}
-		} else if use.IsALoadInst() != nilValue {
+		} else if !use.IsALoadInst().IsNil() {
 			// Load does not escape.
-		} else if use.IsAStoreInst() != nilValue {
+		} else if !use.IsAStoreInst().IsNil() {
 			// Store only escapes when the value is stored to, not when the
 			// value is stored into another value.
 			if use.Operand(0) == value {
-				return true
+				return "stored in memory"
 			}
-		} else if use.IsACallInst() != nilValue {
+		} else if !use.IsACallInst().IsNil() {
 			// Call only escapes when the (pointer) parameter is not marked
 			// "nocapture". This flag means that the parameter does not escape
 			// the give function.
-			if use.CalledValue().IsAFunction() != nilValue {
-				if use.CalledValue().IsDeclaration() {
-					// Kind of dirty: assume external functions don't let
-					// pointers escape.
-					// TODO: introduce //go:noescape that sets the 'nocapture'
-					// flag on each input parameter.
-					continue
-				}
-			}
+			// External functions are not assumed to keep pointers from
+			// escaping, as they might for example be C functions that store
+			// the pointer. They need a //go:noescape pragma instead.
 			if !c.hasFlag(use, value, "nocapture") {
-				return true
+				callee := use.CalledValue()
+				if callee.IsAFunction().IsNil() {
+					return "passed to a function pointer"
+				}
+				if callee.IsDeclaration() {
+					return "passed to external function " + callee.Name() + " without //go:noescape"
+				}
+				return "passed to " + callee.Name()
 			}
+		} else if !use.IsAReturnInst().IsNil() {
+			return "returned from " + use.InstructionParent().Parent().Name()
 		} else {
 			// Unknown instruction, might escape.
-			return true
+			return "used in an instruction that is not analyzed"
 		}
 	}
 
 	// does not escape
-	return false
+	return ""
+}
+
+// printHeapAllocs prints all remaining runtime.alloc calls together with the
+// reason why they could not be moved to the stack. It should be run after
+// OptimizeAllocs.
+func (c *Compiler) printHeapAllocs() {
+	allocator := c.mod.NamedFunction("runtime.alloc")
+	if allocator.IsNil() {
+		// no heap allocations
+		return
+	}
+
+	for _, heapalloc := range getUses(allocator) {
+		fn := heapalloc.InstructionParent().Parent()
+		location := fn.Name()
+		if pos, ok := c.heapAllocs[heapalloc]; ok && pos.IsValid() {
+			location = c.ir.Program.Fset.Position(pos).String()
+		}
+		reason := c.heapAllocReason(heapalloc)
+		if reason == "" {
+			// Not (yet) moved to the stack, for example because the program
+			// was compiled without optimizations.
+			reason = "not optimized"
+		}
+		fmt.Printf("%s: heap allocation in %s: %s\n", location, fn.Name(), reason)
+	}
 }
 
 // Check whether the given value (which is of pointer type) is never stored to.
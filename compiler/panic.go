@@ -0,0 +1,216 @@
+package compiler
+
+// This file implements stack unwinding for panics, which is needed for
+// recover(). It is only enabled when recover() is used somewhere in the
+// program, as it adds a check after every call. When it is not enabled, a panic
+// simply aborts the program.
+//
+// With unwinding enabled, a panic sets the runtime.panicking flag and returns.
+// After each call, the flag is checked and if it is set, control flows to the
+// unwind block of the calling function. This block looks like this:
+//
+//     unwind:
+//         runtime.unwindStart()          // clear the panicking flag
+//         rundefers                      // run all deferred calls
+//         if runtime.unwindEnd() {       // set the panicking flag again, unless recovered
+//             goto recover               // return normally (with named results)
+//         }
+//         return                         // continue unwinding in the caller
+//
+// A function without deferred calls simply returns from the unwind block.
+// Exported functions (like the program entry point) can't return a panic to
+// their caller, so they call runtime.fatalpanic instead which aborts the
+// program.
+//
+// recover() only stops a panic when it is called directly by a deferred
+// function that is run by the unwind block. Therefore, the unwind block stores
+// the address of each deferred function in runtime.deferFunc right before
+// calling it. A function that calls recover() checks on entry whether it is
+// that function (see runtime.deferCallEnter), and clears it so that the
+// functions it calls can't claim it. Wrappers that only call another function
+// (like bound method wrappers) pass it on to that function, see
+// emitDeferCallForward.
+//
+// See src/runtime/panic.go for the runtime side of this.
+
+import (
+	"github.com/tinygo-org/tinygo/ir"
+	"golang.org/x/tools/go/ssa"
+	"tinygo.org/x/go-llvm"
+)
+
+// needsUnwinding returns whether recover() is used anywhere in the program, in
+// which case panics must unwind the stack instead of aborting.
+func (c *Compiler) needsUnwinding() bool {
+	for _, fn := range c.ir.Functions {
+		if callsRecover(fn) {
+			return true
+		}
+	}
+	return false
+}
+
+// callsRecover returns whether the given function calls recover() directly.
+func callsRecover(fn *ir.Function) bool {
+	for _, block := range fn.Blocks {
+		for _, instr := range block.Instrs {
+			call, ok := instr.(*ssa.Call)
+			if !ok {
+				continue
+			}
+			if builtin, ok := call.Call.Value.(*ssa.Builtin); ok && builtin.Name() == "recover" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// emitRecoverEntry is called in the entry block of every function. A function
+// that calls recover() checks whether it has been called directly as a
+// deferred call by a function that is unwinding the stack. A wrapper passes
+// this on to the function it wraps.
+func (c *Compiler) emitRecoverEntry(frame *Frame) {
+	if !c.unwinding {
+		return
+	}
+	if callsRecover(frame.fn) {
+		fnAddr := llvm.ConstPtrToInt(frame.fn.LLVMFn, c.uintptrType)
+		frame.canRecover = c.createRuntimeCall("deferCallEnter", []llvm.Value{fnAddr}, "canrecover")
+	}
+	if frame.fn.Synthetic != "" {
+		// Wrappers generated by the ssa package, like bound method wrappers,
+		// call a single function.
+		for _, block := range frame.fn.Blocks {
+			for _, instr := range block.Instrs {
+				call, ok := instr.(*ssa.Call)
+				if !ok || call.Call.StaticCallee() == nil {
+					continue
+				}
+				callee := c.ir.GetFunction(call.Call.StaticCallee())
+				c.emitDeferCallForward(frame.fn.LLVMFn, callee.LLVMFn)
+				return
+			}
+		}
+	}
+}
+
+// emitDeferCallStart stores the function that is about to be called as a
+// deferred call by the unwind block, so that it can call recover().
+func (c *Compiler) emitDeferCallStart(fn llvm.Value) {
+	fnAddr := c.builder.CreatePtrToInt(fn, c.uintptrType, "defer.func")
+	c.builder.CreateStore(fnAddr, c.mod.NamedGlobal("runtime.deferFunc"))
+}
+
+// emitDeferCallForward emits code to pass on runtime.deferFunc from a wrapper
+// function to the function it calls, if the wrapper was called as a deferred
+// call. This way, recover() works in the wrapped function.
+func (c *Compiler) emitDeferCallForward(from, to llvm.Value) {
+	global := c.mod.NamedGlobal("runtime.deferFunc")
+	if global.IsNil() {
+		// Removed by the optimizer, so recover() isn't called anywhere.
+		return
+	}
+	fromAddr := llvm.ConstPtrToInt(from, c.uintptrType)
+	toAddr := llvm.ConstPtrToInt(to, c.uintptrType)
+	deferFunc := c.builder.CreateLoad(global, "defer.func")
+	isWrapper := c.builder.CreateICmp(llvm.IntEQ, deferFunc, fromAddr, "")
+	deferFunc = c.builder.CreateSelect(isWrapper, toAddr, deferFunc, "")
+	c.builder.CreateStore(deferFunc, global)
+}
+
+// getUnwindBlock returns the block to branch to when this function is
+// panicking. It is created on first use and filled in by emitUnwindBlock.
+func (c *Compiler) getUnwindBlock(frame *Frame) llvm.BasicBlock {
+	if frame.unwindBlock.IsNil() {
+		frame.unwindBlock = c.ctx.AddBasicBlock(frame.fn.LLVMFn, "unwind")
+	}
+	return frame.unwindBlock
+}
+
+// emitPanicCheck checks whether the previous call started a panic, and if so
+// branches to the unwind block. It has no effect when unwinding is disabled.
+func (c *Compiler) emitPanicCheck(frame *Frame) {
+	if !c.unwinding {
+		return
+	}
+	unwindBlock := c.getUnwindBlock(frame)
+	nextBlock := c.ctx.AddBasicBlock(frame.fn.LLVMFn, "call.next")
+	frame.blockExits[frame.currentBlock] = nextBlock // adjust outgoing block for phi nodes
+
+	panicking := c.builder.CreateLoad(c.mod.NamedGlobal("runtime.panicking"), "panicking")
+	c.builder.CreateCondBr(panicking, unwindBlock, nextBlock)
+
+	c.builder.SetInsertPointAtEnd(nextBlock)
+}
+
+// emitPanicTerminator terminates the current block after a call to a function
+// that always panics, like runtime._panic or runtime.nilpanic.
+func (c *Compiler) emitPanicTerminator(frame *Frame) {
+	if !c.unwinding {
+		c.builder.CreateUnreachable()
+		return
+	}
+	c.builder.CreateBr(c.getUnwindBlock(frame))
+}
+
+// emitGoroutinePanicCheck aborts the program when the goroutine that was just
+// started (if it is not a blocking goroutine) returned with a panic, as there
+// is no caller left to return it to.
+func (c *Compiler) emitGoroutinePanicCheck(frame *Frame) {
+	if !c.unwinding {
+		return
+	}
+	panicBlock := c.ctx.AddBasicBlock(frame.fn.LLVMFn, "go.panic")
+	nextBlock := c.ctx.AddBasicBlock(frame.fn.LLVMFn, "go.next")
+	frame.blockExits[frame.currentBlock] = nextBlock // adjust outgoing block for phi nodes
+
+	panicking := c.builder.CreateLoad(c.mod.NamedGlobal("runtime.panicking"), "panicking")
+	c.builder.CreateCondBr(panicking, panicBlock, nextBlock)
+
+	c.builder.SetInsertPointAtEnd(panicBlock)
+	c.createRuntimeCall("fatalpanic", nil, "")
+	c.builder.CreateUnreachable()
+
+	c.builder.SetInsertPointAtEnd(nextBlock)
+}
+
+// emitUnwindBlock fills the unwind block of this function, if it is used. It
+// must be called after all instructions of the function have been emitted, so
+// that all deferred calls are known.
+func (c *Compiler) emitUnwindBlock(frame *Frame) error {
+	if frame.unwindBlock.IsNil() {
+		return nil
+	}
+	c.builder.SetInsertPointAtEnd(frame.unwindBlock)
+	frame.currentBlock = nil
+
+	if frame.fn.Recover != nil {
+		// Run all deferred calls. A deferred call may panic again, in which
+		// case control flows back to the start of the unwind block and the
+		// remaining deferred calls are run.
+		c.createRuntimeCall("unwindStart", nil, "")
+		err := c.emitRunDefers(frame, true)
+		if err != nil {
+			return err
+		}
+		recovered := c.createRuntimeCall("unwindEnd", nil, "recovered")
+		returnBlock := c.ctx.AddBasicBlock(frame.fn.LLVMFn, "unwind.return")
+		c.builder.CreateCondBr(recovered, frame.blockEntries[frame.fn.Recover], returnBlock)
+		c.builder.SetInsertPointAtEnd(returnBlock)
+	}
+
+	// Return to the caller, which will continue unwinding the stack.
+	if frame.fn.IsExported() {
+		c.createRuntimeCall("fatalpanic", nil, "")
+		c.builder.CreateUnreachable()
+		return nil
+	}
+	returnType := frame.fn.LLVMFn.Type().ElementType().ReturnType()
+	if returnType.TypeKind() == llvm.VoidTypeKind {
+		c.builder.CreateRetVoid()
+	} else {
+		c.builder.CreateRet(llvm.Undef(returnType))
+	}
+	return nil
+}
@@ -1,10 +1,29 @@
 package compiler
 
 import (
+	"errors"
+	"go/types"
 	"math/big"
+	"sort"
+	"strconv"
 	"strings"
+
+	"tinygo.org/x/go-llvm"
 )
 
+// complexTypeClasses maps the class of all types other than basic types to the
+// number stored in the type code. See src/reflect/type.go.
+var complexTypeClasses = map[string]int64{
+	"chan":      0,
+	"interface": 1,
+	"pointer":   2,
+	"slice":     3,
+	"array":     4,
+	"func":      5,
+	"map":       6,
+	"struct":    7,
+}
+
 var basicTypes = map[string]int64{
 	"bool":       1,
 	"int":        2,
@@ -26,7 +45,54 @@ var basicTypes = map[string]int64{
 	"unsafeptr":  18,
 }
 
-func (c *Compiler) assignTypeCodes(typeSlice typeInfoSlice) {
+// typeCodeAssignmentState keeps some global state around for type code
+// assignments, used to assign one unique type code to each Go type.
+type typeCodeAssignmentState struct {
+	// Whether the wide encoding is used, which stores all types other than
+	// unnamed basic types in the side table. See src/reflect/type.go.
+	wide bool
+
+	// Index used for distinguishing types that cannot be distinguished by
+	// their contents alone, like structs and interfaces.
+	fallbackIndex int
+
+	// Map of named basic types to their number.
+	namedBasicTypes map[string]int
+
+	// Type codes of named complex types, indexed by class and name. They
+	// are used for references from a type to itself, see getTypeCodeName.
+	namedTypeCodes map[string]*big.Int
+
+	// Type codes that have already been calculated, indexed by type ID.
+	typeCodes map[string]*big.Int
+
+	// Type codes of unnamed types that are stored in the side table, indexed
+	// by class and contents. A type may be reached through different type IDs
+	// (see getTypeCodeName), but it must get only one type code.
+	sidetableTypeCodes map[string]*big.Int
+
+	// Contents of the side table, for types that are named or do not fit in
+	// a pointer-sized type code, and for array and struct type metadata. See
+	// src/reflect/type.go for the layout.
+	sidetable []llvm.Value
+
+	// Type codes of unnamed chan, pointer and slice types that are stored in
+	// the side table, so that the reflect package can look them up.
+	compositeTypes []llvm.Value
+
+	// Strings (struct field names, package paths) referenced from the side
+	// table.
+	strings map[string]llvm.Value
+
+	// Number of bits in a type code.
+	uintptrLen int
+}
+
+// errTypeCodeTooBig is returned while assigning type codes in the compact
+// encoding, when a type code doesn't fit in a uintptr.
+var errTypeCodeTooBig = errors.New("could not store type code number inside interface type code")
+
+func (c *Compiler) assignTypeCodes(typeSlice typeInfoSlice) error {
 	fn := c.mod.NamedFunction("reflect.ValueOf")
 	if fn.IsNil() {
 		// reflect.ValueOf is never used, so we can use the most efficient
@@ -34,46 +100,130 @@ func (c *Compiler) assignTypeCodes(typeSlice typeInfoSlice) {
 		for i, t := range typeSlice {
 			t.num = uint64(i + 1)
 		}
-		return
+		c.createReflectTables(&typeCodeAssignmentState{})
+		return nil
+	}
+
+	// Assign typecodes the way the reflect package expects. The compact
+	// encoding stores most types in the type code itself, but that doesn't
+	// work for every program, especially on systems with 16-bit pointers
+	// (e.g. AVR). Fall back to the wide encoding in that case.
+	reflectStrings := make(map[string]llvm.Value)
+	state, err := c.assignReflectTypeCodes(typeSlice, false, reflectStrings)
+	if err == errTypeCodeTooBig {
+		state, err = c.assignReflectTypeCodes(typeSlice, true, reflectStrings)
+	}
+	if err != nil {
+		return err
 	}
+	c.createReflectTables(state)
+	return nil
+}
 
-	// Assign typecodes the way the reflect package expects.
-	fallbackIndex := 1
-	namedTypes := make(map[string]int)
+// assignReflectTypeCodes assigns type codes to all types in the type slice using
+// either the compact or the wide encoding. The map of strings referenced from
+// the side table is shared between both attempts, so that no string is emitted
+// twice.
+func (c *Compiler) assignReflectTypeCodes(typeSlice typeInfoSlice, wide bool, reflectStrings map[string]llvm.Value) (*typeCodeAssignmentState, error) {
+	state := &typeCodeAssignmentState{
+		wide:               wide,
+		fallbackIndex:      1,
+		namedBasicTypes:    make(map[string]int),
+		namedTypeCodes:     make(map[string]*big.Int),
+		typeCodes:          make(map[string]*big.Int),
+		sidetableTypeCodes: make(map[string]*big.Int),
+		strings:            reflectStrings,
+		uintptrLen:         c.uintptrType.IntTypeWidth(),
+	}
 	for _, t := range typeSlice {
 		if t.name[:5] != "type:" {
 			panic("expected type name to start with 'type:'")
 		}
-		num := c.getTypeCodeNum(t.name[5:], &fallbackIndex, namedTypes)
-		if num.BitLen() > c.uintptrType.IntTypeWidth() || !num.IsUint64() {
-			// TODO: support this in some way, using a side table for example.
-			// That's less efficient but better than not working at all.
-			// Particularly important on systems with 16-bit pointers (e.g.
-			// AVR).
-			panic("compiler: could not store type code number inside interface type code")
+		num, err := c.getTypeCodeNum(t.name[5:], state)
+		if err != nil {
+			return nil, err
 		}
 		t.num = num.Uint64()
 	}
+	err := c.addCompositeTypes(state)
+	if err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// createReflectTables defines the globals that the reflect package reads type
+// information from, if they are used: the type side table, the zero-terminated
+// list of composite types in the side table and whether the wide encoding is
+// used. The reflect package only declares these globals, so they must be
+// defined even when they are empty.
+func (c *Compiler) createReflectTables(state *typeCodeAssignmentState) {
+	c.createReflectGlobal("reflect.typeSidetable", llvm.ConstArray(c.uintptrType, state.sidetable))
+	compositeTypes := append(state.compositeTypes, llvm.ConstInt(c.uintptrType, 0, false))
+	c.createReflectGlobal("reflect.compositeTypes", llvm.ConstArray(c.uintptrType, compositeTypes))
+	wide := uint64(0)
+	if state.wide {
+		wide = 1
+	}
+	c.createReflectGlobal("reflect.wideTypeCodes", llvm.ConstInt(c.ctx.Int1Type(), wide, false))
+}
+
+// createReflectGlobal replaces the given global that is declared in the
+// reflect package with a constant global with the given initializer.
+func (c *Compiler) createReflectGlobal(name string, initializer llvm.Value) {
+	global := c.mod.NamedGlobal(name)
+	if global.IsNil() {
+		return
+	}
+	newGlobal := llvm.AddGlobal(c.mod, initializer.Type(), name+".tmp")
+	newGlobal.SetInitializer(initializer)
+	newGlobal.SetLinkage(llvm.InternalLinkage)
+	newGlobal.SetGlobalConstant(true)
+	global.ReplaceAllUsesWith(llvm.ConstBitCast(newGlobal, global.Type()))
+	global.EraseFromParentAsGlobal()
+	newGlobal.SetName(name)
 }
 
 // getTypeCodeNum returns the typecode for a given type as expected by the
 // reflect package. Also see getTypeCodeName, which serializes types to a string
 // based on a types.Type value for this function.
-func (c *Compiler) getTypeCodeNum(id string, fallbackIndex *int, namedTypes map[string]int) *big.Int {
-	// Note: see src/reflect/type.go for bit allocations.
-	// A type can be named or unnamed. Example of both:
-	//     basic:~foo:uint64
-	//     basic:uint64
-	// Extract the class (basic, slice, pointer, etc.), the name, and the
-	// contents of this type ID string. Allocate bits based on that, as
-	// src/runtime/types.go expects.
-	class := id[:strings.IndexByte(id, ':')]
-	value := id[len(class)+1:]
-	name := ""
+func (c *Compiler) getTypeCodeNum(id string, state *typeCodeAssignmentState) (*big.Int, error) {
+	if num, ok := state.typeCodes[id]; ok {
+		return new(big.Int).Set(num), nil
+	}
+	num, err := c.calculateTypeCodeNum(id, state)
+	if err != nil {
+		return nil, err
+	}
+	state.typeCodes[id] = new(big.Int).Set(num)
+	return num, nil
+}
+
+// splitTypeCodeName splits a type ID into the class (basic, slice, pointer,
+// etc.), the name (for named types) and the contents. Example of both a named
+// and an unnamed type:
+//
+//	basic:~foo:uint64
+//	basic:uint64
+func splitTypeCodeName(id string) (class, name, value string) {
+	class = id[:strings.IndexByte(id, ':')]
+	value = id[len(class)+1:]
 	if value[0] == '~' {
 		name = value[1:strings.IndexByte(value, ':')]
 		value = value[len(name)+2:]
 	}
+	return
+}
+
+// calculateTypeCodeNum calculates the typecode for the given type, see
+// getTypeCodeNum. The resulting typecode always fits in a uintptr: in the
+// compact encoding, errTypeCodeTooBig is returned when the program has too
+// many types to encode them this way.
+func (c *Compiler) calculateTypeCodeNum(id string, state *typeCodeAssignmentState) (*big.Int, error) {
+	// Note: see src/reflect/type.go for bit allocations.
+	// Extract the class, the name, and the contents of this type ID string.
+	// Allocate bits based on that, as src/runtime/types.go expects.
+	class, name, value := splitTypeCodeName(id)
 	if class == "basic" {
 		// Basic types follow the following bit pattern:
 		//    ...xxxxx0
@@ -83,11 +233,27 @@ func (c *Compiler) getTypeCodeNum(id string, fallbackIndex *int, namedTypes map[
 		if !ok {
 			panic("invalid basic type: " + id)
 		}
+		if name != "" && state.wide {
+			// Named basic types can only be told apart by their entry in the
+			// side table.
+			index, result, err := c.reserveTypeDescriptor(state)
+			if err != nil {
+				return nil, err
+			}
+			state.sidetable[index] = llvm.ConstInt(c.uintptrType, uint64(num)<<1|1, false)
+			state.sidetable[index+1] = llvm.ConstInt(c.uintptrType, 0, false)
+			return result, nil
+		}
 		if name != "" {
 			// This type is named, set the upper bits to the name ID.
-			num |= int64(getNamedTypeNum(namedTypes, name)) << 5
+			num |= int64(getNamedTypeNum(state.namedBasicTypes, name)) << 5
 		}
-		return big.NewInt(num << 1)
+		result := big.NewInt(num << 1)
+		if result.BitLen() > state.uintptrLen {
+			// Too many named basic types.
+			return nil, errTypeCodeTooBig
+		}
+		return result, nil
 	} else {
 		// Complex types use the following bit pattern:
 		//    ...nxxx1
@@ -96,49 +262,337 @@ func (c *Compiler) getTypeCodeNum(id string, fallbackIndex *int, namedTypes map[
 		// other type (channel, interface, pointer, slice) just contain the bits
 		// of the wrapped type. Other types (like struct) have a different
 		// method of encoding the contents of the type.
+		classNumber, ok := complexTypeClasses[class]
+		if !ok {
+			panic("unknown type kind: " + id)
+		}
+		if name != "" && value == recursiveTypeCodeName {
+			// Reference to a named type from within its own contents, see
+			// getTypeCodeName. Its type code has been reserved already.
+			num, ok := state.namedTypeCodes[class+":~"+name]
+			if !ok {
+				panic("unknown recursive type: " + id)
+			}
+			return new(big.Int).Set(num), nil
+		}
+
+		// Named types store their contents in the side table. The upper bits
+		// contain the index into this side table, which is unique for each
+		// type. Reserve the side table entry before calculating the contents,
+		// so that the contents can refer back to this type (for example, in
+		// type T struct{ next *T }).
+		var index int
+		var result *big.Int
+		if name != "" {
+			var err error
+			if state.wide {
+				index, result, err = c.reserveTypeDescriptor(state)
+			} else {
+				index = len(state.sidetable)
+				state.sidetable = append(state.sidetable, llvm.Value{})
+				result, err = c.getSidetableTypeCodeNum(index, classNumber, state)
+			}
+			if err != nil {
+				return nil, err
+			}
+			state.typeCodes[id] = new(big.Int).Set(result)
+			state.namedTypeCodes[class+":~"+name] = new(big.Int).Set(result)
+		}
+
 		var num *big.Int
-		var classNumber int64
+		var err error
 		switch class {
-		case "chan":
-			num = c.getTypeCodeNum(value, fallbackIndex, namedTypes)
-			classNumber = 0
-		case "interface":
-			num = big.NewInt(int64(*fallbackIndex))
-			*fallbackIndex++
-			classNumber = 1
-		case "pointer":
-			num = c.getTypeCodeNum(value, fallbackIndex, namedTypes)
-			classNumber = 2
-		case "slice":
-			num = c.getTypeCodeNum(value, fallbackIndex, namedTypes)
-			classNumber = 3
+		case "chan", "pointer", "slice":
+			num, err = c.getTypeCodeNum(value, state)
+		case "interface", "func":
+			num = big.NewInt(int64(state.fallbackIndex))
+			state.fallbackIndex++
 		case "array":
-			num = big.NewInt(int64(*fallbackIndex))
-			*fallbackIndex++
-			classNumber = 4
-		case "func":
-			num = big.NewInt(int64(*fallbackIndex))
-			*fallbackIndex++
-			classNumber = 5
+			num, err = c.getArrayMetadata(value, state)
 		case "map":
-			num = big.NewInt(int64(*fallbackIndex))
-			*fallbackIndex++
-			classNumber = 6
+			num, err = c.getMapMetadata(id, state)
 		case "struct":
-			num = big.NewInt(int64(*fallbackIndex))
-			*fallbackIndex++
-			classNumber = 7
-		default:
-			panic("unknown type kind: " + id)
+			num, err = c.getStructMetadata(id, state)
+		}
+		if err != nil {
+			return nil, err
 		}
-		if name == "" {
-			num.Lsh(num, 5).Or(num, big.NewInt((classNumber<<1)+1))
+		if name != "" {
+			if state.wide {
+				state.sidetable[index] = llvm.ConstInt(c.uintptrType, uint64(classNumber+19)<<1|1, false)
+				index++
+			}
+			state.sidetable[index] = llvm.ConstInt(c.uintptrType, num.Uint64(), false)
+			return result, nil
+		}
+
+		if !state.wide {
+			result = new(big.Int).Lsh(num, 5)
+			result.Or(result, big.NewInt((classNumber<<1)+1))
+			if result.BitLen() <= state.uintptrLen {
+				return result, nil
+			}
+		}
+
+		// The type code doesn't fit in a pointer, which happens easily on
+		// systems with 16-bit pointers (e.g. AVR), or the wide encoding is
+		// used. Store the contents in the side table instead, like for named
+		// types.
+		key := class + ":" + num.String()
+		if result, ok := state.sidetableTypeCodes[key]; ok {
+			return new(big.Int).Set(result), nil
+		}
+		if state.wide {
+			index, result, err = c.reserveTypeDescriptor(state)
+			if err != nil {
+				return nil, err
+			}
+			state.sidetable[index] = llvm.ConstInt(c.uintptrType, uint64(classNumber+19)<<1, false)
+			state.sidetable[index+1] = llvm.ConstInt(c.uintptrType, num.Uint64(), false)
 		} else {
-			// TODO: store num in a sidetable
-			num = big.NewInt(int64(getNamedTypeNum(namedTypes, name))<<1 | 1)
-			num.Lsh(num, 4).Or(num, big.NewInt((classNumber<<1)+1))
+			index = len(state.sidetable)
+			state.sidetable = append(state.sidetable, llvm.ConstInt(c.uintptrType, num.Uint64(), false))
+			result, err = c.getSidetableTypeCodeNum(index, classNumber, state)
+			if err != nil {
+				return nil, err
+			}
+		}
+		state.sidetableTypeCodes[key] = new(big.Int).Set(result)
+		switch class {
+		case "chan", "pointer", "slice":
+			// These types may be created with reflect.PtrTo and
+			// reflect.SliceOf, which must find them in the side table.
+			state.compositeTypes = append(state.compositeTypes, llvm.ConstInt(c.uintptrType, result.Uint64(), false))
+		}
+		return result, nil
+	}
+}
+
+// getSidetableTypeCodeNum returns the type code of a complex type that stores
+// its contents in the side table at the given index, in the compact encoding.
+func (c *Compiler) getSidetableTypeCodeNum(index int, classNumber int64, state *typeCodeAssignmentState) (*big.Int, error) {
+	result := big.NewInt(int64(index)<<1 | 1)
+	result.Lsh(result, 4).Or(result, big.NewInt((classNumber<<1)+1))
+	if result.BitLen() > state.uintptrLen {
+		// The side table is too big.
+		return nil, errTypeCodeTooBig
+	}
+	return result, nil
+}
+
+// reserveTypeDescriptor reserves a type descriptor in the side table for the
+// wide encoding, and returns its index and the type code referring to it. A
+// type descriptor consists of the kind (as in reflect.Kind) shifted left by one
+// with the lowest bit set for named types, followed by the contents of the
+// type.
+func (c *Compiler) reserveTypeDescriptor(state *typeCodeAssignmentState) (int, *big.Int, error) {
+	index := len(state.sidetable)
+	state.sidetable = append(state.sidetable, llvm.Value{}, llvm.Value{})
+	result := big.NewInt(int64(index)<<1 | 1)
+	if result.BitLen() > state.uintptrLen {
+		// Only possible when the side table takes up more than half of the
+		// address space.
+		return 0, nil, errors.New("could not store type code number inside interface type code: side table is bigger than the address space")
+	}
+	return index, result, nil
+}
+
+// addCompositeTypes assigns type codes to the pointer and slice types that the
+// reflect package may need to create while a program uses reflection: pointers
+// to struct fields and to array and slice elements (for Value.Addr) and slices
+// of array elements (for Value.Slice). The reflect package calculates these
+// type codes itself when they fit in the compact encoding, but otherwise they
+// must be present in the side table.
+func (c *Compiler) addCompositeTypes(state *typeCodeAssignmentState) error {
+	done := make(map[string]struct{})
+	for {
+		var ids []string
+		for id := range state.typeCodes {
+			if _, ok := done[id]; !ok {
+				ids = append(ids, id)
+			}
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		sort.Strings(ids) // make the output deterministic
+		for _, id := range ids {
+			done[id] = struct{}{}
+			var composites []string
+			class, _, value := splitTypeCodeName(id)
+			if value == recursiveTypeCodeName {
+				// Reference to a named type, which is handled by itself.
+				continue
+			}
+			switch class {
+			case "array":
+				elem := value[strings.IndexByte(value, ':')+1:]
+				composites = append(composites, "slice:"+elem, "pointer:"+elem)
+			case "slice":
+				composites = append(composites, "pointer:"+value)
+			case "struct":
+				if typ, ok := c.reflectTypes[id].(*types.Struct); ok {
+					for i := 0; i < typ.NumFields(); i++ {
+						composites = append(composites, "pointer:"+getTypeCodeName(typ.Field(i).Type()))
+					}
+				}
+			}
+			for _, composite := range composites {
+				_, err := c.getTypeCodeNum(composite, state)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// getArrayMetadata adds the element type and length of an array type to the
+// side table and returns the index of this metadata. The value is the part of
+// the type ID after the class and name, for example "4:basic:int".
+func (c *Compiler) getArrayMetadata(value string, state *typeCodeAssignmentState) (*big.Int, error) {
+	lengthString := value[:strings.IndexByte(value, ':')]
+	length, err := strconv.ParseUint(lengthString, 10, 64)
+	if err != nil {
+		panic("invalid array length: " + value)
+	}
+	elem, err := c.getTypeCodeNum(value[len(lengthString)+1:], state)
+	if err != nil {
+		return nil, err
+	}
+	index := len(state.sidetable)
+	state.sidetable = append(state.sidetable,
+		llvm.ConstInt(c.uintptrType, elem.Uint64(), false),
+		llvm.ConstInt(c.uintptrType, length, false))
+	return big.NewInt(int64(index)), nil
+}
+
+// getMapMetadata adds the key and element type of a map type to the side table
+// and returns the index of this metadata.
+func (c *Compiler) getMapMetadata(id string, state *typeCodeAssignmentState) (*big.Int, error) {
+	typ, ok := c.reflectTypes[id].(*types.Map)
+	if !ok {
+		panic("unknown map type: " + id)
+	}
+	key, err := c.getTypeCodeNum(getTypeCodeName(typ.Key()), state)
+	if err != nil {
+		return nil, err
+	}
+	elem, err := c.getTypeCodeNum(getTypeCodeName(typ.Elem()), state)
+	if err != nil {
+		return nil, err
+	}
+	index := len(state.sidetable)
+	state.sidetable = append(state.sidetable,
+		llvm.ConstInt(c.uintptrType, key.Uint64(), false),
+		llvm.ConstInt(c.uintptrType, elem.Uint64(), false))
+	return big.NewInt(int64(index)), nil
+}
+
+// getStructMetadata adds the size and the fields of a struct type to the side
+// table and returns the index of this metadata. For each field, the type code,
+// offset, name, package path (for unexported fields) and whether it is
+// embedded is stored.
+func (c *Compiler) getStructMetadata(id string, state *typeCodeAssignmentState) (*big.Int, error) {
+	typ, ok := c.reflectTypes[id].(*types.Struct)
+	if !ok {
+		panic("unknown struct type: " + id)
+	}
+	llvmType, err := c.getLLVMType(typ)
+	if err != nil {
+		panic("could not get struct type: " + err.Error())
+	}
+
+	// Determine the field type codes first, as they may add entries to the
+	// side table themselves.
+	fieldTypes := make([]*big.Int, typ.NumFields())
+	for i := range fieldTypes {
+		fieldTypes[i], err = c.getTypeCodeNum(getTypeCodeName(typ.Field(i).Type()), state)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	index := len(state.sidetable)
+	state.sidetable = append(state.sidetable,
+		llvm.ConstInt(c.uintptrType, uint64(typ.NumFields()), false),
+		llvm.ConstInt(c.uintptrType, c.targetData.TypeAllocSize(llvmType), false))
+	for i, fieldType := range fieldTypes {
+		field := typ.Field(i)
+		pkgPath := llvm.ConstInt(c.uintptrType, 0, false)
+		if !field.Exported() {
+			pkgPath = c.getReflectString(field.Pkg().Path(), state)
+		}
+		embedded := uint64(0)
+		if field.Anonymous() {
+			embedded = 1
+		}
+		state.sidetable = append(state.sidetable,
+			llvm.ConstInt(c.uintptrType, fieldType.Uint64(), false),
+			llvm.ConstInt(c.uintptrType, c.targetData.ElementOffset(llvmType, i), false),
+			c.getReflectString(field.Name(), state),
+			pkgPath,
+			llvm.ConstInt(c.uintptrType, embedded, false))
+	}
+	return big.NewInt(int64(index)), nil
+}
+
+// getReflectString returns a pointer (as uintptr) to a global string with the
+// given contents, to be stored in the side table.
+func (c *Compiler) getReflectString(s string, state *typeCodeAssignmentState) llvm.Value {
+	if value, ok := state.strings[s]; ok {
+		return value
+	}
+	buf := llvm.AddGlobal(c.mod, llvm.ArrayType(c.ctx.Int8Type(), len(s)), "reflect.string$string")
+	buf.SetInitializer(c.ctx.ConstString(s, false))
+	buf.SetLinkage(llvm.InternalLinkage)
+	buf.SetGlobalConstant(true)
+	buf.SetUnnamedAddr(true)
+	zero := llvm.ConstInt(c.ctx.Int32Type(), 0, false)
+	strPtr := llvm.ConstInBoundsGEP(buf, []llvm.Value{zero, zero})
+	strLen := llvm.ConstInt(c.uintptrType, uint64(len(s)), false)
+	strType := c.mod.GetTypeByName("runtime._string")
+	str := llvm.AddGlobal(c.mod, strType, "reflect.string")
+	str.SetInitializer(llvm.ConstNamedStruct(strType, []llvm.Value{strPtr, strLen}))
+	str.SetLinkage(llvm.InternalLinkage)
+	str.SetGlobalConstant(true)
+	str.SetUnnamedAddr(true)
+	value := llvm.ConstPtrToInt(str, c.uintptrType)
+	state.strings[s] = value
+	return value
+}
+
+// addReflectTypes records all struct and map types that are part of the given
+// type (including the type itself), so that metadata can be emitted for them
+// once type codes are assigned. Only types that can be reached through
+// reflect.Type.Elem, reflect.Type.Key and reflect.Type.Field are recorded.
+func (c *Compiler) addReflectTypes(typ types.Type) {
+	switch t := typ.Underlying().(type) {
+	case *types.Array:
+		c.addReflectTypes(t.Elem())
+	case *types.Chan:
+		c.addReflectTypes(t.Elem())
+	case *types.Pointer:
+		c.addReflectTypes(t.Elem())
+	case *types.Slice:
+		c.addReflectTypes(t.Elem())
+	case *types.Map:
+		id := getTypeCodeName(typ)
+		if _, ok := c.reflectTypes[id]; ok {
+			return
+		}
+		c.reflectTypes[id] = t
+		c.addReflectTypes(t.Key())
+		c.addReflectTypes(t.Elem())
+	case *types.Struct:
+		id := getTypeCodeName(typ)
+		if _, ok := c.reflectTypes[id]; ok {
+			return
+		}
+		c.reflectTypes[id] = t
+		for i := 0; i < t.NumFields(); i++ {
+			c.addReflectTypes(t.Field(i).Type())
 		}
-		return num
 	}
 }
 
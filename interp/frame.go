@@ -241,7 +241,7 @@ func (fr *frame) evalBasicBlock(bb, incoming llvm.BasicBlock, indent string) (re
 					KeySize:   int(keySize),
 					ValueSize: int(valueSize),
 				}
-			case callee.Name() == "runtime.hashmapStringSet":
+			case callee.Name() == "runtime.hashmapStringSet" && fr.isCompileTimeMap(inst.Operand(0)):
 				// set a string key in the map
 				m := fr.getLocal(inst.Operand(0)).(*MapValue)
 				// "key" is a Go string value, which in the TinyGo calling convention is split up
@@ -250,12 +250,33 @@ func (fr *frame) evalBasicBlock(bb, incoming llvm.BasicBlock, indent string) (re
 				keyLen := fr.getLocal(inst.Operand(2)).(*LocalValue)
 				valPtr := fr.getLocal(inst.Operand(3)).(*LocalValue)
 				m.PutString(keyBuf, keyLen, valPtr)
-			case callee.Name() == "runtime.hashmapBinarySet":
+			case callee.Name() == "runtime.hashmapBinarySet" && fr.isCompileTimeMap(inst.Operand(0)):
 				// set a binary (int etc.) key in the map
 				m := fr.getLocal(inst.Operand(0)).(*MapValue)
 				keyBuf := fr.getLocal(inst.Operand(1)).(*LocalValue)
 				valPtr := fr.getLocal(inst.Operand(2)).(*LocalValue)
 				m.PutBinary(keyBuf, valPtr)
+			case callee.Name() == "runtime.hashmapGenericSet" && fr.isCompileTimeMap(inst.Operand(0)):
+				// set a key that needs a custom hash function (floats,
+				// interfaces, etc.) in the map
+				m := fr.getLocal(inst.Operand(0)).(*MapValue)
+				keyBuf := fr.getLocal(inst.Operand(1)).(*LocalValue)
+				valPtr := fr.getLocal(inst.Operand(2)).(*LocalValue)
+				keyHash := fr.getLocal(inst.Operand(3)).Value()
+				keyEqual := fr.getLocal(inst.Operand(4)).Value()
+				m.PutGeneric(keyBuf, valPtr, keyHash, keyEqual)
+			case callee.Name() == "runtime.hashmapStringSet" || callee.Name() == "runtime.hashmapBinarySet" || callee.Name() == "runtime.hashmapGenericSet":
+				// Set a key in a map that has been materialized already (for
+				// example, by storing it in a global). Do the assignment at
+				// runtime instead.
+				var params []llvm.Value
+				for i := 0; i < inst.OperandsCount()-1; i++ {
+					operand := fr.getLocal(inst.Operand(i)).Value()
+					fr.markDirty(operand)
+					params = append(params, operand)
+				}
+				// TODO: accurate debug info, including call chain
+				fr.builder.CreateCall(callee, params, inst.Name())
 			case callee.Name() == "runtime.stringConcat":
 				// adding two strings together
 				buf1Ptr := fr.getLocal(inst.Operand(0))
@@ -460,3 +481,10 @@ func (fr *frame) getLocal(v llvm.Value) Value {
 		panic("cannot find value")
 	}
 }
+
+// isCompileTimeMap returns whether the given operand is a map that is still
+// being built at compile time, so that new keys can be added to it directly.
+func (fr *frame) isCompileTimeMap(v llvm.Value) bool {
+	m, ok := fr.getLocal(v).(*MapValue)
+	return ok && m.Underlying.IsNil()
+}
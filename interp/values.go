@@ -151,6 +151,8 @@ type MapValue struct {
 	ValueSize  int
 	KeyType    llvm.Type
 	ValueType  llvm.Type
+	KeyHash    llvm.Value // hash function of keys that need one, see PutGeneric
+	KeyEqual   llvm.Value // equality function of keys that need one
 }
 
 func (v *MapValue) newBucket() llvm.Value {
@@ -175,6 +177,9 @@ func (v *MapValue) Value() llvm.Value {
 	if !v.Underlying.IsNil() {
 		return v.Underlying
 	}
+	if !v.KeyHash.IsNil() {
+		return v.genericValue()
+	}
 
 	ctx := v.Eval.Mod.Context()
 	i8ptrType := llvm.PointerType(ctx.Int8Type(), 0)
@@ -243,14 +248,63 @@ func (v *MapValue) Value() llvm.Value {
 	// Create the hashmap itself.
 	zero := llvm.ConstInt(ctx.Int32Type(), 0, false)
 	bucketPtr := llvm.ConstInBoundsGEP(firstBucketGlobal, []llvm.Value{zero})
+	v.Underlying = v.createHashmap(llvm.ConstBitCast(bucketPtr, i8ptrType), len(v.Keys))
+	return v.Underlying
+}
+
+// genericValue returns a global variable which is a pointer to the actual
+// hashmap, for maps with keys that need a custom hash function. These hashes
+// cannot be calculated at compile time (for example, the hash of an interface
+// depends on the type code, which is only known after interface lowering), so
+// the hashmap starts out empty and the keys are inserted at runtime, before
+// anything else can observe the map.
+func (v *MapValue) genericValue() llvm.Value {
+	ctx := v.Eval.Mod.Context()
+	i8ptrType := llvm.PointerType(ctx.Int8Type(), 0)
+	v.Underlying = v.createHashmap(llvm.ConstPointerNull(i8ptrType), 0)
+
+	setFn := v.Eval.Mod.NamedFunction("runtime.hashmapGenericSet")
+	for i, key := range v.Keys {
+		keyGlobal := llvm.AddGlobal(v.Eval.Mod, v.KeyType, v.PkgName+"$mapkey")
+		keyGlobal.SetInitializer(key.Value())
+		keyGlobal.SetLinkage(llvm.InternalLinkage)
+		keyGlobal.SetGlobalConstant(true)
+		keyGlobal.SetUnnamedAddr(true)
+		valueGlobal := llvm.AddGlobal(v.Eval.Mod, v.ValueType, v.PkgName+"$mapvalue")
+		valueGlobal.SetInitializer(v.Values[i].Value())
+		valueGlobal.SetLinkage(llvm.InternalLinkage)
+		valueGlobal.SetGlobalConstant(true)
+		valueGlobal.SetUnnamedAddr(true)
+		params := []llvm.Value{
+			v.Underlying,
+			llvm.ConstBitCast(keyGlobal, i8ptrType),
+			llvm.ConstBitCast(valueGlobal, i8ptrType),
+			v.KeyHash,
+			v.KeyEqual,
+		}
+		// Add the context and parent parameters.
+		for _, param := range setFn.Params()[len(params):] {
+			params = append(params, llvm.Undef(param.Type()))
+		}
+		// TODO: accurate debug info, including call chain
+		v.Eval.builder.CreateCall(setFn, params, "")
+	}
+	v.Eval.markDirty(v.Underlying)
+	return v.Underlying
+}
+
+// createHashmap creates the runtime.hashmap global and returns a pointer to
+// it.
+func (v *MapValue) createHashmap(buckets llvm.Value, count int) llvm.Value {
+	ctx := v.Eval.Mod.Context()
 	hashmapType := v.Type()
 	hashmap := llvm.ConstNamedStruct(hashmapType, []llvm.Value{
-		llvm.ConstPointerNull(llvm.PointerType(hashmapType, 0)),                        // next
-		llvm.ConstBitCast(bucketPtr, i8ptrType),                                        // buckets
-		llvm.ConstInt(hashmapType.StructElementTypes()[2], uint64(len(v.Keys)), false), // count
-		llvm.ConstInt(ctx.Int8Type(), uint64(v.KeySize), false),                        // keySize
-		llvm.ConstInt(ctx.Int8Type(), uint64(v.ValueSize), false),                      // valueSize
-		llvm.ConstInt(ctx.Int8Type(), 0, false),                                        // bucketBits
+		llvm.ConstPointerNull(llvm.PointerType(hashmapType, 0)), // next
+		buckets, // buckets
+		llvm.ConstInt(hashmapType.StructElementTypes()[2], uint64(count), false), // count
+		llvm.ConstInt(ctx.Int8Type(), uint64(v.KeySize), false),                  // keySize
+		llvm.ConstInt(ctx.Int8Type(), uint64(v.ValueSize), false),                // valueSize
+		llvm.ConstInt(ctx.Int8Type(), 0, false),                                  // bucketBits
 	})
 
 	// Create a pointer to this hashmap.
@@ -258,8 +312,8 @@ func (v *MapValue) Value() llvm.Value {
 	hashmapPtr.SetInitializer(hashmap)
 	hashmapPtr.SetLinkage(llvm.InternalLinkage)
 	hashmapPtr.SetUnnamedAddr(true)
-	v.Underlying = llvm.ConstInBoundsGEP(hashmapPtr, []llvm.Value{zero})
-	return v.Underlying
+	zero := llvm.ConstInt(ctx.Int32Type(), 0, false)
+	return llvm.ConstInBoundsGEP(hashmapPtr, []llvm.Value{zero})
 }
 
 // Type returns type runtime.hashmap, which is the actual hashmap type.
@@ -363,6 +417,14 @@ func (v *MapValue) PutBinary(keyPtr, valPtr *LocalValue) {
 	v.Values = append(v.Values, &LocalValue{v.Eval, value})
 }
 
+// PutGeneric does a map assign operation for keys that need a custom hash and
+// equality function, like floats, interfaces and structs containing them.
+func (v *MapValue) PutGeneric(keyPtr, valPtr *LocalValue, keyHash, keyEqual llvm.Value) {
+	v.KeyHash = keyHash
+	v.KeyEqual = keyEqual
+	v.PutBinary(keyPtr, valPtr)
+}
+
 // Get FNV-1a hash of this string.
 //
 // https://en.wikipedia.org/wiki/Fowler%E2%80%93Noll%E2%80%93Vo_hash_function#FNV-1a_hash
@@ -36,6 +36,8 @@ type Function struct {
 	linkName  string // go:linkname, go:export, go:interrupt
 	exported  bool   // go:export
 	nobounds  bool   // go:nobounds
+	noescape  bool   // go:noescape
+	noinline  bool   // go:noinline
 	flag      bool   // used by dead code elimination
 	interrupt bool   // go:interrupt
 }
@@ -310,6 +312,16 @@ func (f *Function) parsePragmas() {
 				if hasUnsafeImport(f.Pkg.Pkg) {
 					f.nobounds = true
 				}
+			case "//go:noescape":
+				// None of the pointer parameters escape this function. Only
+				// useful for functions without a body, like external
+				// functions: escaping parameters are detected automatically
+				// for other functions.
+				f.noescape = true
+			case "//go:noinline":
+				// Never inline this function, for example because it inspects
+				// its own stack frame.
+				f.noinline = true
 			}
 		}
 	}
@@ -319,6 +331,18 @@ func (f *Function) IsNoBounds() bool {
 	return f.nobounds
 }
 
+// Return true for functions annotated with //go:noescape, whose pointer
+// parameters do not escape.
+func (f *Function) IsNoEscape() bool {
+	return f.noescape
+}
+
+// Return true for functions annotated with //go:noinline, which must never be
+// inlined.
+func (f *Function) IsNoInline() bool {
+	return f.noinline
+}
+
 // Return true iff this function is externally visible.
 func (f *Function) IsExported() bool {
 	return f.exported || f.CName() != ""
@@ -34,15 +34,18 @@ func (e *commandError) Error() string {
 }
 
 type BuildConfig struct {
-	opt        string
-	gc         string
-	printIR    bool
-	dumpSSA    bool
-	debug      bool
-	printSizes string
-	cFlags     []string
-	ldFlags    []string
-	wasmAbi    string
+	opt         string
+	gc          string
+	printIR     bool
+	dumpSSA     bool
+	printAllocs bool
+	debug       bool
+	noFuncTable bool
+	stackLines  bool
+	printSizes  string
+	cFlags      []string
+	ldFlags     []string
+	wasmAbi     string
 }
 
 // Helper function for Compiler object.
@@ -56,18 +59,21 @@ func Compile(pkgName, outpath string, spec *TargetSpec, config *BuildConfig, act
 	spec.LDFlags = append(spec.LDFlags, config.ldFlags...)
 
 	compilerConfig := compiler.Config{
-		Triple:    spec.Triple,
-		CPU:       spec.CPU,
-		GOOS:      spec.GOOS,
-		GOARCH:    spec.GOARCH,
-		GC:        config.gc,
-		CFlags:    spec.CFlags,
-		LDFlags:   spec.LDFlags,
-		Debug:     config.debug,
-		DumpSSA:   config.dumpSSA,
-		RootDir:   sourceDir(),
-		GOPATH:    getGopath(),
-		BuildTags: spec.BuildTags,
+		Triple:      spec.Triple,
+		CPU:         spec.CPU,
+		GOOS:        spec.GOOS,
+		GOARCH:      spec.GOARCH,
+		GC:          config.gc,
+		CFlags:      spec.CFlags,
+		LDFlags:     spec.LDFlags,
+		Debug:       config.debug,
+		NoFuncTable: config.noFuncTable,
+		StackLines:  config.stackLines,
+		DumpSSA:     config.dumpSSA,
+		PrintAllocs: config.printAllocs,
+		RootDir:     sourceDir(),
+		GOPATH:      getGopath(),
+		BuildTags:   spec.BuildTags,
 	}
 	c, err := compiler.NewCompiler(pkgName, compilerConfig)
 	if err != nil {
@@ -140,6 +146,14 @@ func Compile(pkgName, outpath string, spec *TargetSpec, config *BuildConfig, act
 		return errors.New("verification failure after LLVM optimization passes")
 	}
 
+	// Add the table of functions used for stack traces. This must be done
+	// after optimization, so that it only contains functions that are still
+	// present.
+	c.CreateFuncTable()
+	if err := c.Verify(); err != nil {
+		return errors.New("verification error after creating the function table")
+	}
+
 	// On the AVR, pointers can point either to flash or to RAM, but we don't
 	// know. As a temporary fix, load all global variables in RAM.
 	// In the future, there should be a compiler pass that determines which
@@ -438,8 +452,9 @@ func Run(pkgName, target string, config *BuildConfig) error {
 			err := cmd.Run()
 			if err != nil {
 				if err, ok := err.(*exec.ExitError); ok && err.Exited() {
-					// Workaround for QEMU which always exits with an error.
-					return nil
+					// The program exited with a non-zero exit code, which is
+					// passed on by the caller.
+					return err
 				}
 				return &commandError{"failed to run compiled binary", tmppath, err}
 			}
@@ -453,8 +468,9 @@ func Run(pkgName, target string, config *BuildConfig) error {
 			err := cmd.Run()
 			if err != nil {
 				if err, ok := err.(*exec.ExitError); ok && err.Exited() {
-					// Workaround for QEMU which always exits with an error.
-					return nil
+					// The program exited with a non-zero exit code, which is
+					// passed on by the caller.
+					return err
 				}
 				return &commandError{"failed to run emulator with", tmppath, err}
 			}
@@ -505,9 +521,12 @@ func main() {
 	gc := flag.String("gc", "", "garbage collector to use (none, dumb, marksweep)")
 	printIR := flag.Bool("printir", false, "print LLVM IR")
 	dumpSSA := flag.Bool("dumpssa", false, "dump internal Go SSA")
+	printAllocs := flag.Bool("print-allocs", false, "print heap allocations that could not be moved to the stack")
 	target := flag.String("target", "", "LLVM target")
 	printSize := flag.String("size", "", "print sizes (none, short, full)")
 	nodebug := flag.Bool("no-debug", false, "disable DWARF debug symbol generation")
+	nofunctable := flag.Bool("no-functable", false, "strip the function table used for stack traces")
+	stackLines := flag.Bool("stack-lines", false, "record the line of every call for stack traces (needs debug symbols)")
 	ocdOutput := flag.Bool("ocd-output", false, "print OCD daemon output during debug")
 	port := flag.String("port", "/dev/ttyACM0", "flash port")
 	cFlags := flag.String("cflags", "", "additional cflags for compiler")
@@ -523,13 +542,16 @@ func main() {
 
 	flag.CommandLine.Parse(os.Args[2:])
 	config := &BuildConfig{
-		opt:        *opt,
-		gc:         *gc,
-		printIR:    *printIR,
-		dumpSSA:    *dumpSSA,
-		debug:      !*nodebug,
-		printSizes: *printSize,
-		wasmAbi:    *wasmAbi,
+		opt:         *opt,
+		gc:          *gc,
+		printIR:     *printIR,
+		dumpSSA:     *dumpSSA,
+		printAllocs: *printAllocs,
+		debug:       !*nodebug,
+		noFuncTable: *nofunctable,
+		stackLines:  *stackLines,
+		printSizes:  *printSize,
+		wasmAbi:     *wasmAbi,
 	}
 
 	if *cFlags != "" {
@@ -599,6 +621,10 @@ func main() {
 			os.Exit(1)
 		}
 		err := Run(flag.Arg(0), *target, config)
+		if err, ok := err.(*exec.ExitError); ok {
+			// Exit with the same exit code as the program.
+			os.Exit(err.ExitCode())
+		}
 		handleCompilerError(err)
 	case "clean":
 		// remove cache directory
@@ -12,6 +12,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"sort"
+	"strings"
 	"testing"
 )
 
@@ -56,6 +57,12 @@ func TestCompiler(t *testing.T) {
 
 	t.Log("running tests for emulated cortex-m3...")
 	for _, path := range matches {
+		if path == "testdata/osdir.go" {
+			continue // directories are not supported by semihosting
+		}
+		if path == "testdata/env.go" {
+			continue // no environment
+		}
 		t.Run(path, func(t *testing.T) {
 			runTest(path, tmpdir, "qemu", t)
 		})
@@ -87,6 +94,15 @@ func TestCompiler(t *testing.T) {
 			if path == "testdata/gc.go" {
 				continue // known to fail
 			}
+			if path == "testdata/stack.go" || path == "testdata/stacktrace.go" {
+				continue // walking the stack is not supported
+			}
+			if path == "testdata/os.go" || path == "testdata/osdir.go" {
+				continue // no filesystem
+			}
+			if path == "testdata/args.go" || path == "testdata/env.go" {
+				continue // no command line or environment
+			}
 			t.Run(path, func(t *testing.T) {
 				runTest(path, tmpdir, "wasm", t)
 			})
@@ -94,6 +110,69 @@ func TestCompiler(t *testing.T) {
 	}
 }
 
+// TestPrintAllocs checks which heap allocations in testdata/allocs/allocs.go
+// are reported by -print-allocs (and thus were not moved to the stack), and the
+// reason for each of them.
+func TestPrintAllocs(t *testing.T) {
+	expected, err := ioutil.ReadFile(TESTDATA + "/allocs/allocs.txt")
+	if err != nil {
+		t.Fatal("could not read expected output file:", err)
+	}
+
+	tmpdir, err := ioutil.TempDir("", "tinygo-test")
+	if err != nil {
+		t.Fatal("could not create temporary directory:", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	// The allocations are printed to stdout while compiling.
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal("could not create pipe:", err)
+	}
+	output := make(chan []byte)
+	go func() {
+		buf, _ := ioutil.ReadAll(r)
+		output <- buf
+	}()
+	stdout := os.Stdout
+	os.Stdout = w
+	config := &BuildConfig{
+		opt:         "z",
+		printAllocs: true,
+	}
+	err = Build("./"+TESTDATA+"/allocs/allocs.go", filepath.Join(tmpdir, "allocs.o"), "", config)
+	os.Stdout = stdout
+	w.Close()
+	buf := <-output
+	if err != nil {
+		t.Fatal("failed to build:", err)
+	}
+
+	// Only check the allocations in the test program, leaving out the
+	// directory and the column of each position.
+	var actual []string
+	for _, line := range strings.Split(string(buf), "\n") {
+		if !strings.Contains(line, ": heap allocation in main.") {
+			continue
+		}
+		parts := strings.SplitN(line, ": ", 2)
+		if pos := strings.Split(filepath.Base(parts[0]), ":"); len(pos) == 3 {
+			line = pos[0] + ":" + pos[1] + ": " + parts[1]
+		}
+		actual = append(actual, line)
+	}
+	sort.Strings(actual)
+	expectedLines := strings.Split(strings.TrimSpace(string(expected)), "\n")
+	sort.Strings(expectedLines)
+	if strings.Join(actual, "\n") != strings.Join(expectedLines, "\n") {
+		for _, line := range actual {
+			t.Log("allocation:", line)
+		}
+		t.Error("reported heap allocations did not match")
+	}
+}
+
 func runTest(path, tmpdir string, target string, t *testing.T) {
 	// Get the expected output for this test.
 	txtpath := path[:len(path)-3] + ".txt"
@@ -109,14 +188,20 @@ func runTest(path, tmpdir string, target string, t *testing.T) {
 		t.Fatal("could not read expected output file:", err)
 	}
 
-	// Build the test binary.
+	// Build the test binary. The stack tests need the line table, which is
+	// created from debug information. The deadlock test is built without the
+	// function table, as the functions of blocked goroutines can't be printed
+	// on every target.
+	stackTest := path == "testdata/stack.go" || path == "testdata/stacktrace.go"
 	config := &BuildConfig{
-		opt:        "z",
-		printIR:    false,
-		dumpSSA:    false,
-		debug:      false,
-		printSizes: "",
-		wasmAbi:    "js",
+		opt:         "z",
+		printIR:     false,
+		dumpSSA:     false,
+		debug:       stackTest,
+		stackLines:  stackTest,
+		printSizes:  "",
+		wasmAbi:     "js",
+		noFuncTable: path == "testdata/deadlock.go",
 	}
 	binary := filepath.Join(tmpdir, "test")
 	err = Build("./"+path, binary, target, config)
@@ -126,10 +211,30 @@ func runTest(path, tmpdir string, target string, t *testing.T) {
 		return
 	}
 
+	// Tests exit with exit code 0, except for the tests that check it.
+	expectedExitCode := 0
+	switch path {
+	case "testdata/exitcode.go":
+		expectedExitCode = 3
+	case "testdata/deadlock.go", "testdata/stacktrace.go":
+		expectedExitCode = 2
+	}
+
+	// Some tests check the command line arguments and environment variables.
+	// The last argument is long, so that it doesn't fit in the buffer that is
+	// initially used to retrieve the command line from QEMU.
+	var testArgs, testEnv []string
+	switch path {
+	case "testdata/args.go":
+		testArgs = []string{"first", "second", strings.Repeat("x", 300)}
+	case "testdata/env.go":
+		testEnv = []string{"ENV1=VALUE1", "ENV2=VALUE2"}
+	}
+
 	// Run the test.
 	var cmd *exec.Cmd
 	if target == "" {
-		cmd = exec.Command(binary)
+		cmd = exec.Command(binary, testArgs...)
 	} else {
 		spec, err := LoadTarget(target)
 		if err != nil {
@@ -139,16 +244,26 @@ func runTest(path, tmpdir string, target string, t *testing.T) {
 			t.Fatal("no emulator available for target:", target)
 		}
 		args := append(spec.Emulator[1:], binary)
+		if spec.Emulator[0] == "qemu-system-arm" {
+			// The kernel command line is passed using -append.
+			if len(testArgs) != 0 {
+				args = append(args, "-append", strings.Join(testArgs, " "))
+			}
+		} else {
+			args = append(args, testArgs...)
+		}
 		cmd = exec.Command(spec.Emulator[0], args...)
 	}
+	cmd.Env = append(os.Environ(), testEnv...)
 	stdout := &bytes.Buffer{}
 	cmd.Stdout = stdout
 	if target != "" {
 		cmd.Stderr = os.Stderr
 	}
 	err = cmd.Run()
-	if _, ok := err.(*exec.ExitError); ok && target != "" {
-		err = nil // workaround for QEMU
+	exitCode := 0
+	if err, ok := err.(*exec.ExitError); ok && err.Exited() {
+		exitCode = err.ExitCode()
 	}
 
 	// putchar() prints CRLF, convert it to LF.
@@ -156,9 +271,14 @@ func runTest(path, tmpdir string, target string, t *testing.T) {
 
 	// Check whether the command ran successfully.
 	fail := false
-	if err != nil {
+	if err != nil && exitCode == 0 {
 		t.Log("failed to run:", err)
 		fail = true
+	} else if exitCode != expectedExitCode && !(target == "qemu" && exitCode == 1 && expectedExitCode != 0) {
+		// QEMU versions that don't support the extended exit call of
+		// semihosting always exit with exit code 1 on failure.
+		t.Log("unexpected exit code:", exitCode)
+		fail = true
 	} else if !bytes.Equal(expected, actual) {
 		t.Log("output did not match")
 		fail = true
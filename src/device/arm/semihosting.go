@@ -30,6 +30,9 @@ const (
 	// Angel semihosting calls
 	SemihostingEnterSVC        = 0x17
 	SemihostingReportException = 0x18
+
+	// Semihosting v2 calls
+	SemihostingExitExtended = 0x20
 )
 
 // Special codes for the Angel Semihosting interface.
@@ -0,0 +1,38 @@
+package os
+
+import (
+	"io"
+)
+
+// Readdir reads the contents of the directory associated with file and returns
+// a slice of up to n FileInfo values, as would be returned by Lstat, in
+// directory order. Subsequent calls on the same file will yield further
+// FileInfos.
+//
+// If n > 0, Readdir returns at most n FileInfo structures. In this case, if
+// Readdir returns an empty slice, it will return a non-nil error explaining
+// why. At the end of a directory, the error is io.EOF.
+//
+// If n <= 0, Readdir returns all the FileInfo from the directory in a single
+// slice. In this case, if Readdir succeeds (reads all the way to the end of the
+// directory), it returns the slice and a nil error.
+func (f *File) Readdir(n int) ([]FileInfo, error) {
+	names, err := f.Readdirnames(n)
+	fi := make([]FileInfo, 0, len(names))
+	for _, filename := range names {
+		info, lerr := Lstat(f.name + "/" + filename)
+		if IsNotExist(lerr) {
+			// File disappeared between readdir and stat.
+			continue
+		}
+		if lerr != nil {
+			return fi, lerr
+		}
+		fi = append(fi, info)
+	}
+	if len(fi) == 0 && err == nil && n > 0 {
+		// Some files disappeared, there are no more entries to return.
+		err = io.EOF
+	}
+	return fi, err
+}
@@ -0,0 +1,71 @@
+// +build darwin linux
+
+package os
+
+import (
+	"io"
+	"syscall"
+)
+
+// The size of the buffer used to read directory entries.
+const blockSize = 4096
+
+// dirInfo stores the directory entries read but not yet returned by
+// Readdirnames.
+type dirInfo struct {
+	buf  []byte // buffer for directory I/O
+	nbuf int    // length of buf; return value from ReadDirent
+	bufp int    // location of next record in buf
+}
+
+// Readdirnames reads the contents of the directory associated with file and
+// returns a slice of up to n names of files in the directory, in directory
+// order. Subsequent calls on the same file will yield further names.
+//
+// If n > 0, Readdirnames returns at most n names. In this case, if Readdirnames
+// returns an empty slice, it will return a non-nil error explaining why. At
+// the end of a directory, the error is io.EOF.
+//
+// If n <= 0, Readdirnames returns all the names from the directory in a single
+// slice. In this case, if Readdirnames succeeds (reads all the way to the end
+// of the directory), it returns the slice and a nil error.
+func (f *File) Readdirnames(n int) (names []string, err error) {
+	if f == nil {
+		return nil, ErrInvalid
+	}
+	if f.dirinfo == nil {
+		f.dirinfo = &dirInfo{buf: make([]byte, blockSize)}
+	}
+	d := f.dirinfo
+
+	size := n
+	if size <= 0 {
+		size = 100
+		n = -1
+	}
+	names = make([]string, 0, size)
+	for n != 0 {
+		// Refill the buffer if necessary.
+		if d.bufp >= d.nbuf {
+			d.bufp = 0
+			d.nbuf, err = syscall.ReadDirent(int(f.fd), d.buf)
+			if err != nil {
+				d.nbuf = 0
+				return names, &PathError{"readdirent", f.name, err}
+			}
+			if d.nbuf <= 0 {
+				break // EOF
+			}
+		}
+
+		// Drain the buffer. The "." and ".." entries are skipped.
+		var nb, nc int
+		nb, nc, names = syscall.ParseDirent(d.buf[d.bufp:d.nbuf], n, names)
+		d.bufp += nb
+		n -= nc
+	}
+	if n >= 0 && len(names) == 0 {
+		return names, io.EOF
+	}
+	return names, nil
+}
@@ -0,0 +1,26 @@
+package os
+
+import (
+	"syscall"
+)
+
+// Getenv retrieves the value of the environment variable named by the key.
+// It returns the value, which will be empty if the variable is not present.
+func Getenv(key string) string {
+	v, _ := syscall.Getenv(key)
+	return v
+}
+
+// LookupEnv retrieves the value of the environment variable named by the key.
+// If the variable is present in the environment the value (which may be empty)
+// is returned and the boolean is true. Otherwise the returned value will be
+// empty and the boolean will be false.
+func LookupEnv(key string) (string, bool) {
+	return syscall.Getenv(key)
+}
+
+// Environ returns a copy of strings representing the environment, in the form
+// "key=value".
+func Environ() []string {
+	return syscall.Environ()
+}
@@ -0,0 +1,64 @@
+package os
+
+import (
+	"syscall"
+)
+
+// PathError records an error and the operation and file path that caused it.
+type PathError struct {
+	Op   string
+	Path string
+	Err  error
+}
+
+func (e *PathError) Error() string {
+	return e.Op + " " + e.Path + ": " + e.Err.Error()
+}
+
+// LinkError records an error during a link or symlink or rename system call
+// and the paths that caused it.
+type LinkError struct {
+	Op  string
+	Old string
+	New string
+	Err error
+}
+
+func (e *LinkError) Error() string {
+	return e.Op + " " + e.Old + " " + e.New + ": " + e.Err.Error()
+}
+
+// IsExist returns a boolean indicating whether the error is known to report
+// that a file or directory already exists. It is satisfied by ErrExist as well
+// as some syscall errors.
+func IsExist(err error) bool {
+	err = underlyingError(err)
+	return err == syscall.EEXIST || err == syscall.ENOTEMPTY || err == ErrExist
+}
+
+// IsNotExist returns a boolean indicating whether the error is known to report
+// that a file or directory does not exist. It is satisfied by ErrNotExist as
+// well as some syscall errors.
+func IsNotExist(err error) bool {
+	err = underlyingError(err)
+	return err == syscall.ENOENT || err == ErrNotExist
+}
+
+// IsPermission returns a boolean indicating whether the error is known to
+// report that permission is denied. It is satisfied by ErrPermission as well
+// as some syscall errors.
+func IsPermission(err error) bool {
+	err = underlyingError(err)
+	return err == syscall.EACCES || err == syscall.EPERM || err == ErrPermission
+}
+
+// underlyingError returns the underlying error for known os error types.
+func underlyingError(err error) error {
+	switch err := err.(type) {
+	case *PathError:
+		return err.Err
+	case *LinkError:
+		return err.Err
+	}
+	return err
+}
@@ -7,30 +7,80 @@ package os
 
 import (
 	"errors"
+	"syscall"
 )
 
 // Portable analogs of some common system call errors.
 var (
+	ErrInvalid     = errors.New("invalid argument")
+	ErrPermission  = errors.New("permission denied")
+	ErrExist       = errors.New("file already exists")
+	ErrNotExist    = errors.New("file does not exist")
+	ErrClosed      = errors.New("file already closed")
 	ErrUnsupported = errors.New("operation not supported")
 )
 
 // Stdin, Stdout, and Stderr are open Files pointing to the standard input,
 // standard output, and standard error file descriptors.
 var (
-	Stdin  = &File{0, "/dev/stdin"}
-	Stdout = &File{1, "/dev/stdout"}
-	Stderr = &File{2, "/dev/stderr"}
+	Stdin  = NewFile(0, "/dev/stdin")
+	Stdout = NewFile(1, "/dev/stdout")
+	Stderr = NewFile(2, "/dev/stderr")
+)
+
+// Flags to OpenFile wrapping those of the underlying system. Not all flags may
+// be implemented on a given system.
+const (
+	// Exactly one of O_RDONLY, O_WRONLY, or O_RDWR must be specified.
+	O_RDONLY int = syscall.O_RDONLY // open the file read-only.
+	O_WRONLY int = syscall.O_WRONLY // open the file write-only.
+	O_RDWR   int = syscall.O_RDWR   // open the file read-write.
+	// The remaining values may be or'ed in to control behavior.
+	O_APPEND int = syscall.O_APPEND // append data to the file when writing.
+	O_CREATE int = syscall.O_CREAT  // create a new file if none exists.
+	O_EXCL   int = syscall.O_EXCL   // used with O_CREATE, file must not exist.
+	O_SYNC   int = syscall.O_SYNC   // open for synchronous I/O.
+	O_TRUNC  int = syscall.O_TRUNC  // truncate regular writable file when opened.
+)
+
+// Seek whence values.
+const (
+	SEEK_SET int = 0 // seek relative to the origin of the file
+	SEEK_CUR int = 1 // seek relative to the current offset
+	SEEK_END int = 2 // seek relative to the end
 )
 
 // File represents an open file descriptor.
 type File struct {
-	fd   uintptr
-	name string
+	fd      uintptr
+	name    string
+	dirinfo *dirInfo // nil unless directory being read
+	offset  int64    // current offset, on systems that don't track it
 }
 
 // NewFile returns a new File with the given file descriptor and name.
 func NewFile(fd uintptr, name string) *File {
-	return &File{fd, name}
+	return &File{fd: fd, name: name}
+}
+
+// Open opens the named file for reading. If successful, methods on the
+// returned file can be used for reading; the associated file descriptor has
+// mode O_RDONLY. If there is an error, it will be of type *PathError.
+func Open(name string) (*File, error) {
+	return OpenFile(name, O_RDONLY, 0)
+}
+
+// Create creates the named file with mode 0666 (before umask), truncating it
+// if it already exists. If successful, methods on the returned File can be
+// used for I/O; the associated file descriptor has mode O_RDWR. If there is an
+// error, it will be of type *PathError.
+func Create(name string) (*File, error) {
+	return OpenFile(name, O_RDWR|O_CREATE|O_TRUNC, 0666)
+}
+
+// Name returns the name of the file as presented to Open.
+func (f *File) Name() string {
+	return f.name
 }
 
 // Fd returns the integer Unix file descriptor referencing the open file. The
@@ -0,0 +1,291 @@
+// +build qemu
+
+package os
+
+// This file implements files using ARM semihosting, which gives access to the
+// filesystem of the host when running under QEMU with the -semihosting flag.
+// Standard input is read from the console of the host, while standard output
+// and standard error are written to the UART just like the runtime does.
+//
+// Semihosting only supports a small subset of the operations on files.
+// Directories can't be created or read and the only file metadata available
+// is the length of a file. Paths are relative to the working directory of
+// QEMU.
+
+import (
+	"device/arm"
+	"io"
+	"syscall"
+	"unsafe"
+)
+
+// dirInfo is not used on this system, as directories can't be read.
+type dirInfo struct{}
+
+// Modes for SemihostingOpen. They correspond to the modes of fopen in C.
+const (
+	semihostingModeRead       = 1  // "rb"
+	semihostingModeReadWrite  = 3  // "r+b"
+	semihostingModeWrite      = 5  // "wb"
+	semihostingModeWriteRead  = 7  // "w+b"
+	semihostingModeAppend     = 9  // "ab"
+	semihostingModeAppendRead = 11 // "a+b"
+)
+
+// OpenFile is the generalized open call; most users will use Open or Create
+// instead. The flags are mapped to an fopen mode, so O_WRONLY without O_TRUNC
+// or O_APPEND also allows reading and O_TRUNC and O_APPEND create the file
+// even without O_CREATE. If there is an error, it will be of type *PathError.
+func OpenFile(name string, flag int, perm FileMode) (*File, error) {
+	if flag&(O_CREATE|O_EXCL) == O_CREATE|O_EXCL {
+		if fd := semihostingOpen(name, semihostingModeRead); fd >= 0 {
+			semihostingCall(arm.SemihostingClose, uintptr(fd))
+			return nil, &PathError{"open", name, syscall.EEXIST}
+		}
+	}
+	writable := flag&(O_WRONLY|O_RDWR) != 0
+	var mode uintptr
+	switch {
+	case flag&O_APPEND != 0 && flag&O_RDWR != 0:
+		mode = semihostingModeAppendRead
+	case flag&O_APPEND != 0:
+		mode = semihostingModeAppend
+	case flag&O_TRUNC != 0 && flag&O_RDWR != 0:
+		mode = semihostingModeWriteRead
+	case flag&O_TRUNC != 0 && writable:
+		mode = semihostingModeWrite
+	case writable:
+		mode = semihostingModeReadWrite
+	default:
+		mode = semihostingModeRead
+	}
+	fd := semihostingOpen(name, mode)
+	if fd < 0 && flag&O_CREATE != 0 && (mode == semihostingModeRead || mode == semihostingModeReadWrite) {
+		// These modes don't create the file. As it doesn't exist yet, it can
+		// be created by truncating it.
+		fd = semihostingOpen(name, semihostingModeWriteRead)
+	}
+	if fd < 0 {
+		return nil, &PathError{"open", name, semihostingErrno()}
+	}
+	return NewFile(uintptr(fd), name), nil
+}
+
+// Read reads up to len(b) bytes from the File. It returns the number of bytes
+// read and any error encountered. At end of file, Read returns 0, io.EOF.
+//
+// Reading from standard input returns at most one line, as every character is
+// read separately from the console of the host.
+func (f *File) Read(b []byte) (n int, err error) {
+	if f == nil {
+		return 0, ErrInvalid
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+	if f.fd == Stdin.fd {
+		for n < len(b) {
+			c := arm.SemihostingCall(arm.SemihostingReadByte, 0)
+			if c < 0 {
+				break
+			}
+			b[n] = byte(c)
+			n++
+			if c == '\n' {
+				break
+			}
+		}
+		if n == 0 {
+			return 0, io.EOF
+		}
+		return n, nil
+	}
+	notRead := semihostingCall(arm.SemihostingRead, f.fd, uintptr(unsafe.Pointer(&b[0])), uintptr(len(b)))
+	if notRead < 0 {
+		return 0, &PathError{"read", f.name, semihostingErrno()}
+	}
+	n = len(b) - notRead
+	if n == 0 {
+		return 0, io.EOF
+	}
+	f.offset += int64(n)
+	return n, nil
+}
+
+// Write writes len(b) bytes to the File. It returns the number of bytes written
+// and an error, if any. Write returns a non-nil error when n != len(b).
+func (f *File) Write(b []byte) (n int, err error) {
+	if f == nil {
+		return 0, ErrInvalid
+	}
+	switch f.fd {
+	case Stdout.fd, Stderr.fd:
+		for _, c := range b {
+			putchar(c)
+		}
+		return len(b), nil
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+	notWritten := semihostingCall(arm.SemihostingWrite, f.fd, uintptr(unsafe.Pointer(&b[0])), uintptr(len(b)))
+	if notWritten < 0 {
+		return 0, &PathError{"write", f.name, semihostingErrno()}
+	}
+	n = len(b) - notWritten
+	f.offset += int64(n)
+	if n != len(b) {
+		return n, io.ErrShortWrite
+	}
+	return n, nil
+}
+
+// Seek sets the offset for the next Read or Write on file to offset,
+// interpreted according to whence: 0 means relative to the origin of the file,
+// 1 means relative to the current offset, and 2 means relative to the end. It
+// returns the new offset and an error, if any.
+func (f *File) Seek(offset int64, whence int) (ret int64, err error) {
+	if f == nil {
+		return 0, ErrInvalid
+	}
+	switch whence {
+	case SEEK_SET:
+	case SEEK_CUR:
+		offset += f.offset
+	case SEEK_END:
+		length := semihostingCall(arm.SemihostingFileLen, f.fd)
+		if length < 0 {
+			return 0, &PathError{"seek", f.name, semihostingErrno()}
+		}
+		offset += int64(length)
+	default:
+		return 0, &PathError{"seek", f.name, syscall.EINVAL}
+	}
+	if offset < 0 {
+		return 0, &PathError{"seek", f.name, syscall.EINVAL}
+	}
+	if semihostingCall(arm.SemihostingSeek, f.fd, uintptr(offset)) != 0 {
+		return 0, &PathError{"seek", f.name, semihostingErrno()}
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+// Close closes the File, rendering it unusable for I/O. Closing standard
+// input, output or error does nothing, as these are not handles of the host.
+func (f *File) Close() error {
+	if f == nil {
+		return ErrInvalid
+	}
+	switch f.fd {
+	case Stdin.fd, Stdout.fd, Stderr.fd:
+		return nil
+	}
+	if semihostingCall(arm.SemihostingClose, f.fd) != 0 {
+		return &PathError{"close", f.name, semihostingErrno()}
+	}
+	return nil
+}
+
+// Stat returns the FileInfo structure describing file. Only the size of the
+// file is known, it is always reported as a regular file.
+func (f *File) Stat() (FileInfo, error) {
+	if f == nil {
+		return nil, ErrInvalid
+	}
+	length := semihostingCall(arm.SemihostingFileLen, f.fd)
+	if length < 0 {
+		return nil, &PathError{"stat", f.name, semihostingErrno()}
+	}
+	return &fileStat{name: basename(f.name), size: int64(length), mode: 0666}, nil
+}
+
+// Readdirnames is unsupported on this system.
+func (f *File) Readdirnames(n int) (names []string, err error) {
+	return nil, &PathError{"readdirent", f.name, ErrUnsupported}
+}
+
+// Stat returns a FileInfo describing the named file, see (*File).Stat. If there
+// is an error, it will be of type *PathError.
+func Stat(name string) (FileInfo, error) {
+	fd := semihostingOpen(name, semihostingModeRead)
+	if fd < 0 {
+		return nil, &PathError{"stat", name, semihostingErrno()}
+	}
+	f := NewFile(uintptr(fd), name)
+	info, err := f.Stat()
+	f.Close()
+	return info, err
+}
+
+// Lstat is the same as Stat, as symbolic links aren't visible through
+// semihosting.
+func Lstat(name string) (FileInfo, error) {
+	info, err := Stat(name)
+	if err != nil {
+		err.(*PathError).Op = "lstat"
+	}
+	return info, err
+}
+
+// Remove removes the named file. If there is an error, it will be of type
+// *PathError.
+func Remove(name string) error {
+	buf := semihostingString(name)
+	if semihostingCall(arm.SemihostingRemove, uintptr(unsafe.Pointer(&buf[0])), uintptr(len(name))) != 0 {
+		return &PathError{"remove", name, semihostingErrno()}
+	}
+	return nil
+}
+
+// Rename renames (moves) oldpath to newpath. If there is an error, it will be
+// of type *LinkError.
+func Rename(oldpath, newpath string) error {
+	oldbuf := semihostingString(oldpath)
+	newbuf := semihostingString(newpath)
+	if semihostingCall(arm.SemihostingRename, uintptr(unsafe.Pointer(&oldbuf[0])), uintptr(len(oldpath)), uintptr(unsafe.Pointer(&newbuf[0])), uintptr(len(newpath))) != 0 {
+		return &LinkError{"rename", oldpath, newpath, semihostingErrno()}
+	}
+	return nil
+}
+
+// Mkdir is unsupported on this system.
+func Mkdir(name string, perm FileMode) error {
+	return &PathError{"mkdir", name, ErrUnsupported}
+}
+
+// Getwd is unsupported on this system.
+func Getwd() (dir string, err error) {
+	return "", ErrUnsupported
+}
+
+// semihostingCall invokes the given semihosting operation with a parameter
+// block containing params.
+func semihostingCall(num int, params ...uintptr) int {
+	return arm.SemihostingCall(num, uintptr(unsafe.Pointer(&params[0])))
+}
+
+// semihostingOpen opens the named file with the given fopen mode and returns
+// the handle, or -1 on failure.
+func semihostingOpen(name string, mode uintptr) int {
+	buf := semihostingString(name)
+	return semihostingCall(arm.SemihostingOpen, uintptr(unsafe.Pointer(&buf[0])), mode, uintptr(len(name)))
+}
+
+// semihostingString returns the given string with a terminating NUL byte, as
+// expected by the host.
+func semihostingString(s string) []byte {
+	buf := make([]byte, len(s)+1)
+	copy(buf, s)
+	return buf
+}
+
+// semihostingErrno returns the error of the last failed semihosting call. The
+// error numbers of the host are assumed to be the same as the ones in package
+// syscall, which is the case on Linux.
+func semihostingErrno() error {
+	return syscall.Errno(arm.SemihostingCall(arm.SemihostingErrno, 0))
+}
+
+//go:linkname putchar runtime.putchar
+func putchar(c byte)
@@ -3,22 +3,142 @@
 package os
 
 import (
+	"io"
 	"syscall"
 )
 
+// OpenFile is the generalized open call; most users will use Open or Create
+// instead. It opens the named file with specified flag (O_RDONLY etc.) and
+// perm (before umask), if applicable. If successful, methods on the returned
+// File can be used for I/O. If there is an error, it will be of type
+// *PathError.
+func OpenFile(name string, flag int, perm FileMode) (*File, error) {
+	fd, err := syscall.Open(name, flag|syscall.O_CLOEXEC, syscallMode(perm))
+	if err != nil {
+		return nil, &PathError{"open", name, err}
+	}
+	return NewFile(uintptr(fd), name), nil
+}
+
 // Read reads up to len(b) bytes from the File. It returns the number of bytes
 // read and any error encountered. At end of file, Read returns 0, io.EOF.
 func (f *File) Read(b []byte) (n int, err error) {
-	return syscall.Read(int(f.fd), b)
+	if f == nil {
+		return 0, ErrInvalid
+	}
+	n, err = syscall.Read(int(f.fd), b)
+	if err != nil {
+		return 0, &PathError{"read", f.name, err}
+	}
+	if n == 0 && len(b) != 0 {
+		return 0, io.EOF
+	}
+	return n, nil
 }
 
 // Write writes len(b) bytes to the File. It returns the number of bytes written
 // and an error, if any. Write returns a non-nil error when n != len(b).
 func (f *File) Write(b []byte) (n int, err error) {
-	return syscall.Write(int(f.fd), b)
+	if f == nil {
+		return 0, ErrInvalid
+	}
+	n, err = syscall.Write(int(f.fd), b)
+	if err != nil {
+		return 0, &PathError{"write", f.name, err}
+	}
+	if n != len(b) {
+		return n, io.ErrShortWrite
+	}
+	return n, nil
+}
+
+// Seek sets the offset for the next Read or Write on file to offset,
+// interpreted according to whence: 0 means relative to the origin of the file,
+// 1 means relative to the current offset, and 2 means relative to the end. It
+// returns the new offset and an error, if any.
+func (f *File) Seek(offset int64, whence int) (ret int64, err error) {
+	if f == nil {
+		return 0, ErrInvalid
+	}
+	ret, err = syscall.Seek(int(f.fd), offset, whence)
+	if err != nil {
+		return 0, &PathError{"seek", f.name, err}
+	}
+	// Any buffered directory entries are no longer valid.
+	f.dirinfo = nil
+	return ret, nil
 }
 
 // Close closes the File, rendering it unusable for I/O.
 func (f *File) Close() error {
-	return syscall.Close(int(f.fd))
+	if f == nil {
+		return ErrInvalid
+	}
+	if err := syscall.Close(int(f.fd)); err != nil {
+		return &PathError{"close", f.name, err}
+	}
+	return nil
+}
+
+// Remove removes the named file or (empty) directory. If there is an error, it
+// will be of type *PathError.
+func Remove(name string) error {
+	// System call interface forces us to know whether name is a file or
+	// directory. Try both: it is cheaper on average than doing a Stat plus the
+	// right one.
+	err := syscall.Unlink(name)
+	if err == nil {
+		return nil
+	}
+	err1 := syscall.Rmdir(name)
+	if err1 == nil {
+		return nil
+	}
+	// Both failed: figure out which error to return. Rmdir only reports
+	// ENOTDIR when name is a file, in which case the Unlink error is the
+	// relevant one.
+	if err1 != syscall.ENOTDIR {
+		err = err1
+	}
+	return &PathError{"remove", name, err}
+}
+
+// Rename renames (moves) oldpath to newpath. If newpath already exists and is
+// not a directory, Rename replaces it. If there is an error, it will be of type
+// *LinkError.
+func Rename(oldpath, newpath string) error {
+	if err := syscall.Rename(oldpath, newpath); err != nil {
+		return &LinkError{"rename", oldpath, newpath, err}
+	}
+	return nil
+}
+
+// Mkdir creates a new directory with the specified name and permission bits
+// (before umask). If there is an error, it will be of type *PathError.
+func Mkdir(name string, perm FileMode) error {
+	if err := syscall.Mkdir(name, syscallMode(perm)); err != nil {
+		return &PathError{"mkdir", name, err}
+	}
+	return nil
+}
+
+// Getwd returns a rooted path name corresponding to the current directory.
+func Getwd() (dir string, err error) {
+	return syscall.Getwd()
+}
+
+// syscallMode returns the syscall-specific mode bits from Go's portable mode
+// bits.
+func syscallMode(i FileMode) (o uint32) {
+	o |= uint32(i.Perm())
+	if i&ModeSetuid != 0 {
+		o |= syscall.S_ISUID
+	}
+	if i&ModeSetgid != 0 {
+		o |= syscall.S_ISGID
+	}
+	if i&ModeSticky != 0 {
+		o |= syscall.S_ISVTX
+	}
+	return
 }
@@ -1,4 +1,4 @@
-// +build wasm
+// +build wasm,!qemu
 
 package os
 
@@ -6,6 +6,14 @@ import (
 	_ "unsafe"
 )
 
+// dirInfo is not used on this system, as directories can't be read.
+type dirInfo struct{}
+
+// OpenFile is unsupported on this system.
+func OpenFile(name string, flag int, perm FileMode) (*File, error) {
+	return nil, &PathError{"open", name, ErrUnsupported}
+}
+
 // Read is unsupported on this system.
 func (f *File) Read(b []byte) (n int, err error) {
 	return 0, ErrUnsupported
@@ -25,10 +33,55 @@ func (f *File) Write(b []byte) (n int, err error) {
 	}
 }
 
+// Seek is unsupported on this system.
+func (f *File) Seek(offset int64, whence int) (ret int64, err error) {
+	return 0, &PathError{"seek", f.name, ErrUnsupported}
+}
+
 // Close is unsupported on this system.
 func (f *File) Close() error {
 	return ErrUnsupported
 }
 
+// Stat is unsupported on this system.
+func (f *File) Stat() (FileInfo, error) {
+	return nil, &PathError{"stat", f.name, ErrUnsupported}
+}
+
+// Readdirnames is unsupported on this system.
+func (f *File) Readdirnames(n int) (names []string, err error) {
+	return nil, &PathError{"readdirent", f.name, ErrUnsupported}
+}
+
+// Stat is unsupported on this system.
+func Stat(name string) (FileInfo, error) {
+	return nil, &PathError{"stat", name, ErrUnsupported}
+}
+
+// Lstat is unsupported on this system.
+func Lstat(name string) (FileInfo, error) {
+	return nil, &PathError{"lstat", name, ErrUnsupported}
+}
+
+// Remove is unsupported on this system.
+func Remove(name string) error {
+	return &PathError{"remove", name, ErrUnsupported}
+}
+
+// Rename is unsupported on this system.
+func Rename(oldpath, newpath string) error {
+	return &LinkError{"rename", oldpath, newpath, ErrUnsupported}
+}
+
+// Mkdir is unsupported on this system.
+func Mkdir(name string, perm FileMode) error {
+	return &PathError{"mkdir", name, ErrUnsupported}
+}
+
+// Getwd is unsupported on this system.
+func Getwd() (dir string, err error) {
+	return "", ErrUnsupported
+}
+
 //go:linkname putchar runtime.putchar
 func putchar(c byte)
@@ -0,0 +1,11 @@
+package os
+
+import (
+	"syscall"
+	"time"
+)
+
+// modTime returns the modification time of a file.
+func modTime(st *syscall.Stat_t) time.Time {
+	return time.Unix(int64(st.Mtim.Sec), int64(st.Mtim.Nsec))
+}
@@ -0,0 +1,79 @@
+// +build darwin linux
+
+package os
+
+import (
+	"syscall"
+)
+
+// Stat returns a FileInfo describing the named file. If there is an error, it
+// will be of type *PathError.
+func Stat(name string) (FileInfo, error) {
+	var stat syscall.Stat_t
+	if err := syscall.Stat(name, &stat); err != nil {
+		return nil, &PathError{"stat", name, err}
+	}
+	return newFileStat(name, &stat), nil
+}
+
+// Lstat returns a FileInfo describing the named file. If the file is a
+// symbolic link, the returned FileInfo describes the symbolic link. Lstat makes
+// no attempt to follow the link. If there is an error, it will be of type
+// *PathError.
+func Lstat(name string) (FileInfo, error) {
+	var stat syscall.Stat_t
+	if err := syscall.Lstat(name, &stat); err != nil {
+		return nil, &PathError{"lstat", name, err}
+	}
+	return newFileStat(name, &stat), nil
+}
+
+// Stat returns the FileInfo structure describing file. If there is an error, it
+// will be of type *PathError.
+func (f *File) Stat() (FileInfo, error) {
+	if f == nil {
+		return nil, ErrInvalid
+	}
+	var stat syscall.Stat_t
+	if err := syscall.Fstat(int(f.fd), &stat); err != nil {
+		return nil, &PathError{"stat", f.name, err}
+	}
+	return newFileStat(f.name, &stat), nil
+}
+
+// newFileStat converts the result of a stat system call to a FileInfo.
+func newFileStat(name string, st *syscall.Stat_t) *fileStat {
+	fs := &fileStat{
+		name:    basename(name),
+		size:    st.Size,
+		mode:    FileMode(st.Mode & 0777),
+		modTime: modTime(st),
+		sys:     st,
+	}
+	switch st.Mode & syscall.S_IFMT {
+	case syscall.S_IFBLK:
+		fs.mode |= ModeDevice
+	case syscall.S_IFCHR:
+		fs.mode |= ModeDevice | ModeCharDevice
+	case syscall.S_IFDIR:
+		fs.mode |= ModeDir
+	case syscall.S_IFIFO:
+		fs.mode |= ModeNamedPipe
+	case syscall.S_IFLNK:
+		fs.mode |= ModeSymlink
+	case syscall.S_IFREG:
+		// nothing to do
+	case syscall.S_IFSOCK:
+		fs.mode |= ModeSocket
+	}
+	if st.Mode&syscall.S_ISGID != 0 {
+		fs.mode |= ModeSetgid
+	}
+	if st.Mode&syscall.S_ISUID != 0 {
+		fs.mode |= ModeSetuid
+	}
+	if st.Mode&syscall.S_ISVTX != 0 {
+		fs.mode |= ModeSticky
+	}
+	return fs
+}
@@ -0,0 +1,359 @@
+package reflect
+
+// This file implements reflection on maps, using the hashmap implementation of
+// the runtime (see src/runtime/hashmap.go).
+
+import (
+	"unsafe"
+)
+
+// The hash and equality functions of a map key, as passed to the hashmap
+// functions of the runtime.
+type (
+	keyHashFunc  func(key unsafe.Pointer, n uintptr) uint32
+	keyEqualFunc func(x, y unsafe.Pointer, n uintptr) bool
+)
+
+// Same as runtime.hashmapIterator.
+type hashmapIterator struct {
+	buckets      unsafe.Pointer
+	numBuckets   uintptr
+	bucketNumber uintptr
+	bucket       unsafe.Pointer
+	bucketIndex  uint8
+}
+
+// MapIter is an iterator for ranging over a map. See Value.MapRange.
+type MapIter struct {
+	m     Value
+	it    hashmapIterator
+	key   unsafe.Pointer
+	value unsafe.Pointer
+	valid bool
+}
+
+// MapRange returns a range iterator for a map. Call Next to advance the
+// iterator and Key/Value to access each entry.
+func (v Value) MapRange() *MapIter {
+	if v.Kind() != Map {
+		panic(&ValueError{"MapRange"})
+	}
+	return &MapIter{
+		m:     v,
+		key:   alloc(v.Type().Key().Size()),
+		value: alloc(v.Type().Elem().Size()),
+	}
+}
+
+// Next advances the map iterator and reports whether there is another entry.
+// It returns false when the iterator is exhausted.
+func (it *MapIter) Next() bool {
+	keyHash, keyEqual := mapKeyFuncs(it.m.Type().Key())
+	it.valid = hashmapNext(it.m.mapPointer(), unsafe.Pointer(&it.it), it.key, it.value, keyHash, keyEqual)
+	return it.valid
+}
+
+// Key returns the key of the current map entry.
+func (it *MapIter) Key() Value {
+	if !it.valid {
+		panic("reflect: MapIter.Key called on exhausted iterator")
+	}
+	return loadNewValue(it.m.Type().Key(), it.key, it.m.readonly)
+}
+
+// Value returns the value of the current map entry.
+func (it *MapIter) Value() Value {
+	if !it.valid {
+		panic("reflect: MapIter.Value called on exhausted iterator")
+	}
+	return loadNewValue(it.m.Type().Elem(), it.value, it.m.readonly)
+}
+
+// MapKeys returns a slice with all the keys in the map, in unspecified order.
+func (v Value) MapKeys() []Value {
+	if v.Kind() != Map {
+		panic(&ValueError{"MapKeys"})
+	}
+	keys := make([]Value, 0, v.Len())
+	it := v.MapRange()
+	for it.Next() {
+		keys = append(keys, it.Key())
+	}
+	return keys
+}
+
+// MapIndex returns the value associated with key in the map, or the zero Value
+// if the key is not present in the map.
+func (v Value) MapIndex(key Value) Value {
+	if v.Kind() != Map {
+		panic(&ValueError{"MapIndex"})
+	}
+	keyType := v.Type().Key()
+	key, ok := key.assignTo(keyType)
+	if !ok {
+		panic("reflect: map key of the wrong type")
+	}
+	m := v.mapPointer()
+	if hashmapLen(m) == 0 {
+		// This is a nil map or an empty map, which may not have any buckets.
+		return Value{}
+	}
+	keyPtr := key.pointer()
+	keyHash, keyEqual := mapKeyFuncs(keyType)
+	elemType := v.Type().Elem()
+	value := alloc(elemType.Size())
+	if !hashmapGet(m, keyPtr, value, keyHash(keyPtr, keyType.Size()), keyEqual) {
+		return Value{}
+	}
+	return loadNewValue(elemType, value, v.readonly)
+}
+
+// SetMapIndex sets the value associated with key in the map to elem. If elem
+// is the zero Value, the key is deleted from the map instead.
+func (v Value) SetMapIndex(key, elem Value) {
+	if v.Kind() != Map {
+		panic(&ValueError{"SetMapIndex"})
+	}
+	if v.readonly {
+		panic("reflect: map obtained using unexported field cannot be changed")
+	}
+	keyType := v.Type().Key()
+	key, ok := key.assignTo(keyType)
+	if !ok {
+		panic("reflect: map key of the wrong type")
+	}
+	m := v.mapPointer()
+	keyPtr := key.pointer()
+	keyHash, keyEqual := mapKeyFuncs(keyType)
+	if !elem.IsValid() {
+		if hashmapLen(m) == 0 {
+			// Nothing to delete.
+			return
+		}
+		hashmapDelete(m, keyPtr, keyHash(keyPtr, keyType.Size()), keyEqual)
+		return
+	}
+	elem, ok = elem.assignTo(v.Type().Elem())
+	if !ok {
+		panic("reflect: map element of the wrong type")
+	}
+	if m == nil {
+		panic("assignment to entry in nil map")
+	}
+	hashmapSet(m, keyPtr, elem.pointer(), keyHash(keyPtr, keyType.Size()), keyHash, keyEqual)
+}
+
+// MakeMap creates a new map with the specified type.
+func MakeMap(typ Type) Value {
+	if typ.Kind() != Map {
+		panic("reflect: MakeMap of non-map type")
+	}
+	return Value{
+		typecode: typ,
+		value:    hashmapMake(uint8(typ.Key().Size()), uint8(typ.Elem().Size())),
+	}
+}
+
+// mapPointer returns the underlying *runtime.hashmap of a map value.
+func (v Value) mapPointer() unsafe.Pointer {
+	if v.indirect {
+		return *(*unsafe.Pointer)(v.value)
+	}
+	return v.value
+}
+
+// pointer returns a pointer to the memory of this value, copying it to a new
+// memory location if it is stored directly in v.value.
+func (v Value) pointer() unsafe.Pointer {
+	if v.isIndirect() {
+		return v.value
+	}
+	ptr := alloc(unsafe.Sizeof(uintptr(0)))
+	*(*unsafe.Pointer)(ptr) = v.value
+	return ptr
+}
+
+// loadNewValue returns a non-addressable Value of the given type that is
+// stored at the given memory location. The memory must not be modified
+// afterwards. The value is read-only if it was obtained through an unexported
+// struct field.
+func loadNewValue(typ Type, ptr unsafe.Pointer, readonly bool) Value {
+	if typ.Size() <= unsafe.Sizeof(uintptr(0)) {
+		ptr = loadValue(ptr, typ.Size())
+	}
+	return Value{
+		typecode: typ,
+		value:    ptr,
+		readonly: readonly,
+	}
+}
+
+// mapKeyFuncs returns the hash and equality functions for map keys of the
+// given type. They are the same functions as the compiler uses for this key
+// type, see compiler/map.go.
+func mapKeyFuncs(keyType Type) (keyHashFunc, keyEqualFunc) {
+	switch {
+	case isBinaryKey(keyType):
+		return hashmapHash, memequal
+	case keyType.Kind() == String:
+		return hashmapStringPtrHash, hashmapStringEqual
+	default:
+		keyHash := func(key unsafe.Pointer, n uintptr) uint32 {
+			return hashKey(keyType, key)
+		}
+		keyEqual := func(x, y unsafe.Pointer, n uintptr) bool {
+			return keysEqual(keyType, x, y)
+		}
+		return keyHash, keyEqual
+	}
+}
+
+// isBinaryKey returns true if this key type can be hashed and compared as
+// plain binary data.
+func isBinaryKey(t Type) bool {
+	switch t.Kind() {
+	case Bool, Int, Int8, Int16, Int32, Int64, Uint, Uint8, Uint16, Uint32, Uint64, Uintptr:
+		return true
+	case Array:
+		return isBinaryKey(t.Elem())
+	case Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if !isBinaryKey(t.Field(i).Type) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// hashKey calculates the hash of a map key that cannot be hashed as plain
+// binary data, the same way as the hash function generated by the compiler.
+func hashKey(t Type, key unsafe.Pointer) uint32 {
+	switch t.Kind() {
+	case String:
+		return hashmapStringHash(*(*string)(key))
+	case Float32:
+		return hashmapFloat32Hash(*(*float32)(key))
+	case Float64:
+		return hashmapFloat64Hash(*(*float64)(key))
+	case Complex64:
+		c := *(*complex64)(key)
+		return hashmapHashCombine(hashmapFloat32Hash(real(c)), hashmapFloat32Hash(imag(c)))
+	case Complex128:
+		c := *(*complex128)(key)
+		return hashmapHashCombine(hashmapFloat64Hash(real(c)), hashmapFloat64Hash(imag(c)))
+	case Interface:
+		return hashmapInterfaceHash(*(*interface{})(key))
+	case Array:
+		var hash uint32 = 2166136261 // FNV offset basis
+		elemType := t.Elem()
+		for i := 0; i < t.Len(); i++ {
+			elem := unsafe.Pointer(uintptr(key) + elemType.Size()*uintptr(i))
+			hash = hashmapHashCombine(hash, hashKey(elemType, elem))
+		}
+		return hash
+	case Struct:
+		var hash uint32 = 2166136261 // FNV offset basis
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.Name == "_" {
+				// Blank fields are ignored in comparisons.
+				continue
+			}
+			hash = hashmapHashCombine(hash, hashKey(field.Type, unsafe.Pointer(uintptr(key)+field.Offset)))
+		}
+		return hash
+	default:
+		// Booleans, integers, pointers, channels.
+		return hashmapHash(key, t.Size())
+	}
+}
+
+// keysEqual compares two map keys that cannot be compared as plain binary
+// data, the same way as the == operator.
+func keysEqual(t Type, x, y unsafe.Pointer) bool {
+	switch t.Kind() {
+	case String:
+		return *(*string)(x) == *(*string)(y)
+	case Float32:
+		return *(*float32)(x) == *(*float32)(y)
+	case Float64:
+		return *(*float64)(x) == *(*float64)(y)
+	case Complex64:
+		return *(*complex64)(x) == *(*complex64)(y)
+	case Complex128:
+		return *(*complex128)(x) == *(*complex128)(y)
+	case Interface:
+		return *(*interface{})(x) == *(*interface{})(y)
+	case Array:
+		elemType := t.Elem()
+		for i := 0; i < t.Len(); i++ {
+			offset := elemType.Size() * uintptr(i)
+			if !keysEqual(elemType, unsafe.Pointer(uintptr(x)+offset), unsafe.Pointer(uintptr(y)+offset)) {
+				return false
+			}
+		}
+		return true
+	case Struct:
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.Name == "_" {
+				continue
+			}
+			if !keysEqual(field.Type, unsafe.Pointer(uintptr(x)+field.Offset), unsafe.Pointer(uintptr(y)+field.Offset)) {
+				return false
+			}
+		}
+		return true
+	default:
+		// Booleans, integers, pointers, channels.
+		return memequal(x, y, t.Size())
+	}
+}
+
+//go:linkname alloc runtime.alloc
+func alloc(size uintptr) unsafe.Pointer
+
+//go:linkname memequal runtime.memequal
+func memequal(x, y unsafe.Pointer, n uintptr) bool
+
+//go:linkname hashmapHash runtime.hashmapHash
+func hashmapHash(ptr unsafe.Pointer, n uintptr) uint32
+
+//go:linkname hashmapHashCombine runtime.hashmapHashCombine
+func hashmapHashCombine(hash, partHash uint32) uint32
+
+//go:linkname hashmapStringHash runtime.hashmapStringHash
+func hashmapStringHash(s string) uint32
+
+//go:linkname hashmapStringPtrHash runtime.hashmapStringPtrHash
+func hashmapStringPtrHash(sptr unsafe.Pointer, n uintptr) uint32
+
+//go:linkname hashmapStringEqual runtime.hashmapStringEqual
+func hashmapStringEqual(x, y unsafe.Pointer, n uintptr) bool
+
+//go:linkname hashmapFloat32Hash runtime.hashmapFloat32Hash
+func hashmapFloat32Hash(f float32) uint32
+
+//go:linkname hashmapFloat64Hash runtime.hashmapFloat64Hash
+func hashmapFloat64Hash(f float64) uint32
+
+//go:linkname hashmapInterfaceHash runtime.hashmapInterfaceHash
+func hashmapInterfaceHash(itf interface{}) uint32
+
+// The following functions are implemented in the runtime, see
+// src/runtime/hashmap.go.
+
+func hashmapMake(keySize, valueSize uint8) unsafe.Pointer
+
+func hashmapLen(m unsafe.Pointer) int
+
+func hashmapSet(m unsafe.Pointer, key, value unsafe.Pointer, hash uint32, keyHash func(key unsafe.Pointer, n uintptr) uint32, keyEqual func(x, y unsafe.Pointer, n uintptr) bool)
+
+func hashmapGet(m unsafe.Pointer, key, value unsafe.Pointer, hash uint32, keyEqual func(x, y unsafe.Pointer, n uintptr) bool) bool
+
+func hashmapDelete(m unsafe.Pointer, key unsafe.Pointer, hash uint32, keyEqual func(x, y unsafe.Pointer, n uintptr) bool)
+
+func hashmapNext(m unsafe.Pointer, it unsafe.Pointer, key, value unsafe.Pointer, keyHash func(key unsafe.Pointer, n uintptr) uint32, keyEqual func(x, y unsafe.Pointer, n uintptr) bool) bool
@@ -21,11 +21,49 @@ import (
 //             6 (1101): Map
 //             7 (1111): Struct
 //         The higher bits are either the contents of the type depending on the
-//         type (if n is clear) or indicate the number of the named type (if n
-//         is set).
+//         type (if n is clear) or an index into the type side table (if n is
+//         set). The side table is used for named types and for types that
+//         would otherwise not fit in a type code, which is common on systems
+//         with 16-bit pointers. Each entry in the side table contains what
+//         would otherwise be stored in the higher bits.
+//
+// The contents of a type are the element type for Chan, Ptr and Slice types
+// and an index into the side table for Array and Struct types, where the
+// compiler stores metadata about these types:
+//     Array:  element type, length
+//     Map:    key type, element type
+//     Struct: number of fields, size, and for each field: type, offset, name
+//             (*string), package path (*string or 0 if exported) and whether
+//             it is embedded (0 or 1)
+// Other types contain a unique number.
+//
+// Not every program fits in this compact encoding, especially on systems with
+// 16-bit pointers. The compiler then falls back to a wide encoding, in which
+// only unnamed basic types are stored directly in the type code:
+// xxxxx0: unnamed basic types, as above.
+//      1: all other types, where the higher bits are an index into the side
+//         table. The type is described by two entries there: the kind shifted
+//         left by one (with the lowest bit set for named types) and the
+//         contents of the type as described above.
 
 type Kind uintptr
 
+// The type side table is created by the compiler, see compiler/reflect.go.
+//
+//go:extern reflect.typeSidetable
+var typeSidetable uintptr
+
+// Whether the compiler used the wide encoding for type codes.
+//
+//go:extern reflect.wideTypeCodes
+var wideTypeCodes bool
+
+// Unnamed Chan, Ptr and Slice types that are stored in the side table,
+// terminated by a zero type code. They are looked up by PtrTo and SliceOf.
+//
+//go:extern reflect.compositeTypes
+var compositeTypes Type
+
 // Copied from reflect/type.go
 // https://golang.org/src/reflect/type.go?s=8302:8316#L217
 const (
@@ -134,40 +172,132 @@ func (t Type) String() string {
 }
 
 func (t Type) Kind() Kind {
-	if t % 2 == 0 {
+	if t%2 == 0 {
 		// basic type
 		return Kind((t >> 1) % 32)
+	} else if wideTypeCodes {
+		return Kind(readSidetable(uintptr(t>>1)) >> 1)
 	} else {
-		return Kind(t >> 1) % 8 + 19
+		return Kind(t>>1)%8 + 19
 	}
 }
 
 func (t Type) Elem() Type {
 	switch t.Kind() {
 	case Chan, Ptr, Slice:
-		if (t >> 4) % 2 != 0 {
-			panic("unimplemented: (reflect.Type).Elem() for named types")
+		return Type(t.contents())
+	case Array:
+		return Type(readSidetable(t.contents()))
+	case Map:
+		return Type(readSidetable(t.contents() + 1))
+	default:
+		panic(&TypeError{"Elem"})
+	}
+}
+
+func (t Type) Key() Type {
+	if t.Kind() != Map {
+		panic(&TypeError{"Key"})
+	}
+	return Type(readSidetable(t.contents()))
+}
+
+// PtrTo returns the pointer type with element t.
+func PtrTo(t Type) Type {
+	return t.compositeType(Ptr)
+}
+
+// SliceOf returns the slice type with element t.
+func SliceOf(t Type) Type {
+	return t.compositeType(Slice)
+}
+
+// compositeType returns the type code of an unnamed Chan, Ptr or Slice type
+// with this element type, the same way the compiler would have encoded it.
+// Types that are stored in the side table can only be found when the program
+// uses them. The compiler makes sure this is the case for the types created by
+// Value.Addr and Value.Slice, but otherwise the invalid type is returned.
+func (t Type) compositeType(kind Kind) Type {
+	if !wideTypeCodes && t>>(unsafe.Sizeof(t)*8-5) == 0 {
+		return t<<5 | Type(kind-Chan)<<1 | 1
+	}
+	for i := uintptr(0); ; i++ {
+		u := *(*Type)(unsafe.Pointer(uintptr(unsafe.Pointer(&compositeTypes)) + i*unsafe.Sizeof(Type(0))))
+		if u == 0 {
+			return 0
+		}
+		if u.Kind() == kind && u.contents() == uintptr(t) {
+			return u
 		}
-		return t >> 5
-	default: // not implemented: Array, Map
-		panic("unimplemented: (reflect.Type).Elem()")
 	}
 }
 
+// contents returns the contents of a non-basic type, reading it from the side
+// table for named types and for all types in the wide encoding.
+func (t Type) contents() uintptr {
+	if wideTypeCodes {
+		return readSidetable(uintptr(t>>1) + 1)
+	}
+	if (t>>4)%2 != 0 {
+		return readSidetable(uintptr(t >> 5))
+	}
+	return uintptr(t >> 5)
+}
+
+// readSidetable returns the value stored in the type side table at the given
+// index.
+func readSidetable(index uintptr) uintptr {
+	return *(*uintptr)(unsafe.Pointer(uintptr(unsafe.Pointer(&typeSidetable)) + index*unsafe.Sizeof(uintptr(0))))
+}
+
+// readSidetableString returns the string pointed to by the value in the type
+// side table at the given index, or "" if it is 0.
+func readSidetableString(index uintptr) string {
+	ptr := readSidetable(index)
+	if ptr == 0 {
+		return ""
+	}
+	return *(*string)(unsafe.Pointer(ptr))
+}
+
 func (t Type) Field(i int) StructField {
-	panic("unimplemented: (reflect.Type).Field()")
+	if t.Kind() != Struct {
+		panic(&TypeError{"Field"})
+	}
+	if uint(i) >= uint(t.NumField()) {
+		panic("reflect: field index out of range")
+	}
+	index := t.contents() + 2 + uintptr(i)*5
+	return StructField{
+		Name:      readSidetableString(index + 2),
+		PkgPath:   readSidetableString(index + 3),
+		Type:      Type(readSidetable(index)),
+		Offset:    readSidetable(index + 1),
+		Anonymous: readSidetable(index+4) != 0,
+	}
 }
 
 func (t Type) Bits() int {
-	panic("unimplemented: (reflect.Type).Bits()")
+	switch t.Kind() {
+	case Int, Int8, Int16, Int32, Int64, Uint, Uint8, Uint16, Uint32, Uint64, Uintptr, Float32, Float64, Complex64, Complex128:
+		return int(t.Size()) * 8
+	default:
+		panic(&TypeError{"Bits"})
+	}
 }
 
 func (t Type) Len() int {
-	panic("unimplemented: (reflect.Type).Len()")
+	if t.Kind() != Array {
+		panic(&TypeError{"Len"})
+	}
+	return int(readSidetable(t.contents() + 1))
 }
 
 func (t Type) NumField() int {
-	panic("unimplemented: (reflect.Type).NumField()")
+	if t.Kind() != Struct {
+		panic(&TypeError{"NumField"})
+	}
+	return int(readSidetable(t.contents()))
 }
 
 func (t Type) Size() uintptr {
@@ -198,12 +328,104 @@ func (t Type) Size() uintptr {
 		return unsafe.Sizeof(uintptr(0))
 	case Slice:
 		return unsafe.Sizeof(SliceHeader{})
+	case Interface:
+		return unsafe.Sizeof(interfaceHeader{})
+	case Func:
+		return unsafe.Sizeof(funcHeader{})
+	case Array:
+		return t.Elem().Size() * uintptr(t.Len())
+	case Struct:
+		return readSidetable(t.contents() + 1)
 	default:
 		panic("unimplemented: size of type")
 	}
 }
 
+// isNamed returns whether this is a named type. In the compact encoding,
+// unnamed types that are stored in the side table because their type code
+// would not fit otherwise cannot be told apart from named types, so they are
+// reported as named as well.
+func (t Type) isNamed() bool {
+	if t%2 == 0 {
+		// Basic type: the bits above the kind contain the name ID.
+		return t>>6 != 0
+	}
+	if wideTypeCodes {
+		return readSidetable(uintptr(t>>1))%2 != 0
+	}
+	return (t>>4)%2 != 0
+}
+
+// assignableTo returns whether a value of this type can be assigned to a
+// variable of type u, following the assignability rules of the Go
+// specification. Method sets are not available at runtime, so any value is
+// considered to be assignable to any interface type.
+func (t Type) assignableTo(u Type) bool {
+	if t == u {
+		return true
+	}
+	if u.Kind() == Interface {
+		return true
+	}
+	if t.isNamed() && u.isNamed() {
+		return false
+	}
+	return t.hasIdenticalUnderlyingType(u)
+}
+
+// hasIdenticalUnderlyingType returns whether the underlying types of t and u
+// are identical. Identical types have the same type code, except that a named
+// type and its unnamed equivalent differ.
+func (t Type) hasIdenticalUnderlyingType(u Type) bool {
+	if t.Kind() != u.Kind() {
+		return false
+	}
+	switch t.Kind() {
+	case Chan, Ptr, Slice:
+		return t.Elem() == u.Elem()
+	case Array:
+		return t.Len() == u.Len() && t.Elem() == u.Elem()
+	case Map:
+		return t.Key() == u.Key() && t.Elem() == u.Elem()
+	case Struct:
+		if t.NumField() != u.NumField() {
+			return false
+		}
+		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i) != u.Field(i) {
+				return false
+			}
+		}
+		return true
+	case Interface, Func:
+		// These types are only distinguished by a unique number, so their
+		// contents cannot be compared.
+		return false
+	default:
+		// Basic types.
+		return true
+	}
+}
+
 type StructField struct {
+	// Name indicates the field name.
 	Name string
-	Type Type
+
+	// PkgPath is the package path where the struct containing this field is
+	// declared for unexported fields, or the empty string for exported fields.
+	PkgPath string
+
+	Type      Type
+	Offset    uintptr
+	Anonymous bool
+}
+
+// TypeError is the error that is used in a panic when invoking a method on a
+// type that is not applicable to that type.
+type TypeError struct {
+	Method string
+}
+
+func (e *TypeError) Error() string {
+	return "reflect: call of reflect.Type." + e.Method + "() on invalid type"
 }
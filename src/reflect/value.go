@@ -8,6 +8,7 @@ type Value struct {
 	typecode Type
 	value    unsafe.Pointer
 	indirect bool
+	readonly bool // obtained through an unexported struct field
 }
 
 func Indirect(v Value) Value {
@@ -26,6 +27,9 @@ func ValueOf(i interface{}) Value {
 }
 
 func (v Value) Interface() interface{} {
+	if v.readonly {
+		panic("reflect: cannot return value obtained from unexported field")
+	}
 	i := interfaceHeader{
 		typecode: v.typecode,
 		value:    v.value,
@@ -33,15 +37,57 @@ func (v Value) Interface() interface{} {
 	if v.indirect && v.Type().Size() <= unsafe.Sizeof(uintptr(0)) {
 		// Value was indirect but must be put back directly in the interface
 		// value.
-		var value uintptr
-		for j := v.Type().Size(); j != 0; j-- {
-			value = (value << 8) | uintptr(*(*uint8)(unsafe.Pointer(uintptr(v.value) + j - 1)))
-		}
-		i.value = unsafe.Pointer(value)
+		i.value = loadValue(v.value, v.Type().Size())
 	}
 	return *(*interface{})(unsafe.Pointer(&i))
 }
 
+// isIndirect returns whether the value is stored in memory pointed to by
+// v.value, instead of directly in v.value as small values in an interface are.
+func (v Value) isIndirect() bool {
+	return v.indirect || v.Type().Size() > unsafe.Sizeof(uintptr(0))
+}
+
+// subValue returns a part (struct field or array element) of this value, at
+// the given offset. The resulting value is addressable if this value is.
+func (v Value) subValue(typecode Type, offset uintptr) Value {
+	sub := Value{
+		typecode: typecode,
+		indirect: v.indirect,
+		readonly: v.readonly,
+	}
+	size := typecode.Size()
+	if !v.isIndirect() {
+		// The value is stored directly in v.value, which means this part of
+		// it is small enough to be stored directly as well. Extract it by
+		// shifting out the bits (assuming a little-endian system).
+		if size != 0 {
+			bits := uintptr(v.value) >> (offset * 8)
+			if size < unsafe.Sizeof(uintptr(0)) {
+				bits &= 1<<(size*8) - 1
+			}
+			sub.value = unsafe.Pointer(bits)
+		}
+	} else if !v.indirect && size <= unsafe.Sizeof(uintptr(0)) {
+		// The value is not addressable, so small values must be stored
+		// directly in the new value.
+		sub.value = loadValue(unsafe.Pointer(uintptr(v.value)+offset), size)
+	} else {
+		sub.value = unsafe.Pointer(uintptr(v.value) + offset)
+	}
+	return sub
+}
+
+// loadValue loads a value of the given size (at most the size of a pointer)
+// from memory, in the form it is stored directly in an interface value.
+func loadValue(ptr unsafe.Pointer, size uintptr) unsafe.Pointer {
+	var value uintptr
+	for j := size; j != 0; j-- {
+		value = (value << 8) | uintptr(*(*uint8)(unsafe.Pointer(uintptr(ptr) + j - 1)))
+	}
+	return unsafe.Pointer(value)
+}
+
 func (v Value) Type() Type {
 	return v.typecode
 }
@@ -92,24 +138,41 @@ func (v Value) Pointer() uintptr {
 }
 
 func (v Value) IsValid() bool {
-	panic("unimplemented: (reflect.Value).IsValid()")
+	return v.typecode != 0
 }
 
 func (v Value) CanInterface() bool {
-	// No Value types of private data can be constructed at the moment.
-	return true
+	return !v.readonly
 }
 
 func (v Value) CanAddr() bool {
-	panic("unimplemented: (reflect.Value).CanAddr()")
+	return v.indirect
 }
 
 func (v Value) Addr() Value {
-	panic("unimplemented: (reflect.Value).Addr()")
+	if !v.CanAddr() {
+		panic("reflect: call of reflect.Value.Addr on unaddressable value")
+	}
+	return Value{
+		typecode: PtrTo(v.Type()),
+		value:    v.value,
+		readonly: v.readonly,
+	}
 }
 
 func (v Value) CanSet() bool {
-	return v.indirect
+	return v.indirect && !v.readonly
+}
+
+// checkSettable panics if this value cannot be changed, because it is not
+// addressable or because it was obtained through an unexported struct field.
+func (v Value) checkSettable() {
+	if v.readonly {
+		panic("reflect: value obtained using unexported field cannot be set")
+	}
+	if !v.indirect {
+		panic("reflect: value is not addressable")
+	}
 }
 
 func (v Value) Bool() bool {
@@ -266,11 +329,64 @@ func (v Value) String() string {
 }
 
 func (v Value) Bytes() []byte {
-	panic("unimplemented: (reflect.Value).Bytes()")
+	if v.Kind() != Slice || v.Type().Elem().Kind() != Uint8 {
+		panic(&ValueError{"Bytes"})
+	}
+	return *(*[]byte)(v.value)
 }
 
 func (v Value) Slice(i, j int) Value {
-	panic("unimplemented: (reflect.Value).Slice()")
+	switch v.Kind() {
+	case Slice:
+		slice := *(*SliceHeader)(v.value)
+		if uint(i) > uint(j) || uint(j) > uint(slice.Cap) {
+			panic("reflect: slice index out of range")
+		}
+		elemSize := v.Type().Elem().Size()
+		return Value{
+			typecode: v.typecode,
+			value: unsafe.Pointer(&SliceHeader{
+				Data: slice.Data + elemSize*uintptr(i),
+				Len:  uintptr(j - i),
+				Cap:  slice.Cap - uintptr(i),
+			}),
+			readonly: v.readonly,
+		}
+	case Array:
+		// Only an addressable array can be sliced, as the resulting slice
+		// refers to the same memory.
+		if !v.indirect {
+			panic("reflect: slice of unaddressable array")
+		}
+		length := v.Type().Len()
+		if uint(i) > uint(j) || uint(j) > uint(length) {
+			panic("reflect: slice index out of range")
+		}
+		elemType := v.Type().Elem()
+		return Value{
+			typecode: SliceOf(elemType),
+			value: unsafe.Pointer(&SliceHeader{
+				Data: uintptr(v.value) + elemType.Size()*uintptr(i),
+				Len:  uintptr(j - i),
+				Cap:  uintptr(length - i),
+			}),
+			readonly: v.readonly,
+		}
+	case String:
+		s := *(*StringHeader)(v.value)
+		if uint(i) > uint(j) || uint(j) > uint(s.Len) {
+			panic("reflect: string slice index out of range")
+		}
+		return Value{
+			typecode: v.typecode,
+			value: unsafe.Pointer(&StringHeader{
+				Data: s.Data + uintptr(i),
+				Len:  uintptr(j - i),
+			}),
+		}
+	default:
+		panic(&ValueError{"Slice"})
+	}
 }
 
 func (v Value) Len() int {
@@ -280,7 +396,11 @@ func (v Value) Len() int {
 		return int((*SliceHeader)(v.value).Len)
 	case String:
 		return int((*StringHeader)(v.value).Len)
-	default: // Array, Chan, Map
+	case Array:
+		return t.Len()
+	case Map:
+		return hashmapLen(v.mapPointer())
+	default: // Chan
 		panic("unimplemented: (reflect.Value).Len()")
 	}
 }
@@ -290,13 +410,18 @@ func (v Value) Cap() int {
 	switch t.Kind() {
 	case Slice:
 		return int((*SliceHeader)(v.value).Cap)
-	default: // Array, Chan
+	case Array:
+		return t.Len()
+	default: // Chan
 		panic("unimplemented: (reflect.Value).Cap()")
 	}
 }
 
 func (v Value) NumField() int {
-	panic("unimplemented: (reflect.Value).NumField()")
+	if v.Kind() != Struct {
+		panic(&ValueError{"NumField"})
+	}
+	return v.Type().NumField()
 }
 
 func (v Value) Elem() Value {
@@ -313,6 +438,7 @@ func (v Value) Elem() Value {
 			typecode: v.Type().Elem(),
 			value:    ptr,
 			indirect: true,
+			readonly: v.readonly,
 		}
 	default: // not implemented: Interface
 		panic(&ValueError{"Elem"})
@@ -320,7 +446,16 @@ func (v Value) Elem() Value {
 }
 
 func (v Value) Field(i int) Value {
-	panic("unimplemented: (reflect.Value).Field()")
+	if v.Kind() != Struct {
+		panic(&ValueError{"Field"})
+	}
+	field := v.Type().Field(i)
+	sub := v.subValue(field.Type, field.Offset)
+	if field.PkgPath != "" {
+		// Unexported fields can be read, but not changed.
+		sub.readonly = true
+	}
+	return sub
 }
 
 func (v Value) Index(i int) Value {
@@ -334,8 +469,9 @@ func (v Value) Index(i int) Value {
 		elem := Value{
 			typecode: v.Type().Elem(),
 			indirect: true,
+			readonly: v.readonly,
 		}
-		addr := uintptr(slice.Data) + elem.Type().Size() * uintptr(i) // pointer to new value
+		addr := uintptr(slice.Data) + elem.Type().Size()*uintptr(i) // pointer to new value
 		elem.value = unsafe.Pointer(addr)
 		return elem
 	case String:
@@ -348,26 +484,24 @@ func (v Value) Index(i int) Value {
 		}
 		return Value{
 			typecode: Uint8.basicType(),
-			value: unsafe.Pointer(uintptr(*(*uint8)(unsafe.Pointer(s.Data + uintptr(i))))),
+			value:    unsafe.Pointer(uintptr(*(*uint8)(unsafe.Pointer(s.Data + uintptr(i))))),
 		}
 	case Array:
-		panic("unimplemented: (reflect.Value).Index()")
+		// Extract an element from the array.
+		if uint(i) >= uint(v.Type().Len()) {
+			panic("reflect: array index out of range")
+		}
+		elemType := v.Type().Elem()
+		return v.subValue(elemType, elemType.Size()*uintptr(i))
 	default:
 		panic(&ValueError{"Index"})
 	}
 }
 
-func (v Value) MapKeys() []Value {
-	panic("unimplemented: (reflect.Value).MapKeys()")
-}
-
-func (v Value) MapIndex(key Value) Value {
-	panic("unimplemented: (reflect.Value).MapIndex()")
-}
-
 func (v Value) Set(x Value) {
-	if !v.indirect {
-		panic("reflect: value is not addressable")
+	v.checkSettable()
+	if x.readonly {
+		panic("reflect: cannot assign value obtained from unexported field")
 	}
 	if v.Type() != x.Type() {
 		if v.Kind() == Interface {
@@ -385,10 +519,41 @@ func (v Value) Set(x Value) {
 	memcpy(v.value, xptr, size)
 }
 
-func (v Value) SetBool(x bool) {
-	if !v.indirect {
-		panic("reflect: value is not addressable")
+// assignTo returns this value converted to the given type, if it can be
+// assigned to a variable of that type (see Type.assignableTo). Values assigned
+// to an interface type are stored in a new interface value.
+func (v Value) assignTo(typ Type) (Value, bool) {
+	switch {
+	case v.typecode == typ:
+		return v, true
+	case typ.Kind() == Interface:
+		itf := new(interfaceHeader)
+		if v.Kind() == Interface {
+			// The interface value is always stored indirectly.
+			*itf = *(*interfaceHeader)(v.value)
+		} else {
+			x := v.Interface()
+			*itf = *(*interfaceHeader)(unsafe.Pointer(&x))
+		}
+		return Value{
+			typecode: typ,
+			value:    unsafe.Pointer(itf),
+		}, true
+	case v.typecode.assignableTo(typ):
+		// Both types have the same memory layout.
+		return Value{
+			typecode: typ,
+			value:    v.value,
+			indirect: v.indirect,
+			readonly: v.readonly,
+		}, true
+	default:
+		return Value{}, false
 	}
+}
+
+func (v Value) SetBool(x bool) {
+	v.checkSettable()
 	switch v.Kind() {
 	case Bool:
 		*(*bool)(v.value) = x
@@ -398,9 +563,7 @@ func (v Value) SetBool(x bool) {
 }
 
 func (v Value) SetInt(x int64) {
-	if !v.indirect {
-		panic("reflect: value is not addressable")
-	}
+	v.checkSettable()
 	switch v.Kind() {
 	case Int:
 		*(*int)(v.value) = int(x)
@@ -418,9 +581,7 @@ func (v Value) SetInt(x int64) {
 }
 
 func (v Value) SetUint(x uint64) {
-	if !v.indirect {
-		panic("reflect: value is not addressable")
-	}
+	v.checkSettable()
 	switch v.Kind() {
 	case Uint:
 		*(*uint)(v.value) = uint(x)
@@ -440,9 +601,7 @@ func (v Value) SetUint(x uint64) {
 }
 
 func (v Value) SetFloat(x float64) {
-	if !v.indirect {
-		panic("reflect: value is not addressable")
-	}
+	v.checkSettable()
 	switch v.Kind() {
 	case Float32:
 		*(*float32)(v.value) = float32(x)
@@ -454,9 +613,7 @@ func (v Value) SetFloat(x float64) {
 }
 
 func (v Value) SetComplex(x complex128) {
-	if !v.indirect {
-		panic("reflect: value is not addressable")
-	}
+	v.checkSettable()
 	switch v.Kind() {
 	case Complex64:
 		*(*complex64)(v.value) = complex64(x)
@@ -468,9 +625,7 @@ func (v Value) SetComplex(x complex128) {
 }
 
 func (v Value) SetString(x string) {
-	if !v.indirect {
-		panic("reflect: value is not addressable")
-	}
+	v.checkSettable()
 	switch v.Kind() {
 	case String:
 		*(*string)(v.value) = x
@@ -0,0 +1,15 @@
+// +build !darwin,!linux,!qemu
+
+package runtime
+
+// There is no command line or environment on this target.
+
+// args returns nil, as there are no command line arguments on this target.
+func args() []string {
+	return nil
+}
+
+// envs returns nil, as there is no environment on this target.
+func envs() []string {
+	return nil
+}
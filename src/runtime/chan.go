@@ -5,31 +5,52 @@ package runtime
 // A channel can be in one of the following states:
 //     empty:
 //       No goroutine is waiting on a send or receive operation. The 'blocked'
-//       member is nil.
+//       member is nil. There may be values stored in the buffer.
 //     recv:
 //       A goroutine tries to receive from the channel. This goroutine is stored
-//       in the 'blocked' member.
+//       in the 'blocked' member. The buffer is empty.
 //     send:
 //       The reverse of send. A goroutine tries to send to the channel. This
-//       goroutine is stored in the 'blocked' member.
+//       goroutine is stored in the 'blocked' member. The buffer (if any) is
+//       full.
 //     closed:
 //       The channel is closed. Sends will panic, receives will get a zero value
 //       plus optionally the indication that the channel is zero (with the
 //       commao-ok value in the coroutine).
 //
-// A send/recv transmission is completed by copying from the data element of the
-// sending coroutine to the data element of the receiving coroutine, and setting
-// the 'comma-ok' value to true.
-// A receive operation on a closed channel is completed by zeroing the data
-// element of the receiving coroutine and setting the 'comma-ok' value to false.
+// A send/recv transmission is completed by copying from the value buffer of the
+// sending coroutine to the value buffer of the receiving coroutine, and setting
+// the 'comma-ok' value to true. These value buffers live in the coroutine frame
+// and are referred to by the 'ptr' element of the promise while the coroutine
+// is blocked, so values of any size can be sent over a channel.
+// A receive operation on a closed channel is completed by zeroing the value
+// buffer of the receiving coroutine and setting the 'comma-ok' value to false.
+//
+// Buffered channels store values in a ring buffer. A send operation only blocks
+// when the buffer is full and a receive operation only blocks when the buffer
+// is empty. Values remaining in the buffer can still be received after the
+// channel has been closed.
+//
+// Goroutines blocked in a select statement are not stored in the 'blocked'
+// member, as they may be waiting on multiple channels at the same time.
+// Instead, every case of a blocked select statement is stored in the 'selects'
+// member of its channel. As soon as one of those cases can proceed, all cases
+// are removed from their channels and the goroutine is re-activated. The
+// selected case is stored in the data element of the promise.
 
 import (
 	"unsafe"
 )
 
 type channel struct {
-	state   uint8
-	blocked *coroutine
+	elementSize uintptr // the size of one value in this channel
+	state       uint8
+	blocked     *coroutine
+	selects     *channelBlockedList
+	buf         unsafe.Pointer // ring buffer for buffered channels
+	bufSize     uintptr        // capacity of the buffer (in elements)
+	bufHead     uintptr        // index of the oldest value in the buffer
+	bufUsed     uintptr        // number of values currently in the buffer
 }
 
 const (
@@ -39,45 +60,114 @@ const (
 	chanStateClosed
 )
 
-func chanSendStub(caller *coroutine, ch *channel, _ unsafe.Pointer, size uintptr)
-func chanRecvStub(caller *coroutine, ch *channel, _ unsafe.Pointer, _ *bool, size uintptr)
+// chanSelectState is a single channel operation (send/recv) in a select
+// statement. The value pointer is either nil (for receives) or points to the
+// value to send (for sends).
+type chanSelectState struct {
+	ch    *channel
+	value unsafe.Pointer
+}
+
+// channelBlockedList is a single case of a select statement that is blocked on
+// a channel. It is part of a linked list of such cases, one list per channel.
+// The blocked cases are allocated by the compiler (one for each case of the
+// select statement) and live in the coroutine frame of the blocked goroutine.
+type channelBlockedList struct {
+	next         *channelBlockedList
+	t            *coroutine
+	s            *chanSelectState
+	allSelectOps []channelBlockedList
+}
+
+// chanMake creates a new channel with the given element size and buffer
+// capacity (0 for unbuffered channels).
+func chanMake(elementSize uintptr, bufSize int) *channel {
+	if bufSize < 0 || (elementSize != 0 && uintptr(bufSize) > maxChanBufSize/elementSize) {
+		runtimePanic("makechan: size out of range")
+		return nil
+	}
+	ch := &channel{
+		elementSize: elementSize,
+		bufSize:     uintptr(bufSize),
+	}
+	if bufSize != 0 {
+		ch.buf = alloc(elementSize * uintptr(bufSize))
+	}
+	return ch
+}
+
+// The maximum size in bytes of a channel buffer. The buffer size must fit in
+// an int, just like the size of a slice.
+const maxChanBufSize = ^uintptr(0) >> 1
+
+// chanMakeCheck64 checks the buffer size of a channel that is created with a
+// size type bigger than int, before it is converted to an int. A negative size
+// of a signed type is also too big when interpreted as unsigned.
+func chanMakeCheck64(bufSize uint64) {
+	if bufSize > uint64(^uint(0)>>1) {
+		runtimePanic("makechan: size out of range")
+	}
+}
+
+// chanLen returns the number of values in the channel buffer, for len(ch).
+func chanLen(ch *channel) int {
+	if ch == nil {
+		return 0
+	}
+	return int(ch.bufUsed)
+}
+
+// chanCap returns the capacity of the channel buffer, for cap(ch).
+func chanCap(ch *channel) int {
+	if ch == nil {
+		return 0
+	}
+	return int(ch.bufSize)
+}
+
+// Pseudo functions that are replaced with calls to chanSend, chanRecv and
+// chanSelect during goroutine lowering. Pointers passed to them are only used
+// while the calling goroutine is blocked.
+
+//go:noescape
+func chanSendStub(caller *coroutine, ch *channel, value unsafe.Pointer)
+
+//go:noescape
+func chanRecvStub(caller *coroutine, ch *channel, value unsafe.Pointer, _ *bool)
+
+//go:noescape
+func chanSelectStub(caller *coroutine, recvbuf unsafe.Pointer, states []chanSelectState, ops []channelBlockedList) (uintptr, bool)
 
 // chanSend sends a single value over the channel. If this operation can
 // complete immediately (there is a goroutine waiting for a value), it sends the
 // value and re-activates both goroutines. If not, it sets itself as waiting on
 // a value.
 //
-// The unsafe.Pointer value is used during lowering. During IR generation, it
-// points to the to-be-received value. During coroutine lowering, this value is
-// replaced with a read from the coroutine promise.
-func chanSend(sender *coroutine, ch *channel, _ unsafe.Pointer, size uintptr) {
+// The value pointer points to the value to send, which is stored in the
+// coroutine frame of the sender. It must stay valid until the sender is
+// re-activated.
+func chanSend(sender *coroutine, ch *channel, value unsafe.Pointer) {
 	if ch == nil {
 		// A nil channel blocks forever. Do not scheduler this goroutine again.
+		blockTask(sender, waitReasonChanSendNilChan)
 		return
 	}
-	switch ch.state {
-	case chanStateEmpty:
-		ch.state = chanStateSend
-		ch.blocked = sender
-	case chanStateRecv:
-		receiver := ch.blocked
-		receiverPromise := receiver.promise()
-		senderPromise := sender.promise()
-		memcpy(unsafe.Pointer(&receiverPromise.data), unsafe.Pointer(&senderPromise.data), size)
-		receiverPromise.commaOk = true
-		ch.blocked = receiverPromise.next
-		receiverPromise.next = nil
-		activateTask(receiver)
+	if ch.trySend(value) {
 		activateTask(sender)
-		if ch.blocked == nil {
-			ch.state = chanStateEmpty
-		}
-	case chanStateClosed:
-		runtimePanic("send on closed channel")
-	case chanStateSend:
-		sender.promise().next = ch.blocked
-		ch.blocked = sender
+		return
 	}
+	if panicking {
+		// Sending on a closed channel. The sender is re-activated by
+		// yieldPanic, which continues unwinding the stack.
+		return
+	}
+	// Wait for a receiver.
+	blockTask(sender, waitReasonChanSend)
+	senderPromise := sender.promise()
+	senderPromise.ptr = value
+	ch.state = chanStateSend
+	senderPromise.next = ch.blocked
+	ch.blocked = sender
 }
 
 // chanRecv receives a single value over a channel. If there is an available
@@ -85,69 +175,364 @@ func chanSend(sender *coroutine, ch *channel, _ unsafe.Pointer, size uintptr) {
 // If not, it sets itself as available for receiving. If the channel is closed,
 // it immediately activates itself with a zero value as the result.
 //
-// The two unnamed values exist to help during lowering. The unsafe.Pointer
-// points to the value, and the *bool points to the comma-ok value. Both are
-// replaced by reads from the coroutine promise.
-func chanRecv(receiver *coroutine, ch *channel, _ unsafe.Pointer, _ *bool, size uintptr) {
+// The value pointer points to the buffer the received value is stored in, which
+// is part of the coroutine frame of the receiver. The unnamed *bool exists to
+// help during lowering: it points to the comma-ok value and is replaced by a
+// read from the coroutine promise.
+func chanRecv(receiver *coroutine, ch *channel, value unsafe.Pointer, _ *bool) {
 	if ch == nil {
 		// A nil channel blocks forever. Do not scheduler this goroutine again.
+		blockTask(receiver, waitReasonChanReceiveNilChan)
+		return
+	}
+	receiverPromise := receiver.promise()
+	if received, ok := ch.tryRecv(value); received {
+		receiverPromise.commaOk = ok
+		activateTask(receiver)
 		return
 	}
+	// Wait for a sender.
+	blockTask(receiver, waitReasonChanReceive)
+	receiverPromise.ptr = value
+	ch.state = chanStateRecv
+	receiverPromise.next = ch.blocked
+	ch.blocked = receiver
+}
+
+// trySend sends the value pointed to by value to a goroutine that is waiting
+// on this channel or stores it in the channel buffer, without blocking. It
+// returns whether the value was sent. When the channel is closed, it panics and
+// returns false.
+func (ch *channel) trySend(value unsafe.Pointer) bool {
 	switch ch.state {
-	case chanStateSend:
-		sender := ch.blocked
+	case chanStateRecv:
+		receiver := ch.popBlocked()
 		receiverPromise := receiver.promise()
-		senderPromise := sender.promise()
-		memcpy(unsafe.Pointer(&receiverPromise.data), unsafe.Pointer(&senderPromise.data), size)
+		memcpy(receiverPromise.ptr, value, ch.elementSize)
 		receiverPromise.commaOk = true
-		ch.blocked = senderPromise.next
-		senderPromise.next = nil
 		activateTask(receiver)
-		activateTask(sender)
-		if ch.blocked == nil {
-			ch.state = chanStateEmpty
+		return true
+	case chanStateClosed:
+		runtimePanic("send on closed channel")
+		return false
+	}
+	if op := ch.selectWaiting(false); op != nil {
+		memcpy(op.recvbuf(), value, ch.elementSize)
+		op.done(true)
+		return true
+	}
+	if ch.bufUsed < ch.bufSize {
+		ch.bufPush(value)
+		return true
+	}
+	return false
+}
+
+// tryRecv receives a value from the channel buffer or from a goroutine that is
+// waiting on this channel, without blocking, and stores it in value. It
+// returns whether a value was received and the comma-ok value (false if the
+// channel is closed).
+func (ch *channel) tryRecv(value unsafe.Pointer) (received, ok bool) {
+	if ch.bufUsed != 0 {
+		ch.bufPop(value)
+		// There is room in the buffer now, so a blocked sender can proceed.
+		if ch.state == chanStateSend {
+			sender := ch.popBlocked()
+			ch.bufPush(sender.promise().ptr)
+			activateTask(sender)
+		} else if op := ch.selectWaiting(true); op != nil {
+			ch.bufPush(op.s.value)
+			op.done(false)
 		}
-	case chanStateEmpty:
-		ch.state = chanStateRecv
-		ch.blocked = receiver
+		return true, true
+	}
+	switch ch.state {
+	case chanStateSend:
+		sender := ch.popBlocked()
+		memcpy(value, sender.promise().ptr, ch.elementSize)
+		activateTask(sender)
+		return true, true
 	case chanStateClosed:
-		receiverPromise := receiver.promise()
-		memzero(unsafe.Pointer(&receiverPromise.data), size)
-		receiverPromise.commaOk = false
-		activateTask(receiver)
-	case chanStateRecv:
-		receiver.promise().next = ch.blocked
-		ch.blocked = receiver
+		memzero(value, ch.elementSize)
+		return true, false
+	}
+	if op := ch.selectWaiting(true); op != nil {
+		memcpy(value, op.s.value, ch.elementSize)
+		op.done(false)
+		return true, true
+	}
+	return false, false
+}
+
+// popBlocked removes the first goroutine from the list of goroutines blocked
+// on a send or receive operation, and returns it.
+func (ch *channel) popBlocked() *coroutine {
+	t := ch.blocked
+	promise := t.promise()
+	ch.blocked = promise.next
+	promise.next = nil
+	if ch.blocked == nil {
+		ch.state = chanStateEmpty
 	}
+	return t
+}
+
+// bufPush appends a value to the channel buffer, which must not be full.
+func (ch *channel) bufPush(value unsafe.Pointer) {
+	index := ch.bufHead + ch.bufUsed
+	if index >= ch.bufSize {
+		index -= ch.bufSize
+	}
+	memcpy(unsafe.Pointer(uintptr(ch.buf)+index*ch.elementSize), value, ch.elementSize)
+	ch.bufUsed++
+}
+
+// bufPop removes the oldest value from the channel buffer, which must not be
+// empty, and stores it in value.
+func (ch *channel) bufPop(value unsafe.Pointer) {
+	memcpy(value, unsafe.Pointer(uintptr(ch.buf)+ch.bufHead*ch.elementSize), ch.elementSize)
+	ch.bufHead++
+	if ch.bufHead == ch.bufSize {
+		ch.bufHead = 0
+	}
+	ch.bufUsed--
+}
+
+// selectWaiting returns a blocked select case on this channel that wants to
+// send (if send is true) or receive (if send is false) a value. It returns nil
+// if there is no such select case.
+func (ch *channel) selectWaiting(send bool) *channelBlockedList {
+	for op := ch.selects; op != nil; op = op.next {
+		if (op.s.value != nil) == send {
+			return op
+		}
+	}
+	return nil
+}
+
+// removeSelect removes a blocked select case from this channel.
+func (ch *channel) removeSelect(op *channelBlockedList) {
+	for ptr := &ch.selects; *ptr != nil; ptr = &(*ptr).next {
+		if *ptr == op {
+			*ptr = op.next
+			op.next = nil
+			return
+		}
+	}
+}
+
+// recvbuf returns the buffer where a received value must be stored for the
+// select statement this blocked case belongs to.
+func (op *channelBlockedList) recvbuf() unsafe.Pointer {
+	// The receive buffer is stored in the promise while blocked.
+	return op.t.promise().ptr
+}
+
+// done completes a blocked select statement with this case as the selected
+// case. It removes all cases of the select statement from the channels they're
+// waiting on, stores the selected case and the comma-ok value in the promise
+// and re-activates the blocked goroutine.
+func (op *channelBlockedList) done(commaOk bool) {
+	for i := range op.allSelectOps {
+		other := &op.allSelectOps[i]
+		if other.s == nil {
+			// This case was never blocked on a channel (nil channel).
+			continue
+		}
+		other.s.ch.removeSelect(other)
+	}
+	promise := op.t.promise()
+	promise.data = uint((uintptr(unsafe.Pointer(op)) - uintptr(unsafe.Pointer(&op.allSelectOps[0]))) / unsafe.Sizeof(channelBlockedList{}))
+	promise.commaOk = commaOk
+	activateTask(op.t)
 }
 
 // chanClose closes the given channel. If this channel has a receiver or is
 // empty, it closes the channel. Else, it panics.
-func chanClose(ch *channel, size uintptr) {
+func chanClose(ch *channel) {
 	if ch == nil {
 		// Not allowed by the language spec.
 		runtimePanic("close of nil channel")
+		return
 	}
 	switch ch.state {
 	case chanStateClosed:
 		// Not allowed by the language spec.
 		runtimePanic("close of closed channel")
+		return
 	case chanStateSend:
 		// This panic should ideally on the sending side, not in this goroutine.
 		// But when a goroutine tries to send while the channel is being closed,
 		// that is clearly invalid: the send should have been completed already
 		// before the close.
 		runtimePanic("close channel during send")
+		return
 	case chanStateRecv:
-		// The receiver must be re-activated with a zero value.
-		receiverPromise := ch.blocked.promise()
-		memzero(unsafe.Pointer(&receiverPromise.data), size)
-		receiverPromise.commaOk = false
-		activateTask(ch.blocked)
-		ch.state = chanStateClosed
-		ch.blocked = nil
+		// The receivers must be re-activated with a zero value.
+		for ch.blocked != nil {
+			receiver := ch.popBlocked()
+			receiverPromise := receiver.promise()
+			memzero(receiverPromise.ptr, ch.elementSize)
+			receiverPromise.commaOk = false
+			activateTask(receiver)
+		}
 	case chanStateEmpty:
 		// Easy case. No available sender or receiver.
-		ch.state = chanStateClosed
 	}
+	// Blocked select cases must be re-activated with a zero value, just like
+	// regular receivers.
+	for ch.selects != nil {
+		op := ch.selects
+		if op.s.value != nil {
+			runtimePanic("close channel during send")
+			return
+		}
+		memzero(op.recvbuf(), ch.elementSize)
+		op.done(false)
+	}
+	ch.state = chanStateClosed
+}
+
+// chanTrySelect is the runtime implementation of a non-blocking select
+// statement (a select statement with a default case). It returns the selected
+// index and the 'comma-ok' value, or ^uintptr(0) if none of the cases can
+// proceed immediately. If a send case is tried on a closed channel, that case
+// is selected while panicking.
+//
+// The cases are tried starting at a random index, so that a case that can
+// always proceed doesn't starve the cases after it.
+func chanTrySelect(recvbuf unsafe.Pointer, states []chanSelectState) (uintptr, bool) {
+	if len(states) == 0 {
+		return ^uintptr(0), false
+	}
+	start := int(fastrand() % uint32(len(states)))
+	for n := range states {
+		i := start + n
+		if i >= len(states) {
+			i -= len(states)
+		}
+		state := states[i]
+		if state.ch == nil {
+			// A nil channel blocks forever, so this case can never proceed.
+			continue
+		}
+		if state.value == nil {
+			// A receive operation.
+			if received, ok := state.ch.tryRecv(recvbuf); received {
+				return uintptr(i), ok
+			}
+		} else {
+			// A send operation.
+			if state.ch.trySend(state.value) || panicking {
+				// Also select this case when sending panicked, so that the
+				// select statement doesn't block.
+				return uintptr(i), false
+			}
+		}
+	}
+	return ^uintptr(0), false
+}
+
+// fastrandState is the state of fastrand. It must never be zero.
+var fastrandState uint32 = 1
+
+// fastrand returns a pseudo-random number using a xorshift generator. It is
+// fast and small, but not suitable for anything but picking a select case.
+func fastrand() uint32 {
+	x := fastrandState
+	x ^= x << 13
+	x ^= x >> 17
+	x ^= x << 5
+	fastrandState = x
+	return x
+}
+
+// chanSelect is the runtime implementation of a blocking select statement. If
+// one of the cases can proceed immediately, it completes that case and
+// re-activates itself. If not, it blocks on all channels at once until one of
+// the cases can proceed.
+//
+// The selected index and the 'comma-ok' value are stored in the promise of the
+// coroutine and are read from there during coroutine lowering.
+func chanSelect(caller *coroutine, recvbuf unsafe.Pointer, states []chanSelectState, ops []channelBlockedList) {
+	promise := caller.promise()
+	if selected, ok := chanTrySelect(recvbuf, states); selected != ^uintptr(0) {
+		promise.data = uint(selected)
+		promise.commaOk = ok
+		if !panicking {
+			// When panicking, the caller is re-activated by yieldPanic.
+			activateTask(caller)
+		}
+		return
+	}
+
+	// Block on all channels. Store the receive buffer in the promise so that
+	// the goroutine that completes the select statement knows where to store
+	// the received value.
+	if len(states) == 0 {
+		blockTask(caller, waitReasonSelectNoCases)
+	} else {
+		blockTask(caller, waitReasonSelect)
+	}
+	promise.ptr = recvbuf
+	for i := range states {
+		state := &states[i]
+		if state.ch == nil {
+			// A nil channel blocks forever.
+			ops[i] = channelBlockedList{}
+			continue
+		}
+		ops[i] = channelBlockedList{
+			next:         state.ch.selects,
+			t:            caller,
+			s:            state,
+			allSelectOps: ops,
+		}
+		state.ch.selects = &ops[i]
+	}
+}
+
+// chanSendNoScheduler sends a value over a channel in a program without a
+// scheduler. There are no other goroutines that could receive the value, so
+// the send only succeeds when there is room in the channel buffer.
+//
+// This is a compiler intrinsic, see chanSendStub.
+func chanSendNoScheduler(ch *channel, value unsafe.Pointer) {
+	if ch == nil {
+		blockForever(waitReasonChanSendNilChan)
+	}
+	if !ch.trySend(value) && !panicking {
+		blockForever(waitReasonChanSend)
+	}
+}
+
+// chanRecvNoScheduler receives a value from a channel in a program without a
+// scheduler. This only succeeds when there is a value in the channel buffer or
+// the channel is closed.
+//
+// This is a compiler intrinsic, see chanRecvStub.
+func chanRecvNoScheduler(ch *channel, value unsafe.Pointer, commaOk *bool) {
+	if ch == nil {
+		blockForever(waitReasonChanReceiveNilChan)
+	}
+	received, ok := ch.tryRecv(value)
+	if !received {
+		blockForever(waitReasonChanReceive)
+	}
+	*commaOk = ok
+}
+
+// chanSelectNoScheduler is a blocking select statement in a program without a
+// scheduler. One of the cases must be able to proceed immediately.
+//
+// This is a compiler intrinsic, see chanSelectStub.
+func chanSelectNoScheduler(recvbuf unsafe.Pointer, states []chanSelectState, ops []channelBlockedList) (uintptr, bool) {
+	selected, ok := chanTrySelect(recvbuf, states)
+	if selected == ^uintptr(0) {
+		if len(states) == 0 {
+			blockForever(waitReasonSelectNoCases)
+		}
+		blockForever(waitReasonSelect)
+	}
+	return selected, ok
 }
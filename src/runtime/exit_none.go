@@ -0,0 +1,9 @@
+// +build avr tinygo.arm,!qemu
+
+package runtime
+
+// exit halts the program, as there is no operating system to return to on
+// these targets.
+func exit(code int) {
+	abort()
+}
@@ -31,6 +31,8 @@ type hashmapBucket struct {
 }
 
 type hashmapIterator struct {
+	buckets      unsafe.Pointer // buckets of the hashmap when the iteration started
+	numBuckets   uintptr
 	bucketNumber uintptr
 	bucket       *hashmapBucket
 	bucketIndex  uint8
@@ -62,7 +64,7 @@ func hashmapTopHash(hash uint32) uint8 {
 // Create a new hashmap with the given keySize and valueSize.
 func hashmapMake(keySize, valueSize uint8) *hashmap {
 	bucketBufSize := unsafe.Sizeof(hashmapBucket{}) + uintptr(keySize)*8 + uintptr(valueSize)*8
-	bucket := alloc(bucketBufSize)
+	bucket := alloc(bucketBufSize) // start with a single bucket, grown when needed
 	return &hashmap{
 		buckets:    bucket,
 		keySize:    keySize,
@@ -71,6 +73,12 @@ func hashmapMake(keySize, valueSize uint8) *hashmap {
 	}
 }
 
+// Return the size of a single bucket (including keys and values) in this
+// hashmap.
+func hashmapBucketSize(m *hashmap) uintptr {
+	return unsafe.Sizeof(hashmapBucket{}) + uintptr(m.keySize)*8 + uintptr(m.valueSize)*8
+}
+
 // Return the number of entries in this hashmap, called from the len builtin.
 // A nil hashmap is defined as having length 0.
 func hashmapLen(m *hashmap) int {
@@ -82,10 +90,15 @@ func hashmapLen(m *hashmap) int {
 
 // Set a specified key to a given value. Grow the map if necessary.
 //go:nobounds
-func hashmapSet(m *hashmap, key unsafe.Pointer, value unsafe.Pointer, hash uint32, keyEqual func(x, y unsafe.Pointer, n uintptr) bool) {
+func hashmapSet(m *hashmap, key unsafe.Pointer, value unsafe.Pointer, hash uint32, keyHash func(key unsafe.Pointer, n uintptr) uint32, keyEqual func(x, y unsafe.Pointer, n uintptr) bool) {
+	if m.buckets == nil {
+		// Empty hashmap created at compile time, which has no buckets.
+		m.buckets = alloc(hashmapBucketSize(m))
+	}
+
 	numBuckets := uintptr(1) << m.bucketBits
 	bucketNumber := (uintptr(hash) & (numBuckets - 1))
-	bucketSize := unsafe.Sizeof(hashmapBucket{}) + uintptr(m.keySize)*8 + uintptr(m.valueSize)*8
+	bucketSize := hashmapBucketSize(m)
 	bucketAddr := uintptr(m.buckets) + bucketSize*bucketNumber
 	bucket := (*hashmapBucket)(unsafe.Pointer(bucketAddr))
 
@@ -95,7 +108,9 @@ func hashmapSet(m *hashmap, key unsafe.Pointer, value unsafe.Pointer, hash uint3
 	var emptySlotKey unsafe.Pointer
 	var emptySlotValue unsafe.Pointer
 	var emptySlotTophash *byte
+	var lastBucket *hashmapBucket
 	for bucket != nil {
+		bucketAddr := uintptr(unsafe.Pointer(bucket))
 		for i := uintptr(0); i < 8; i++ {
 			slotKeyOffset := unsafe.Sizeof(hashmapBucket{}) + uintptr(m.keySize)*uintptr(i)
 			slotKey := unsafe.Pointer(bucketAddr + slotKeyOffset)
@@ -117,16 +132,67 @@ func hashmapSet(m *hashmap, key unsafe.Pointer, value unsafe.Pointer, hash uint3
 				}
 			}
 		}
+		lastBucket = bucket
 		bucket = bucket.next
 	}
-	if emptySlotKey != nil {
-		m.count++
-		memcpy(emptySlotKey, key, uintptr(m.keySize))
-		memcpy(emptySlotValue, value, uintptr(m.valueSize))
-		*emptySlotTophash = tophash
-		return
+	if emptySlotKey == nil {
+		// All slots in this chain of buckets are in use.
+		if m.count >= numBuckets*hashmapMaxLoad {
+			// The hashmap is too full: grow it and try again.
+			hashmapGrow(m, keyHash, keyEqual)
+			hashmapSet(m, key, value, hash, keyHash, keyEqual)
+			return
+		}
+		// Add a new bucket to the end of the chain. This keeps small maps
+		// small, as they only grow when the average bucket is mostly full.
+		newBucket := alloc(bucketSize)
+		lastBucket.next = (*hashmapBucket)(newBucket)
+		emptySlotKey = unsafe.Pointer(uintptr(newBucket) + unsafe.Sizeof(hashmapBucket{}))
+		emptySlotValue = unsafe.Pointer(uintptr(newBucket) + unsafe.Sizeof(hashmapBucket{}) + uintptr(m.keySize)*8)
+		emptySlotTophash = &lastBucket.next.tophash[0]
+	}
+	m.count++
+	memcpy(emptySlotKey, key, uintptr(m.keySize))
+	memcpy(emptySlotValue, value, uintptr(m.valueSize))
+	*emptySlotTophash = tophash
+}
+
+// The maximum average number of entries per bucket before the hashmap is
+// grown. It is lower than the bucket size (8) to keep bucket chains short.
+const hashmapMaxLoad = 6
+
+// Double the number of buckets in the hashmap and move all entries to the new
+// buckets. The old buckets are left untouched, as they may still be in use by
+// an iterator (see hashmapNext).
+//go:nobounds
+func hashmapGrow(m *hashmap, keyHash func(key unsafe.Pointer, n uintptr) uint32, keyEqual func(x, y unsafe.Pointer, n uintptr) bool) {
+	oldBuckets := m.buckets
+	oldNumBuckets := uintptr(1) << m.bucketBits
+	bucketSize := hashmapBucketSize(m)
+
+	m.bucketBits++
+	m.buckets = alloc(bucketSize * (uintptr(1) << m.bucketBits))
+	m.count = 0
+
+	for bucketNumber := uintptr(0); bucketNumber < oldNumBuckets; bucketNumber++ {
+		bucket := (*hashmapBucket)(unsafe.Pointer(uintptr(oldBuckets) + bucketSize*bucketNumber))
+		for bucket != nil {
+			bucketAddr := uintptr(unsafe.Pointer(bucket))
+			for i := uintptr(0); i < 8; i++ {
+				if bucket.tophash[i] == 0 {
+					// empty slot
+					continue
+				}
+				slotKeyOffset := unsafe.Sizeof(hashmapBucket{}) + uintptr(m.keySize)*uintptr(i)
+				slotKey := unsafe.Pointer(bucketAddr + slotKeyOffset)
+				slotValueOffset := unsafe.Sizeof(hashmapBucket{}) + uintptr(m.keySize)*8 + uintptr(m.valueSize)*uintptr(i)
+				slotValue := unsafe.Pointer(bucketAddr + slotValueOffset)
+				hash := keyHash(slotKey, uintptr(m.keySize))
+				hashmapSet(m, slotKey, slotValue, hash, keyHash, keyEqual)
+			}
+			bucket = bucket.next
+		}
 	}
-	panic("todo: hashmap: grow bucket")
 }
 
 // Get the value of a specified key, or zero the value if not found.
@@ -134,7 +200,7 @@ func hashmapSet(m *hashmap, key unsafe.Pointer, value unsafe.Pointer, hash uint3
 func hashmapGet(m *hashmap, key unsafe.Pointer, value unsafe.Pointer, hash uint32, keyEqual func(x, y unsafe.Pointer, n uintptr) bool) bool {
 	numBuckets := uintptr(1) << m.bucketBits
 	bucketNumber := (uintptr(hash) & (numBuckets - 1))
-	bucketSize := unsafe.Sizeof(hashmapBucket{}) + uintptr(m.keySize)*8 + uintptr(m.valueSize)*8
+	bucketSize := hashmapBucketSize(m)
 	bucketAddr := uintptr(m.buckets) + bucketSize*bucketNumber
 	bucket := (*hashmapBucket)(unsafe.Pointer(bucketAddr))
 
@@ -174,7 +240,7 @@ func hashmapGet(m *hashmap, key unsafe.Pointer, value unsafe.Pointer, hash uint3
 func hashmapDelete(m *hashmap, key unsafe.Pointer, hash uint32, keyEqual func(x, y unsafe.Pointer, n uintptr) bool) {
 	numBuckets := uintptr(1) << m.bucketBits
 	bucketNumber := (uintptr(hash) & (numBuckets - 1))
-	bucketSize := unsafe.Sizeof(hashmapBucket{}) + uintptr(m.keySize)*8 + uintptr(m.valueSize)*8
+	bucketSize := hashmapBucketSize(m)
 	bucketAddr := uintptr(m.buckets) + bucketSize*bucketNumber
 	bucket := (*hashmapBucket)(unsafe.Pointer(bucketAddr))
 
@@ -204,9 +270,23 @@ func hashmapDelete(m *hashmap, key unsafe.Pointer, hash uint32, keyEqual func(x,
 }
 
 // Iterate over a hashmap.
+//
+// The iterator walks over the buckets the hashmap had when the iteration
+// started. When the hashmap grows in the meantime, these buckets are kept
+// as-is, so every entry is still visited exactly once. But their contents are
+// outdated, so the key is then looked up in the grown hashmap to get the
+// current value (and to skip it when it has been deleted).
 //go:nobounds
-func hashmapNext(m *hashmap, it *hashmapIterator, key, value unsafe.Pointer) bool {
-	numBuckets := uintptr(1) << m.bucketBits
+func hashmapNext(m *hashmap, it *hashmapIterator, key, value unsafe.Pointer, keyHash func(key unsafe.Pointer, n uintptr) uint32, keyEqual func(x, y unsafe.Pointer, n uintptr) bool) bool {
+	if m == nil || m.buckets == nil {
+		// Iterating over a nil map or an empty map created at compile time.
+		return false
+	}
+	if it.buckets == nil {
+		// Start of the iteration.
+		it.buckets = m.buckets
+		it.numBuckets = uintptr(1) << m.bucketBits
+	}
 	for {
 		if it.bucketIndex >= 8 {
 			// end of bucket, move to the next in the chain
@@ -214,12 +294,11 @@ func hashmapNext(m *hashmap, it *hashmapIterator, key, value unsafe.Pointer) boo
 			it.bucket = it.bucket.next
 		}
 		if it.bucket == nil {
-			if it.bucketNumber >= numBuckets {
+			if it.bucketNumber >= it.numBuckets {
 				// went through all buckets
 				return false
 			}
-			bucketSize := unsafe.Sizeof(hashmapBucket{}) + uintptr(m.keySize)*8 + uintptr(m.valueSize)*8
-			bucketAddr := uintptr(m.buckets) + bucketSize*it.bucketNumber
+			bucketAddr := uintptr(it.buckets) + hashmapBucketSize(m)*it.bucketNumber
 			it.bucket = (*hashmapBucket)(unsafe.Pointer(bucketAddr))
 			it.bucketNumber++ // next bucket
 		}
@@ -234,19 +313,62 @@ func hashmapNext(m *hashmap, it *hashmapIterator, key, value unsafe.Pointer) boo
 		slotKey := unsafe.Pointer(bucketAddr + slotKeyOffset)
 		slotValueOffset := unsafe.Sizeof(hashmapBucket{}) + uintptr(m.keySize)*8 + uintptr(m.valueSize)*uintptr(it.bucketIndex)
 		slotValue := unsafe.Pointer(bucketAddr + slotValueOffset)
+		it.bucketIndex++
 		memcpy(key, slotKey, uintptr(m.keySize))
+		if it.buckets != m.buckets {
+			// The hashmap has grown, so look up the current value.
+			hash := keyHash(key, uintptr(m.keySize))
+			if !hashmapGet(m, key, value, hash, keyEqual) {
+				// deleted after the hashmap has grown
+				continue
+			}
+			return true
+		}
 		memcpy(value, slotValue, uintptr(m.valueSize))
-		it.bucketIndex++
 
 		return true
 	}
 }
 
+// Hashmap functions used by the reflect package. They use unsafe.Pointer
+// instead of *hashmap and *hashmapIterator, as the reflect package cannot
+// refer to these types. See src/reflect/map.go.
+
+//go:linkname reflect_hashmapMake reflect.hashmapMake
+func reflect_hashmapMake(keySize, valueSize uint8) unsafe.Pointer {
+	return unsafe.Pointer(hashmapMake(keySize, valueSize))
+}
+
+//go:linkname reflect_hashmapLen reflect.hashmapLen
+func reflect_hashmapLen(m unsafe.Pointer) int {
+	return hashmapLen((*hashmap)(m))
+}
+
+//go:linkname reflect_hashmapSet reflect.hashmapSet
+func reflect_hashmapSet(m unsafe.Pointer, key, value unsafe.Pointer, hash uint32, keyHash func(key unsafe.Pointer, n uintptr) uint32, keyEqual func(x, y unsafe.Pointer, n uintptr) bool) {
+	hashmapSet((*hashmap)(m), key, value, hash, keyHash, keyEqual)
+}
+
+//go:linkname reflect_hashmapGet reflect.hashmapGet
+func reflect_hashmapGet(m unsafe.Pointer, key, value unsafe.Pointer, hash uint32, keyEqual func(x, y unsafe.Pointer, n uintptr) bool) bool {
+	return hashmapGet((*hashmap)(m), key, value, hash, keyEqual)
+}
+
+//go:linkname reflect_hashmapDelete reflect.hashmapDelete
+func reflect_hashmapDelete(m unsafe.Pointer, key unsafe.Pointer, hash uint32, keyEqual func(x, y unsafe.Pointer, n uintptr) bool) {
+	hashmapDelete((*hashmap)(m), key, hash, keyEqual)
+}
+
+//go:linkname reflect_hashmapNext reflect.hashmapNext
+func reflect_hashmapNext(m unsafe.Pointer, it unsafe.Pointer, key, value unsafe.Pointer, keyHash func(key unsafe.Pointer, n uintptr) uint32, keyEqual func(x, y unsafe.Pointer, n uintptr) bool) bool {
+	return hashmapNext((*hashmap)(m), (*hashmapIterator)(it), key, value, keyHash, keyEqual)
+}
+
 // Hashmap with plain binary data keys (not containing strings etc.).
 
 func hashmapBinarySet(m *hashmap, key, value unsafe.Pointer) {
 	hash := hashmapHash(key, uintptr(m.keySize))
-	hashmapSet(m, key, value, hash, memequal)
+	hashmapSet(m, key, value, hash, hashmapHash, memequal)
 }
 
 func hashmapBinaryGet(m *hashmap, key, value unsafe.Pointer) bool {
@@ -259,6 +381,10 @@ func hashmapBinaryDelete(m *hashmap, key unsafe.Pointer) {
 	hashmapDelete(m, key, hash, memequal)
 }
 
+func hashmapBinaryNext(m *hashmap, it *hashmapIterator, key, value unsafe.Pointer) bool {
+	return hashmapNext(m, it, key, value, hashmapHash, memequal)
+}
+
 // Hashmap with string keys (a common case).
 
 func hashmapStringEqual(x, y unsafe.Pointer, n uintptr) bool {
@@ -270,9 +396,13 @@ func hashmapStringHash(s string) uint32 {
 	return hashmapHash(unsafe.Pointer(_s.ptr), uintptr(_s.length))
 }
 
+func hashmapStringPtrHash(sptr unsafe.Pointer, n uintptr) uint32 {
+	return hashmapStringHash(*(*string)(sptr))
+}
+
 func hashmapStringSet(m *hashmap, key string, value unsafe.Pointer) {
 	hash := hashmapStringHash(key)
-	hashmapSet(m, unsafe.Pointer(&key), value, hash, hashmapStringEqual)
+	hashmapSet(m, unsafe.Pointer(&key), value, hash, hashmapStringPtrHash, hashmapStringEqual)
 }
 
 func hashmapStringGet(m *hashmap, key string, value unsafe.Pointer) bool {
@@ -284,3 +414,79 @@ func hashmapStringDelete(m *hashmap, key string) {
 	hash := hashmapStringHash(key)
 	hashmapDelete(m, unsafe.Pointer(&key), hash, hashmapStringEqual)
 }
+
+func hashmapStringNext(m *hashmap, it *hashmapIterator, key, value unsafe.Pointer) bool {
+	return hashmapNext(m, it, key, value, hashmapStringPtrHash, hashmapStringEqual)
+}
+
+// Hashmap with keys that cannot be compared with memequal: floats, complex
+// numbers, interfaces, and structs or arrays containing those or strings. The
+// compiler creates a function that calculates the hash of such a key and a
+// function to compare two keys, which are passed to the functions below. See
+// compiler/map.go for details.
+
+func hashmapGenericSet(m *hashmap, key, value unsafe.Pointer, keyHash func(key unsafe.Pointer, n uintptr) uint32, keyEqual func(x, y unsafe.Pointer, n uintptr) bool) {
+	hash := keyHash(key, uintptr(m.keySize))
+	hashmapSet(m, key, value, hash, keyHash, keyEqual)
+}
+
+func hashmapGenericGet(m *hashmap, key, value unsafe.Pointer, keyHash func(key unsafe.Pointer, n uintptr) uint32, keyEqual func(x, y unsafe.Pointer, n uintptr) bool) bool {
+	hash := keyHash(key, uintptr(m.keySize))
+	return hashmapGet(m, key, value, hash, keyEqual)
+}
+
+func hashmapGenericDelete(m *hashmap, key unsafe.Pointer, keyHash func(key unsafe.Pointer, n uintptr) uint32, keyEqual func(x, y unsafe.Pointer, n uintptr) bool) {
+	hash := keyHash(key, uintptr(m.keySize))
+	hashmapDelete(m, key, hash, keyEqual)
+}
+
+func hashmapGenericNext(m *hashmap, it *hashmapIterator, key, value unsafe.Pointer, keyHash func(key unsafe.Pointer, n uintptr) uint32, keyEqual func(x, y unsafe.Pointer, n uintptr) bool) bool {
+	return hashmapNext(m, it, key, value, keyHash, keyEqual)
+}
+
+// Mix the hash of a part of a key (like a struct field) into the hash of the
+// whole key.
+func hashmapHashCombine(hash, partHash uint32) uint32 {
+	hash ^= partHash
+	hash *= 16777619 // FNV prime
+	return hash
+}
+
+func hashmapFloat32Hash(f float32) uint32 {
+	if f == 0 {
+		// +0 and -0 are equal, so must have the same hash.
+		f = 0
+	}
+	return hashmapHash(unsafe.Pointer(&f), 4)
+}
+
+func hashmapFloat64Hash(f float64) uint32 {
+	if f == 0 {
+		// +0 and -0 are equal, so must have the same hash.
+		f = 0
+	}
+	return hashmapHash(unsafe.Pointer(&f), 8)
+}
+
+// Get the hash of the dynamic value in an interface. Two interfaces that are
+// equal according to interfaceEqual have the same hash.
+func hashmapInterfaceHash(itf interface{}) uint32 {
+	x := (*_interface)(unsafe.Pointer(&itf))
+	if x.typecode == 0 {
+		// nil interface
+		return 0
+	}
+	return hashmapHashCombine(uint32(x.typecode), interfaceValueHash(x.typecode, unsafe.Pointer(&x.value)))
+}
+
+// Get the hash of a value stored in an interface. The value parameter points to
+// the value field of the interface.
+// The body of this function is generated by the compiler, see
+// createInterfaceFuncs.
+func interfaceValueHash(typecode uintptr, value unsafe.Pointer) uint32
+
+// hashmapUnhashable is called when an interface is used as a map key, while its
+// dynamic type is not comparable.
+func hashmapUnhashable() {
+	runtimePanic("hash of unhashable type")
+}
@@ -22,8 +22,20 @@ func interfaceEqual(x, y _interface) bool {
 		// Both interfaces are nil, so they are equal.
 		return true
 	}
-	// TODO: depends on reflection.
-	panic("unimplemented: interface equality")
+	return interfaceValueEqual(x.typecode, unsafe.Pointer(&x.value), unsafe.Pointer(&y.value))
+}
+
+// Compare two values of the same dynamic type. The x and y parameters point to
+// the value field of the interfaces that contain the values.
+// The body of this function is generated by the compiler, see
+// createInterfaceFuncs.
+func interfaceValueEqual(typecode uintptr, x, y unsafe.Pointer) bool
+
+// interfaceUncomparable is called when two interfaces are compared that have
+// the same dynamic type, but that type is not comparable (a slice, map or
+// function, or a struct or array containing one).
+func interfaceUncomparable() {
+	runtimePanic("comparing uncomparable type")
 }
 
 // interfaceTypeAssert is called when a type assert without comma-ok still
@@ -1,30 +1,218 @@
 package runtime
 
+// This file implements panic and recover.
+//
+// When recover() is never used in a program, a panic simply prints the panic
+// value and aborts the program. When it is used somewhere, the compiler enables
+// stack unwinding instead:
+//   * A panic sets the panicking flag and returns to the caller.
+//   * The compiler checks this flag after every call. When it is set, the
+//     calling function clears the flag (see unwindStart), runs its deferred
+//     calls, and either returns normally when one of them called recover() or
+//     sets the flag again and returns to its own caller (see unwindEnd).
+//   * A blocking function that returns while panicking stores the panic in the
+//     parent coroutine (see forwardPanic), which continues unwinding once it is
+//     resumed by the scheduler. When the blocking function didn't block, the
+//     parent checks the flag right after the call as usual (see returnTask).
+//   * A panic that reaches the top of a goroutine (or an exported function)
+//     prints the panic value and aborts the program (see fatalpanic). The
+//     stack trace printed there is stored in _panic, before the stack is
+//     unwound.
+// This works on every target, including WebAssembly, as it doesn't depend on
+// setjmp/longjmp or exception handling support.
+
 // trap is a compiler hint that this function cannot be executed. It is
 // translated into either a trap instruction or a call to abort().
 //go:export llvm.trap
 func trap()
 
+// The Error interface identifies a run time panic.
+type Error interface {
+	error
+
+	// RuntimeError is a no-op function but serves to distinguish types that
+	// are run time errors from ordinary errors.
+	RuntimeError()
+}
+
+// A runtimeError is the value passed to panic() by runtimePanic.
+type runtimeError struct {
+	msg string
+}
+
+func (e runtimeError) Error() string {
+	return "runtime error: " + e.msg
+}
+
+func (e runtimeError) RuntimeError() {}
+
+// panicUnwinding is set by the compiler when this program uses recover(), in
+// which case a panic unwinds the stack instead of aborting immediately.
+var panicUnwinding bool
+
+// panicking is set while a panic is being propagated to the caller. The
+// compiler checks it after every call.
+var panicking bool
+
+// panicState is the state of a panic whose deferred calls are being run.
+type panicState struct {
+	active    bool        // a panic is in progress, deferred calls are being run
+	recovered bool        // recover() has been called for this panic
+	value     interface{} // value passed to panic()
+
+	// The stack at the point of the panic, printed in fatalpanic. It must be
+	// stored in _panic as the stack is unwound before fatalpanic is called.
+	stack    [maxStackDepth]uintptr
+	stackLen int
+}
+
+// State of the current panic, if any.
+//
+// When a panic is forwarded to the parent coroutine (see forwardPanic), this
+// state is moved to the promise of the parent, and restored by the scheduler
+// when the parent is resumed. That way, other goroutines can panic and recover
+// while the parent isn't running yet. However, a deferred call that blocks
+// while a panic is in progress isn't supported: when another goroutine panics
+// in the meantime, the panic state of the first goroutine is overwritten.
+var currentPanic panicState
+
 // Builtin function panic(msg), used as a compiler intrinsic.
 func _panic(message interface{}) {
-	printstring("panic: ")
-	printitf(message)
-	printnl()
-	abort()
+	if !panicUnwinding {
+		printstring("panic: ")
+		printitf(message)
+		printnl()
+		printcallers()
+		exit(2)
+	}
+	currentPanic.stackLen = callers(0, currentPanic.stack[:])
+	currentPanic.value = message
+	currentPanic.active = true
+	currentPanic.recovered = false
+	panicking = true
 }
 
 // Cause a runtime panic, which is (currently) always a string.
 func runtimePanic(msg string) {
-	printstring("panic: runtime error: ")
-	println(msg)
-	abort()
+	if !panicUnwinding {
+		printstring("panic: runtime error: ")
+		println(msg)
+		printcallers()
+		exit(2)
+	}
+	_panic(runtimeError{msg})
+}
+
+// The address of the function that is about to be called as a deferred call
+// by a function that is unwinding the stack. It is stored by the compiler right
+// before the call, and passed on by wrappers to the function they call.
+var deferFunc uintptr
+
+// deferCallEnter is called by the compiler on entry of every function that
+// calls recover(), with the address of that function. It returns whether the
+// function was called directly as a deferred call while unwinding the stack,
+// which is the only case in which recover() stops a panic. Other functions
+// that call recover() and are called by this function (like the deferred
+// calls of package fmt when it prints a value) must not stop the panic, so
+// deferFunc is cleared.
+func deferCallEnter(fn uintptr) bool {
+	if fn != deferFunc {
+		return false
+	}
+	deferFunc = 0
+	return currentPanic.active
+}
+
+// Try to recover a panicking goroutine. The canRecover parameter is the result
+// of deferCallEnter in the calling function.
+func _recover(canRecover bool) interface{} {
+	if !canRecover || !currentPanic.active || currentPanic.recovered {
+		// Not called directly by a deferred function while panicking, or
+		// this panic has already been recovered.
+		return nil
+	}
+	currentPanic.recovered = true
+	return currentPanic.value
+}
+
+// unwindStart is called by a function that is unwinding the stack, right
+// before running its deferred calls. It clears the panicking flag, so that the
+// deferred calls run as usual.
+func unwindStart() {
+	panicking = false
+}
+
+// unwindEnd is called by a function that is unwinding the stack, after all
+// deferred calls have been run. It returns true if the panic was recovered, in
+// which case the function returns normally. Otherwise, the panic continues to
+// propagate to the caller.
+func unwindEnd() bool {
+	if currentPanic.recovered {
+		currentPanic = panicState{}
+		return true
+	}
+	panicking = true
+	return false
+}
+
+// forwardPanic is called by a blocking function (coroutine) right before it
+// returns to its parent. If it is panicking, the panic is stored in the parent
+// so that the parent continues unwinding when it is resumed (see
+// resumePanic). There is no parent at the top of a goroutine, so the program
+// is aborted instead.
+func forwardPanic(parent *coroutine) {
+	if !panicking {
+		return
+	}
+	if parent == nil {
+		fatalpanic()
+	}
+	panicking = false
+	state := new(panicState)
+	*state = currentPanic
+	currentPanic = panicState{}
+	parent.promise().pendingPanic = state
+}
+
+// resumePanic is called by the scheduler right before resuming a task. If a
+// panic was forwarded to this task, the panic state is restored so that the
+// task continues unwinding the stack.
+func resumePanic(t *coroutine) {
+	promise := t.promise()
+	if promise.pendingPanic == nil {
+		return
+	}
+	currentPanic = *promise.pendingPanic
+	promise.pendingPanic = nil
+	panicking = true
 }
 
-// Try to recover a panicking goroutine.
-func _recover() interface{} {
-	// Deferred functions are currently not executed during panic, so there is
-	// no way this can return anything besides nil.
-	return nil
+// yieldPanic is called right after a blocking operation (like a channel send),
+// right before the coroutine suspends. If the operation panicked, the coroutine
+// is rescheduled so that it starts unwinding once it is resumed.
+func yieldPanic(t *coroutine) {
+	if !panicking {
+		return
+	}
+	forwardPanic(t)
+	activateTask(t)
+}
+
+// fatalpanic prints the current panic and exits the program with exit code 2,
+// like the Go runtime does. It is called when a panic reaches the top of a
+// goroutine without being recovered.
+func fatalpanic() {
+	panicking = false
+	printstring("panic: ")
+	if err, ok := currentPanic.value.(runtimeError); ok {
+		printstring("runtime error: ")
+		printstring(err.msg)
+	} else {
+		printitf(currentPanic.value)
+	}
+	printnl()
+	printstack(currentPanic.stack[:currentPanic.stackLen])
+	exit(2)
 }
 
 // Panic when trying to dereference a nil pointer.
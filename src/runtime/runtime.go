@@ -35,7 +35,7 @@ func GOROOT() string {
 
 //go:linkname os_runtime_args os.runtime_args
 func os_runtime_args() []string {
-	return nil
+	return args()
 }
 
 // Copy size bytes from src to dst. The memory areas must not overlap.
@@ -98,7 +98,12 @@ func os_sigpipe() {
 	runtimePanic("too many writes on closed pipe")
 }
 
+//go:linkname syscall_Exit syscall.Exit
+func syscall_Exit(code int) {
+	exit(code)
+}
+
 //go:linkname syscall_runtime_envs syscall.runtime_envs
 func syscall_runtime_envs() []string {
-	return nil
+	return envs()
 }
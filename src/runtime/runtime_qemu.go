@@ -21,10 +21,52 @@ func main() {
 	preinit()
 	initAll()
 	callMain()
-	arm.SemihostingCall(arm.SemihostingReportException, arm.SemihostingApplicationExit)
+	exit(0)
+}
+
+// exit stops QEMU with the given exit code, using semihosting.
+func exit(code int) {
+	if code != 0 {
+		if hasExitExtended() {
+			// Only the extended exit call can report a specific exit code.
+			// It takes a parameter block with the reason and the exit code.
+			block := [2]uintptr{arm.SemihostingApplicationExit, uintptr(code)}
+			arm.SemihostingCall(arm.SemihostingExitExtended, uintptr(unsafe.Pointer(&block)))
+		}
+		// Not supported by the host, report a generic error instead. QEMU
+		// exits with exit code 1 in that case.
+		arm.SemihostingCall(arm.SemihostingReportException, arm.SemihostingRunTimeErrorUnknown)
+	} else {
+		arm.SemihostingCall(arm.SemihostingReportException, arm.SemihostingApplicationExit)
+	}
 	abort()
 }
 
+// hasExitExtended returns whether the host supports the extended exit call.
+// This must be checked before using it, as QEMU stops with an error on calls
+// it doesn't know. The supported extensions are read from the special file
+// ":semihosting-features", which starts with the magic bytes "SHFB" followed
+// by a byte with a bit for each extension.
+func hasExitExtended() bool {
+	const name = ":semihosting-features"
+	buf := []byte(name + "\x00")
+	openBlock := [3]uintptr{uintptr(unsafe.Pointer(&buf[0])), 1, uintptr(len(name))} // mode "rb"
+	fd := arm.SemihostingCall(arm.SemihostingOpen, uintptr(unsafe.Pointer(&openBlock)))
+	if fd < 0 {
+		// Feature detection isn't supported, so neither are extensions.
+		return false
+	}
+	var features [5]byte
+	readBlock := [3]uintptr{uintptr(fd), uintptr(unsafe.Pointer(&features[0])), uintptr(len(features))}
+	notRead := arm.SemihostingCall(arm.SemihostingRead, uintptr(unsafe.Pointer(&readBlock)))
+	closeBlock := [1]uintptr{uintptr(fd)}
+	arm.SemihostingCall(arm.SemihostingClose, uintptr(unsafe.Pointer(&closeBlock)))
+	if notRead != 0 || string(features[:4]) != "SHFB" {
+		return false
+	}
+	return features[4]&1 != 0 // SH_EXT_EXIT_EXTENDED
+}
+
 const asyncScheduler = false
 
 func sleepTicks(d timeUnit) {
@@ -45,3 +87,46 @@ var stdoutWrite *regValue = (*regValue)(unsafe.Pointer(uintptr(0x4000c000)))
 func putchar(c byte) {
 	*stdoutWrite = regValue(c)
 }
+
+// The maximum length of the command line that is retrieved from the host, to
+// limit the memory used for it.
+const maxCmdlineSize = 4096
+
+// args returns the command line passed to QEMU (the kernel name followed by the
+// -append parameter), split on spaces. It is retrieved from the host using
+// semihosting.
+func args() []string {
+	// The length of the command line can't be queried, and the host fails the
+	// call when the buffer is too small. Retry with a bigger buffer until it
+	// fits.
+	for size := 256; size <= maxCmdlineSize; size *= 2 {
+		buf := make([]byte, size)
+		// Parameter block: a pointer to the buffer and the size of the buffer.
+		// The size is replaced with the length of the command line on return.
+		block := [2]uintptr{uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf))}
+		if arm.SemihostingCall(arm.SemihostingGetCmdline, uintptr(unsafe.Pointer(&block))) == 0 {
+			return splitCmdline(buf[:block[1]])
+		}
+	}
+	return nil
+}
+
+// splitCmdline splits the command line on spaces, leaving out empty arguments.
+func splitCmdline(cmdline []byte) []string {
+	var args []string
+	start := 0
+	for i := 0; i <= len(cmdline); i++ {
+		if i == len(cmdline) || cmdline[i] == ' ' {
+			if i > start {
+				args = append(args, string(cmdline[start:i]))
+			}
+			start = i + 1
+		}
+	}
+	return args
+}
+
+// envs returns nil, as there is no environment on this target.
+func envs() []string {
+	return nil
+}
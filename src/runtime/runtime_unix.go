@@ -18,7 +18,11 @@ func malloc(size uintptr) unsafe.Pointer
 //go:export abort
 func abort()
 
+//go:export exit
+func exit(code int)
+
 //go:export clock_gettime
+//go:noescape
 func clock_gettime(clk_id uint, ts *timespec)
 
 const heapSize = 1 * 1024 * 1024 // 1MB to start
@@ -40,9 +44,21 @@ type timespec struct {
 
 const CLOCK_MONOTONIC_RAW = 4
 
+// The arguments passed to main by libc, used for os.Args and the environment.
+var (
+	main_argc int32
+	main_argv *unsafe.Pointer
+	main_envp *unsafe.Pointer
+)
+
 // Entry point for Go. Initialize all packages and call main.main().
 //go:export main
-func main() int {
+func main(argc int32, argv *unsafe.Pointer, envp *unsafe.Pointer) int {
+	// Store the command line and environment for os.Args and syscall.Getenv.
+	main_argc = argc
+	main_argv = argv
+	main_envp = envp
+
 	// Run initializers of all packages.
 	initAll()
 
@@ -64,8 +80,6 @@ func sleepTicks(d timeUnit) {
 }
 
 // Return monotonic time in nanoseconds.
-//
-// TODO: noescape
 func monotime() uint64 {
 	ts := timespec{}
 	clock_gettime(CLOCK_MONOTONIC_RAW, &ts)
@@ -75,3 +89,42 @@ func monotime() uint64 {
 func ticks() timeUnit {
 	return timeUnit(monotime())
 }
+
+// args returns the command line arguments passed to the program.
+func args() []string {
+	return cstrings(main_argv, int(main_argc))
+}
+
+// envs returns the environment of the program, as key=value strings.
+func envs() []string {
+	if main_envp == nil {
+		return nil
+	}
+	// The environment is terminated by a NULL pointer.
+	n := 0
+	for *cstringAt(main_envp, n) != nil {
+		n++
+	}
+	return cstrings(main_envp, n)
+}
+
+// cstrings converts an array of n C strings to Go strings. The strings are not
+// copied, as they stay valid for the lifetime of the program.
+func cstrings(array *unsafe.Pointer, n int) []string {
+	strs := make([]string, n)
+	for i := range strs {
+		ptr := *cstringAt(array, i)
+		length := uintptr(0)
+		for *(*byte)(unsafe.Pointer(uintptr(ptr) + length)) != 0 {
+			length++
+		}
+		str := _string{ptr: (*byte)(ptr), length: length}
+		strs[i] = *(*string)(unsafe.Pointer(&str))
+	}
+	return strs
+}
+
+// cstringAt returns a pointer to the i-th element of an array of C strings.
+func cstringAt(array *unsafe.Pointer, i int) *unsafe.Pointer {
+	return (*unsafe.Pointer)(unsafe.Pointer(uintptr(unsafe.Pointer(array)) + uintptr(i)*unsafe.Sizeof(uintptr(0))))
+}
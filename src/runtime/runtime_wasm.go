@@ -14,6 +14,7 @@ const tickMicros = 1000000
 func io_get_stdout() int32
 
 //go:export resource_write
+//go:noescape
 func resource_write(id int32, ptr *uint8, len int32) int32
 
 var stdout int32
@@ -44,6 +45,17 @@ func go_scheduler() {
 
 const asyncScheduler = true
 
+// go_deadlock is called by the host when it won't call into the program
+// anymore, for example when there are no more pending events in Node.js. The
+// scheduler itself can't detect a deadlock with an async scheduler, as the host
+// might still call an exported function that wakes up a blocked goroutine.
+//go:export go_deadlock
+func go_deadlock() {
+	if blockedTasks != nil && !mainExited {
+		deadlock()
+	}
+}
+
 // This function is called by the scheduler.
 // Schedule a call to runtime.scheduler, do not actually sleep.
 //go:export runtime.sleepTicks
@@ -52,6 +64,16 @@ func sleepTicks(d timeUnit)
 //go:export runtime.ticks
 func ticks() timeUnit
 
+//go:export proc_exit
+func proc_exit(code uint32)
+
+// exit stops the program with the given exit code. The host must not return
+// from proc_exit.
+func exit(code int) {
+	proc_exit(uint32(code))
+	abort()
+}
+
 // Abort executes the wasm 'unreachable' instruction.
 func abort() {
 	trap()
@@ -47,15 +47,64 @@ func (t *coroutine) promise() *taskState {
 	return (*taskState)(t._promise(int32(unsafe.Alignof(taskState{})), false))
 }
 
+// Get the function that continues the task when it is resumed. This relies on
+// the frame layout of the switched-resume lowering of LLVM coroutines, which
+// always stores this pointer at the start of the coroutine frame (it's what
+// llvm.coro.resume calls). It is only used to print the location of a blocked
+// task.
+func (t *coroutine) resumeFunc() uintptr {
+	return *(*uintptr)(unsafe.Pointer(t))
+}
+
 func makeGoroutine(*uint8) *uint8
 
 // State/promise of a task. Internally represented as:
 //
-//     {i8* next, i1 commaOk, i32/i64 data}
+//     {i8* next, i1 commaOk, %panicState* pendingPanic, i32/i64 data, i8* ptr, i8 waitReason, i8* blockedNext}
 type taskState struct {
-	next    *coroutine
-	commaOk bool // 'comma-ok' flag for channel receive operation
-	data    uint
+	next         *coroutine
+	commaOk      bool        // 'comma-ok' flag for channel receive operation
+	pendingPanic *panicState // a panic was forwarded to this task, see forwardPanic
+	data         uint
+	ptr          unsafe.Pointer // value buffer of a blocked channel operation, or return value buffer
+	waitReason   waitReason     // the operation this task is blocked on, if any
+	blockedNext  *coroutine     // next task in the list of blocked tasks
+}
+
+// The operation a blocked task is waiting on, printed when a deadlock is
+// detected.
+type waitReason uint8
+
+const (
+	waitReasonNone waitReason = iota
+	waitReasonChanSend
+	waitReasonChanReceive
+	waitReasonChanSendNilChan
+	waitReasonChanReceiveNilChan
+	waitReasonSelect
+	waitReasonSelectNoCases
+	waitReasonSemacquire
+)
+
+func (r waitReason) String() string {
+	switch r {
+	case waitReasonChanSend:
+		return "chan send"
+	case waitReasonChanReceive:
+		return "chan receive"
+	case waitReasonChanSendNilChan:
+		return "chan send (nil chan)"
+	case waitReasonChanReceiveNilChan:
+		return "chan receive (nil chan)"
+	case waitReasonSelect:
+		return "select"
+	case waitReasonSelectNoCases:
+		return "select (no cases)"
+	case waitReasonSemacquire:
+		return "semacquire"
+	default:
+		return "running"
+	}
 }
 
 // Queues used by the scheduler.
@@ -69,6 +118,22 @@ var (
 	sleepQueueBaseTime timeUnit
 )
 
+// Tasks that are blocked on a channel operation or semaphore, linked through
+// the blockedNext pointer of the promise. When there are no other tasks left
+// to run, these tasks can never be woken up again.
+var blockedTasks *coroutine
+
+// The task that is currently being resumed by the scheduler, see returnTask.
+var resumedTask *coroutine
+
+// Set by returnTask when a blocking function returns to its parent without
+// having blocked, see awaitTask.
+var returnedDirectly bool
+
+// Set when main.main has returned. Tasks that are still blocked after that
+// are not a deadlock, as the program would have exited already in Go.
+var mainExited bool
+
 // Simple logging, for debugging.
 func scheduleLog(msg string) {
 	if schedulerDebug {
@@ -104,9 +169,74 @@ func activateTask(task *coroutine) {
 		return
 	}
 	scheduleLogTask("  set runnable:", task)
+	if task.promise().waitReason != waitReasonNone {
+		unblockTask(task)
+	}
 	runqueuePushBack(task)
 }
 
+// Called by a blocking function right before it returns to its parent.
+//
+// Usually the parent is suspended, waiting for this function to return, so it
+// is re-activated. But when this function didn't block at all (for example,
+// when locking a mutex that isn't contended), it is still running as part of
+// the call from its parent. In that case, the parent continues right away
+// instead of going through the scheduler, see awaitTask.
+//
+// This is a compiler intrinsic.
+func returnTask(t, parent *coroutine) {
+	if t != resumedTask && parent != nil {
+		// This task has never been suspended, as every suspended task is
+		// resumed by the scheduler. The panic flag (if set) is checked by
+		// the parent right after the call.
+		returnedDirectly = true
+		return
+	}
+	forwardPanic(parent)
+	activateTask(parent)
+}
+
+// Called by a blocking function right after calling another blocking function.
+// It returns whether the callee has already returned (see returnTask), in
+// which case the caller doesn't need to suspend.
+//
+// This is a compiler intrinsic.
+func awaitTask() bool {
+	returned := returnedDirectly
+	returnedDirectly = false
+	return returned
+}
+
+// Mark the task as blocked on the given operation. It is added to the list of
+// blocked tasks until it is activated again.
+func blockTask(t *coroutine, reason waitReason) {
+	scheduleLogTask("  blocked:", t)
+	promise := t.promise()
+	promise.waitReason = reason
+	promise.blockedNext = blockedTasks
+	blockedTasks = t
+}
+
+// Remove the task from the list of blocked tasks.
+func unblockTask(t *coroutine) {
+	for ptr := &blockedTasks; *ptr != nil; ptr = &(*ptr).promise().blockedNext {
+		if *ptr == t {
+			promise := t.promise()
+			*ptr = promise.blockedNext
+			promise.blockedNext = nil
+			promise.waitReason = waitReasonNone
+			return
+		}
+	}
+}
+
+// Called when main.main returns, in a program with a scheduler.
+//
+// This is a compiler intrinsic.
+func mainReturned() {
+	mainExited = true
+}
+
 // Add this task to the end of the run queue. May also destroy the task if it's
 // done.
 func runqueuePushBack(t *coroutine) {
@@ -221,10 +351,16 @@ func scheduler() {
 		t := runqueuePopFront()
 		if t == nil {
 			if sleepQueue == nil {
-				// No more tasks to execute.
-				// It would be nice if we could detect deadlocks here, because
-				// there might still be functions waiting on each other in a
-				// deadlock.
+				// No more tasks to execute. Tasks that are still blocked can
+				// never be woken up again by other tasks.
+				// With an async scheduler, the host may still call an
+				// exported function later (for example, from a JavaScript
+				// event handler) that wakes up a blocked task. That can't be
+				// known here, so the host reports the deadlock instead once
+				// it won't call into the program anymore (see go_deadlock).
+				if blockedTasks != nil && !mainExited && !asyncScheduler {
+					deadlock()
+				}
 				scheduleLog("  no tasks left!")
 				return
 			}
@@ -245,6 +381,9 @@ func scheduler() {
 		// Run the given task.
 		scheduleLog("  <- runqueuePopFront")
 		scheduleLogTask("  run:", t)
+		resumePanic(t)
+		resumedTask = t
 		t.resume()
+		resumedTask = nil
 	}
 }
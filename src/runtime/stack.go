@@ -1,16 +1,208 @@
 package runtime
 
+// This file implements runtime.Caller, runtime.FuncForPC and the stack traces
+// printed on a panic.
+//
+// The compiler emits a table with the address, name and source position of
+// every function in the program (see compiler/functable.go). The stack is
+// walked using frame pointers, which the compiler enables when it emits this
+// table, see callers. A program counter is then mapped to the function with
+// the highest address that is not above it.
+//
+// The table can be stripped with the -no-functable flag. In that case (and on
+// targets that don't support walking the stack), no stack trace is printed and
+// runtime.Caller always fails.
+//
+// With the -stack-lines flag (and debug information), the compiler also emits a
+// table with the address and source position of each call, see lineTable.
+// Without it, only the source position of the function declaration is known.
+//
+// File names are stored relative to the source directory of the package (for
+// example "fmt/print.go") or relative to the working directory of the
+// compiler.
+
+import (
+	"unsafe"
+)
+
+// Func is an entry in the function table created by the compiler. The layout
+// must match the one in compiler/functable.go.
 type Func struct {
+	entry uintptr // address of the function
+	name  *string // fully qualified name of the function
+	file  *string // file with the function declaration, or nil if unknown
+	line  uint32  // line of the function declaration
+	flags uint32  // funcFlagExported
+}
+
+// The function has been called from outside Go (like the program entry point
+// or an interrupt handler) and is therefore at the bottom of the Go stack.
+const funcFlagExported = 1
+
+// The function table, terminated by an entry with a zero address.
+//go:extern runtime.funcTable
+var funcTable Func
+
+// lineEntry is an entry in the line table created by the compiler. The layout
+// must match the one in compiler/functable.go.
+type lineEntry struct {
+	pc   uintptr // address of the code leading up to a call
+	file *string // file with the call
+	line uint32  // line of the call
+}
+
+// The line table, terminated by an entry with a zero address. The entries are
+// not sorted.
+//go:extern runtime.lineTable
+var lineTable lineEntry
+
+// The maximum number of functions printed in a stack trace.
+const maxStackDepth = 32
+
+// hasFuncTable returns whether the compiler emitted a function table. Frame
+// pointers can only be relied upon when it did.
+func hasFuncTable() bool {
+	return funcTable.entry != 0
 }
 
+// findFunc returns the function that contains the given program counter, or
+// nil if it isn't known.
+func findFunc(pc uintptr) *Func {
+	if pc == 0 {
+		return nil
+	}
+	var found *Func
+	for f := &funcTable; f.entry != 0; f = (*Func)(unsafe.Pointer(uintptr(unsafe.Pointer(f)) + unsafe.Sizeof(Func{}))) {
+		// The return address may point just past the end of a function that
+		// ends with a call, so compare with the address of the call itself.
+		if f.entry <= pc-1 && (found == nil || f.entry > found.entry) {
+			found = f
+		}
+	}
+	return found
+}
+
+// FuncForPC returns a *Func describing the function that contains the given
+// program counter address, or else nil.
 func FuncForPC(pc uintptr) *Func {
-	return nil
+	return findFunc(pc)
 }
 
+// Name returns the name of the function.
 func (f *Func) Name() string {
-	return ""
+	if f == nil || f.name == nil {
+		return ""
+	}
+	return *f.name
+}
+
+// Entry returns the entry address of the function.
+func (f *Func) Entry() uintptr {
+	if f == nil {
+		return 0
+	}
+	return f.entry
 }
 
+// FileLine returns the file name and line number of the source code
+// corresponding to the program counter pc. If the line of the call isn't
+// known, the position of the function declaration is returned instead.
+func (f *Func) FileLine(pc uintptr) (file string, line int) {
+	if f == nil {
+		return "?", 0
+	}
+	if e := f.findLine(pc); e != nil {
+		return *e.file, int(e.line)
+	}
+	if f.file == nil {
+		return "?", 0
+	}
+	return *f.file, int(f.line)
+}
+
+// findLine returns the line table entry of the call that the given return
+// address belongs to, or nil if it isn't known. The entry with the highest
+// address that is not above the call and not before the start of the function
+// is the one that belongs to the call.
+func (f *Func) findLine(pc uintptr) *lineEntry {
+	var found *lineEntry
+	for e := &lineTable; e.pc != 0; e = (*lineEntry)(unsafe.Pointer(uintptr(unsafe.Pointer(e)) + unsafe.Sizeof(lineEntry{}))) {
+		if e.pc >= f.entry && e.pc <= pc-1 && (found == nil || e.pc > found.pc) {
+			found = e
+		}
+	}
+	return found
+}
+
+// Caller reports file and line number information about function invocations
+// on the calling goroutine's stack. The argument skip is the number of stack
+// frames to ascend, with 0 identifying the caller of Caller.
+//go:noinline
 func Caller(skip int) (pc uintptr, file string, line int, ok bool) {
-	return 0, "", 0, false
+	var pcs [1]uintptr
+	if callers(skip+1, pcs[:]) == 0 {
+		return 0, "", 0, false
+	}
+	pc = pcs[0]
+	file, line = findFunc(pc).FileLine(pc)
+	return pc, file, line, true
+}
+
+// printstack prints the functions of the given return addresses, as returned
+// by callers, after an empty line. Functions in the runtime at the top and the
+// bottom of the stack are left out, see userFrames. Nothing is printed if no
+// functions are left.
+func printstack(pcs []uintptr) {
+	pcs = userFrames(pcs)
+	if len(pcs) == 0 {
+		return
+	}
+	printnl()
+	printframes(pcs)
+}
+
+// userFrames returns the given return addresses without the runtime functions
+// at the top of the stack (like _panic) and at the bottom of the stack (like
+// the functions that call main.main).
+func userFrames(pcs []uintptr) []uintptr {
+	for len(pcs) != 0 && isRuntimeFrame(pcs[0]) {
+		pcs = pcs[1:]
+	}
+	for len(pcs) != 0 && isRuntimeFrame(pcs[len(pcs)-1]) {
+		pcs = pcs[:len(pcs)-1]
+	}
+	return pcs
+}
+
+// isRuntimeFrame returns whether the given return address is in a function of
+// the runtime.
+func isRuntimeFrame(pc uintptr) bool {
+	name := findFunc(pc).Name()
+	return len(name) >= len("runtime.") && name[:len("runtime.")] == "runtime."
+}
+
+// printframes prints the name and source position of the function of each
+// return address, skipping unknown functions.
+func printframes(pcs []uintptr) {
+	for _, pc := range pcs {
+		f := findFunc(pc)
+		if f == nil {
+			continue
+		}
+		file, line := f.FileLine(pc)
+		printstring(f.Name())
+		printstring("()\n\t")
+		printstring(file)
+		printstring(":")
+		printuint32(uint32(line))
+		printnl()
+	}
+}
+
+// printcallers prints a stack trace of the calling function.
+//go:noinline
+func printcallers() {
+	var pcs [maxStackDepth]uintptr
+	n := callers(1, pcs[:])
+	printstack(pcs[:n])
 }
@@ -0,0 +1,49 @@
+// +build !wasm tinygo.arm
+
+package runtime
+
+// This file implements walking the stack using frame pointers. The frame
+// pointer points to the frame pointer of the calling function, which is
+// directly followed by the return address. This is the case on all supported
+// architectures (amd64, 386, arm64 and Thumb on Cortex-M).
+
+import (
+	"unsafe"
+)
+
+// frameAddress returns the frame pointer of the current function when level is
+// 0.
+//go:export llvm.frameaddress
+func frameAddress(level int32) unsafe.Pointer
+
+// callers stores the return addresses of the functions on the stack in pcs,
+// starting with the caller of callers when skip is 0, and returns the number of
+// addresses stored. It stops at the bottom of the Go stack.
+//go:noinline
+func callers(skip int, pcs []uintptr) int {
+	if !hasFuncTable() {
+		// Frame pointers may not be present.
+		return 0
+	}
+	n := 0
+	fp := frameAddress(0)
+	for fp != nil && n < len(pcs) {
+		pc := *(*uintptr)(unsafe.Pointer(uintptr(fp) + unsafe.Sizeof(uintptr(0))))
+		f := findFunc(pc)
+		if f == nil {
+			break
+		}
+		if skip > 0 {
+			skip--
+		} else {
+			pcs[n] = pc
+			n++
+		}
+		if f.flags&funcFlagExported != 0 {
+			// Called from outside Go, there may not be a frame pointer.
+			break
+		}
+		fp = *(*unsafe.Pointer)(fp)
+	}
+	return n
+}
@@ -0,0 +1,11 @@
+// +build wasm,!tinygo.arm
+
+package runtime
+
+// Walking the stack is not supported on WebAssembly (where the stack isn't
+// accessible) and AVR.
+
+// callers is not implemented on this target, it never stores any addresses.
+func callers(skip int, pcs []uintptr) int {
+	return 0
+}
@@ -89,6 +89,45 @@ func stringToBytes(x _string) (slice struct {
 	return
 }
 
+// Create a string from a []rune slice.
+func stringFromRunes(runeSlice []rune) (s _string) {
+	// Count the number of bytes that will be in the string.
+	for _, r := range runeSlice {
+		_, numBytes := encodeUTF8(r)
+		s.length += numBytes
+	}
+
+	// Allocate memory for the string.
+	s.ptr = (*byte)(alloc(s.length))
+
+	// Encode runes to UTF-8 and store the resulting bytes in the string.
+	index := uintptr(0)
+	for _, r := range runeSlice {
+		array, numBytes := encodeUTF8(r)
+		for _, c := range array[:numBytes] {
+			*(*byte)(unsafe.Pointer(uintptr(unsafe.Pointer(s.ptr)) + index)) = c
+			index++
+		}
+	}
+
+	return
+}
+
+// Convert a string to a []rune slice.
+func stringToRunes(s string) []rune {
+	var n = 0
+	for range s {
+		n++
+	}
+	var r = make([]rune, n)
+	n = 0
+	for _, e := range s {
+		r[n] = e
+		n++
+	}
+	return r
+}
+
 // Create a string from a Unicode code point.
 func stringFromUnicode(x rune) _string {
 	array, length := encodeUTF8(x)
@@ -114,6 +153,11 @@ func stringNext(s string, it *stringIterator) (bool, int, rune) {
 func encodeUTF8(x rune) ([4]byte, uintptr) {
 	// https://stackoverflow.com/questions/6240055/manually-converting-unicode-codepoints-into-utf-8-and-utf-16
 	// Note: this code can probably be optimized (in size and speed).
+	if x < 0 || x > 0x10ffff || (x >= 0xd800 && x <= 0xdfff) {
+		// Invalid Unicode code point or surrogate half, which are encoded as
+		// the replacement character.
+		x = 0xfffd
+	}
 	switch {
 	case x <= 0x7f:
 		return [4]byte{byte(x), 0, 0, 0}, 1
@@ -126,15 +170,12 @@ func encodeUTF8(x rune) ([4]byte, uintptr) {
 		b2 := 0x80 | byte((x>>6)&0x3f)
 		b3 := 0x80 | byte((x>>0)&0x3f)
 		return [4]byte{b1, b2, b3, 0}, 3
-	case x <= 0x10ffff:
+	default: // x <= 0x10ffff
 		b1 := 0xf0 | byte(x>>18)
 		b2 := 0x80 | byte((x>>12)&0x3f)
 		b3 := 0x80 | byte((x>>6)&0x3f)
 		b4 := 0x80 | byte((x>>0)&0x3f)
 		return [4]byte{b1, b2, b3, b4}, 4
-	default:
-		// Invalid Unicode code point.
-		return [4]byte{0xef, 0xbf, 0xbd, 0}, 3
 	}
 }
 
@@ -1,13 +1,143 @@
 package runtime
 
-// This file contains stub implementations for internal/poll.
+// This file implements semaphores, which are the basis for the blocking
+// primitives in the sync package and in internal/poll.
+//
+// A goroutine that has to wait on a semaphore is parked in a list of waiting
+// goroutines (linked through the next pointer of the promise, with the ptr
+// field pointing to the semaphore). Releasing a semaphore hands it off directly
+// to the first goroutine waiting on it, if there is one.
 
-//go:linkname semacquire internal/poll.runtime_Semacquire
-func semacquire(sema *uint32) {
-	panic("todo: semacquire")
+import (
+	"unsafe"
+)
+
+// Goroutines that are blocked in semacquire, in FIFO order.
+var semaWaiters *coroutine
+
+// Pseudo function call that is replaced during goroutine lowering with a call
+// to semacquire followed by a suspend point, or with a call to
+// semacquireNoScheduler when the program doesn't need a scheduler.
+//
+//go:noescape
+func semacquireStub(caller *coroutine, sema *uint32)
+
+// Acquire the semaphore, or park the caller until it is released.
+//
+// This is a compiler intrinsic, see semacquireStub.
+func semacquire(caller *coroutine, sema *uint32) {
+	if *sema != 0 {
+		// Acquired the semaphore without waiting.
+		*sema--
+		activateTask(caller)
+		return
+	}
+
+	// Wait until the semaphore is handed off by semrelease.
+	blockTask(caller, waitReasonSemacquire)
+	promise := caller.promise()
+	promise.ptr = unsafe.Pointer(sema)
+	if semaWaiters == nil {
+		semaWaiters = caller
+		return
+	}
+	last := semaWaiters
+	for last.promise().next != nil {
+		last = last.promise().next
+	}
+	last.promise().next = caller
 }
 
-//go:linkname semrelease internal/poll.runtime_Semrelease
+// Acquire the semaphore in a program without a scheduler. There are no other
+// goroutines that could release it, so waiting would wait forever.
+//
+// This is a compiler intrinsic, see semacquireStub.
+func semacquireNoScheduler(sema *uint32) {
+	if *sema == 0 {
+		blockForever(waitReasonSemacquire)
+	}
+	*sema--
+}
+
+// Release the semaphore, waking up the first goroutine waiting on it if there
+// is any.
 func semrelease(sema *uint32) {
-	panic("todo: semrelease")
+	var prev *coroutine
+	for t := semaWaiters; t != nil; t = t.promise().next {
+		promise := t.promise()
+		if promise.ptr != unsafe.Pointer(sema) {
+			prev = t
+			continue
+		}
+		// Hand off the semaphore to this goroutine.
+		if prev == nil {
+			semaWaiters = promise.next
+		} else {
+			prev.promise().next = promise.next
+		}
+		promise.next = nil
+		promise.ptr = nil
+		activateTask(t)
+		return
+	}
+	*sema++
+}
+
+// deadlock is called by the scheduler when all goroutines are blocked, so that
+// none of them can ever be woken up again. It prints the operation each
+// goroutine is blocked on and the function it is blocked in. The stack of a
+// blocked goroutine can't be walked, as it is stored in coroutine frames
+// instead of on the system stack, so only the innermost function is known.
+// Like the Go runtime, it exits with exit code 2.
+func deadlock() {
+	printstring("fatal error: all goroutines are asleep - deadlock!")
+	printnl()
+	for t := blockedTasks; t != nil; t = t.promise().blockedNext {
+		printnl()
+		printstring("goroutine [")
+		printstring(t.promise().waitReason.String())
+		printstring("]:")
+		printnl()
+		// The resume function is part of the blocked function, see
+		// CreateFuncTable.
+		printframes([]uintptr{t.resumeFunc() + 1})
+	}
+	exit(2)
+}
+
+// blockForever is called when a goroutine blocks in a program without a
+// scheduler. There are no other goroutines that could wake it up, so this is
+// always a deadlock.
+func blockForever(reason waitReason) {
+	printstring("fatal error: all goroutines are asleep - deadlock!")
+	printnl()
+	printnl()
+	printstring("goroutine [")
+	printstring(reason.String())
+	printstring("]:")
+	printnl()
+	var pcs [maxStackDepth]uintptr
+	n := callers(0, pcs[:])
+	printframes(userFrames(pcs[:n]))
+	exit(2)
+}
+
+//go:linkname sync_runtime_Semacquire sync.runtime_Semacquire
+func sync_runtime_Semacquire(sema *uint32) {
+	semacquireStub(nil, sema)
+}
+
+//go:linkname sync_runtime_Semrelease sync.runtime_Semrelease
+func sync_runtime_Semrelease(sema *uint32) {
+	semrelease(sema)
+}
+
+//go:linkname poll_runtime_Semacquire internal/poll.runtime_Semacquire
+func poll_runtime_Semacquire(sema *uint32) {
+	semacquireStub(nil, sema)
+}
+
+//go:linkname poll_runtime_Semrelease internal/poll.runtime_Semrelease
+func poll_runtime_Semrelease(sema *uint32) {
+	semrelease(sema)
 }
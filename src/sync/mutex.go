@@ -1,15 +1,23 @@
 package sync
 
-// These mutexes assume there is only one thread of operation: no goroutines,
-// interrupts or anything else.
+// These mutexes are cooperative: a goroutine that tries to lock a locked mutex
+// is parked in the scheduler until the mutex is unlocked, at which point the
+// lock is handed off directly to the first waiting goroutine. Locking a mutex
+// that isn't locked never blocks, so the caller continues right away without
+// going through the scheduler.
 
 type Mutex struct {
-	locked bool
+	locked  bool
+	waiters uint32 // number of goroutines blocked in Lock
+	sema    uint32
 }
 
 func (m *Mutex) Lock() {
 	if m.locked {
-		panic("todo: block on locked mutex")
+		// Wait until Unlock hands off the lock to this goroutine.
+		m.waiters++
+		runtime_Semacquire(&m.sema)
+		return
 	}
 	m.locked = true
 }
@@ -18,6 +26,13 @@ func (m *Mutex) Unlock() {
 	if !m.locked {
 		panic("sync: unlock of unlocked Mutex")
 	}
+	if m.waiters != 0 {
+		// Hand off the lock to the first waiting goroutine. The mutex stays
+		// locked.
+		m.waiters--
+		runtime_Semrelease(&m.sema)
+		return
+	}
 	m.locked = false
 }
 
@@ -50,3 +65,9 @@ func (rw *RWMutex) RUnlock() {
 		rw.m.Unlock()
 	}
 }
+
+// A Locker represents an object that can be locked and unlocked.
+type Locker interface {
+	Lock()
+	Unlock()
+}
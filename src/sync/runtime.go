@@ -0,0 +1,11 @@
+package sync
+
+// These functions are implemented in the runtime, see src/runtime/sync.go.
+
+// Wait until *s > 0 and then decrement it. The calling goroutine is parked in
+// the scheduler while waiting.
+func runtime_Semacquire(s *uint32)
+
+// Increment *s, or wake up a goroutine that is blocked in runtime_Semacquire
+// on the same semaphore.
+func runtime_Semrelease(s *uint32)
@@ -0,0 +1,38 @@
+package sync
+
+// A WaitGroup waits for a collection of goroutines to finish.
+type WaitGroup struct {
+	counter uint32
+	waiters uint32 // number of goroutines blocked in Wait
+	sema    uint32
+}
+
+// Add adds delta, which may be negative, to the WaitGroup counter. When the
+// counter becomes zero, all goroutines blocked on Wait are released.
+func (wg *WaitGroup) Add(delta int) {
+	counter := int(wg.counter) + delta
+	if counter < 0 {
+		panic("sync: negative WaitGroup counter")
+	}
+	wg.counter = uint32(counter)
+	if counter != 0 {
+		return
+	}
+	for ; wg.waiters != 0; wg.waiters-- {
+		runtime_Semrelease(&wg.sema)
+	}
+}
+
+// Done decrements the WaitGroup counter by one.
+func (wg *WaitGroup) Done() {
+	wg.Add(-1)
+}
+
+// Wait blocks until the WaitGroup counter is zero.
+func (wg *WaitGroup) Wait() {
+	if wg.counter == 0 {
+		return
+	}
+	wg.waiters++
+	runtime_Semacquire(&wg.sema)
+}
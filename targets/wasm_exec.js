@@ -75,6 +75,11 @@
 		constructor() {
 			this._callbackTimeouts = new Map();
 			this._nextCallbackTimeoutID = 1;
+			this.exit = (code) => {
+				if (code !== 0) {
+					console.warn("exit code:", code);
+				}
+			};
 
 			const mem = () => {
 				// The buffer may change when requesting more memory.
@@ -206,6 +211,19 @@
 						}
 					},
 
+					// func proc_exit(code uint32)
+					proc_exit: (code) => {
+						if (logLine.length > 0) {
+							// write the last line
+							console.log(decoder.decode(new Uint8Array(logLine)));
+							logLine = [];
+						}
+						this.exited = true;
+						this.exit(code);
+						// Stop running the program, the exit call must not return.
+						throw new Error("Go program has exited");
+					},
+
 					// func ticks() float64
 					"runtime.ticks": () => {
 						return timeOrigin + performance.now();
@@ -344,7 +362,13 @@
 						setTimeout(resolve, 0); // make sure it is asynchronous
 					};
 				});
-				this._inst.exports.cwa_main();
+				try {
+					this._inst.exports.cwa_main();
+				} catch (err) {
+					if (!this.exited) {
+						throw err;
+					}
+				}
 				if (this.exited) {
 					break;
 				}
@@ -382,11 +406,13 @@
 		}
 
 		const go = new Go();
+		go.exit = process.exit;
 		WebAssembly.instantiate(fs.readFileSync(process.argv[2]), go.importObject).then((result) => {
 			process.on("exit", (code) => { // Node.js exits if no callback is pending
 				if (code === 0 && !go.exited) {
 					// deadlock, make Go print error and stack traces
 					go._callbackShutdown = true;
+					go._inst.exports.go_deadlock();
 				}
 			});
 			return go.run(result.instance);
@@ -0,0 +1,72 @@
+package main
+
+// This file is compiled with -print-allocs by TestPrintAllocs. Heap allocations
+// that are not listed in allocs.txt must have been moved to the stack.
+
+import "unsafe"
+
+var global *int
+
+//go:export write
+//go:noescape
+func write(fd int32, buf unsafe.Pointer, count uintptr) int
+
+//go:export store
+func store(ptr unsafe.Pointer)
+
+func main() {
+	stackLocal()
+	stackCallee()
+	stackNoEscape()
+	heapExternal()
+	heapGlobal()
+	println(*global)
+	println(*heapReturned())
+	heapTooBig()
+}
+
+//go:noinline
+func stackLocal() {
+	n := new(int)
+	*n = 3
+	println(*n)
+}
+
+//go:noinline
+func stackCallee() {
+	n := 5
+	load(&n)
+}
+
+//go:noinline
+func load(n *int) {
+	println(*n)
+}
+
+//go:noinline
+func stackNoEscape() {
+	buf := [4]byte{'a', 'b', 'c', '\n'}
+	write(1, unsafe.Pointer(&buf), uintptr(len(buf)))
+}
+
+//go:noinline
+func heapExternal() {
+	n := 7
+	store(unsafe.Pointer(&n))
+}
+
+//go:noinline
+func heapGlobal() {
+	global = new(int)
+}
+
+//go:noinline
+func heapReturned() *int {
+	return new(int)
+}
+
+//go:noinline
+func heapTooBig() {
+	var buf [300]byte
+	write(1, unsafe.Pointer(&buf), uintptr(len(buf)))
+}
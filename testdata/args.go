@@ -0,0 +1,15 @@
+package main
+
+import "os"
+
+func main() {
+	// The first argument is the program name, which differs per target.
+	println("number of args:", len(os.Args))
+	for i, arg := range os.Args[1:] {
+		if len(arg) > 16 {
+			println("arg", i+1, "has length", len(arg))
+		} else {
+			println("arg", i+1, "is", arg)
+		}
+	}
+}
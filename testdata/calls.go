@@ -16,6 +16,14 @@ type Printer interface {
 	Print(string)
 }
 
+type OtherThing struct {
+	n int
+}
+
+func (t *OtherThing) Print(arg string) {
+	println("OtherThing.Print:", t.n, "arg:", arg)
+}
+
 func main() {
 	thing := &Thing{"foo"}
 
@@ -24,6 +32,9 @@ func main() {
 
 	// deferred functions
 	testDefer()
+	ch := testDeferValues(deferred)
+	_, ok := <-ch
+	println("channel closed by defer:", !ok)
 
 	// Take a bound method and use it as a function pointer.
 	// This function pointer needs a context pointer.
@@ -63,6 +74,43 @@ func testDefer() {
 	println("deferring...")
 }
 
+func testDeferValues(f func(string, int)) chan int {
+	// Interface values: the interface is evaluated when the defer statement is
+	// executed.
+	var t Printer = &Thing{"first"}
+	defer t.Print("interface")
+	t = &OtherThing{5}
+	defer t.Print("interface")
+	t = nil
+	for _, p := range []Printer{Thing{"loop"}, &OtherThing{7}} {
+		defer p.Print("loop")
+	}
+
+	// Function values.
+	defer f("...run function value", 1)
+	g := func(msg string, i int) {
+		println(msg, i*2)
+	}
+	defer g("...run closure value", 2)
+	thing := Thing{"bound"}
+	bound := thing.Print
+	thing.name = "changed"
+	defer bound("bound method")
+
+	// Builtins.
+	m := map[string]int{"a": 1, "b": 2}
+	defer func() {
+		println("map length after delete:", len(m))
+	}()
+	defer delete(m, "a")
+	ch := make(chan int)
+	defer close(ch)
+	defer println("...run deferred println", 3)
+
+	println("deferring values...")
+	return ch
+}
+
 func deferred(msg string, i int) {
 	println(msg, i)
 }
@@ -0,0 +1,50 @@
+package main
+
+// Channel operations in a program without goroutines, which doesn't need a
+// scheduler.
+
+func main() {
+	ch := make(chan int, 2)
+	ch <- 1
+	ch <- 2
+	println("len, cap:", len(ch), cap(ch))
+	println("recv:", <-ch)
+	n, ok := <-ch
+	println("recv:", n, ok)
+
+	select {
+	case n := <-ch:
+		println("select: unexpected receive:", n)
+	default:
+		println("select: nothing to receive")
+	}
+	select {
+	case ch <- 3:
+		println("select: sent value")
+	default:
+		println("select: unexpected default")
+	}
+
+	// Both cases can always proceed, so both must be picked sometimes.
+	a := make(chan int, 1)
+	b := make(chan int, 1)
+	gotA, gotB := false, false
+	for i := 0; i < 100; i++ {
+		select {
+		case a <- i:
+			<-a
+			gotA = true
+		case b <- i:
+			<-b
+			gotB = true
+		}
+	}
+	println("select: picked both cases:", gotA && gotB)
+
+	close(ch)
+	for n := range ch {
+		println("after close:", n)
+	}
+	n, ok = <-ch
+	println("recv from closed channel:", n, ok)
+}
@@ -2,6 +2,10 @@ package main
 
 import "time"
 
+type bigValue struct {
+	a, b, c int64
+}
+
 func main() {
 	ch := make(chan int)
 	println("len, cap of channel:", len(ch), cap(ch))
@@ -43,6 +47,91 @@ func main() {
 	}
 	println("sum(100):", sum)
 
+	// Test select statements.
+	ch = make(chan int)
+	ch2 := make(chan int)
+
+	// Non-blocking select without a ready operation.
+	select {
+	case n := <-ch:
+		println("select: unexpected receive:", n)
+	default:
+		println("select: no value ready")
+	}
+
+	// Blocking select on multiple channels.
+	go selectSender(ch2, 42)
+	select {
+	case n := <-ch:
+		println("select: received from ch:", n)
+	case n := <-ch2:
+		println("select: received from ch2:", n)
+	}
+
+	// Blocking select with a send operation.
+	go selectReceiver(ch)
+	select {
+	case ch <- 5:
+		println("select: sent value")
+	case n := <-ch2:
+		println("select: unexpected receive:", n)
+	}
+
+	// Non-blocking select with a ready send operation.
+	go selectReceiver(ch)
+	select {
+	case ch <- 6:
+		println("select: sent value without blocking")
+	default:
+		println("select: unexpected default")
+	}
+
+	// Blocking select on a channel that is closed while blocked.
+	go selectCloser(ch)
+	select {
+	case n, ok := <-ch:
+		println("select: received from closed channel:", n, ok)
+	case n := <-ch2:
+		println("select: unexpected receive:", n)
+	}
+
+	// Test buffered channels.
+	buffered := make(chan int, 2)
+	buffered <- 1
+	buffered <- 2
+	println("len, cap of buffered channel:", len(buffered), cap(buffered))
+	go fastsender(buffered)
+	for i := 0; i < 4; i++ {
+		println("buffered:", <-buffered)
+	}
+	buffered <- 3
+	close(buffered)
+	for n := range buffered {
+		println("buffered after close:", n)
+	}
+	n, ok = <-buffered
+	println("recv from closed buffered channel:", n, ok)
+
+	// Test channels with values bigger than a machine word.
+	bigch := make(chan bigValue)
+	go bigSender(bigch)
+	big := <-bigch
+	println("big value:", big.a, big.b, big.c)
+	bigbuffered := make(chan bigValue, 1)
+	bigbuffered <- bigValue{4, 5, 6}
+	big = <-bigbuffered
+	println("big value (buffered):", big.a, big.b, big.c)
+
+	// Test buffer sizes of other integer types than int.
+	var size8 uint8 = 3
+	var size64 int64 = 2
+	println("cap of channels with other size types:", cap(make(chan int, size8)), cap(make(chan bool, size64)), cap(make(chan int, uintptr(1))))
+
+	// A goroutine that is still blocked when main returns is not a deadlock.
+	go func() {
+		<-make(chan int)
+	}()
+
 	// Allow goroutines to exit.
 	time.Sleep(time.Microsecond)
 }
@@ -93,3 +182,22 @@ func iterator(ch chan int, top int) {
 	}
 	close(ch)
 }
+
+func selectSender(ch chan int, n int) {
+	time.Sleep(time.Microsecond)
+	ch <- n
+}
+
+func selectReceiver(ch chan int) {
+	n := <-ch
+	println("select: received:", n)
+}
+
+func selectCloser(ch chan int) {
+	time.Sleep(time.Microsecond)
+	close(ch)
+}
+
+func bigSender(ch chan bigValue) {
+	ch <- bigValue{1, 2, 3}
+}
@@ -21,6 +21,68 @@ func main() {
 	go nowait()
 	time.Sleep(time.Millisecond)
 	println("done with non-blocking goroutine")
+
+	// Start goroutines on closures, function values and interface methods.
+	x := 3
+	go func() {
+		println("closure goroutine:", x)
+	}()
+	startGoroutine(nowaitPrint, "function value goroutine")
+	p := &myPrinter{"bound method goroutine:"}
+	startGoroutine(p.Print, "ok")
+	var itf printer = &myPrinter{"interface method goroutine:"}
+	go itf.Print("ok")
+	go func() {
+		time.Sleep(time.Millisecond)
+		println("blocking closure goroutine:", x)
+	}()
+	time.Sleep(2 * time.Millisecond)
+	println("done with goroutines")
+
+	// Return values from blocking functions.
+	println("blocking return value:", delayedSquare(5))
+	q, r := delayedDivMod(17, 5)
+	println("blocking multiple return values:", q, r)
+	println("blocking string result:", delayedConcat("foo", "bar"))
+
+	// Call blocking functions through function values and interfaces.
+	println("blocking function value:", callFunc(delayedSquare, 6))
+	println("non-blocking function value:", callFunc(square, 7))
+	add := func(n int) int {
+		time.Sleep(time.Millisecond)
+		return n + x
+	}
+	println("blocking closure:", callFunc(add, 4))
+	for _, rd := range []reader{&slowReader{5}, fastReader(8)} {
+		println("blocking interface method:", rd.Read())
+	}
+	var w waiter = &sleeper{"blocking single-implementation interface method"}
+	w.Wait()
+}
+
+func callFunc(fn func(int) int, n int) int {
+	return fn(n)
+}
+
+func square(n int) int {
+	return n * n
+}
+
+func delayedSquare(n int) int {
+	time.Sleep(time.Millisecond)
+	return n * n
+}
+
+func delayedDivMod(a, b int) (int, int) {
+	time.Sleep(time.Millisecond)
+	return a / b, a % b
+}
+
+func delayedConcat(a, b string) string {
+	// Call another blocking function, to make sure return values are passed
+	// through several levels of coroutines.
+	n := delayedSquare(2)
+	return a + b + string('0'+byte(n))
 }
 
 func sub() {
@@ -38,3 +100,55 @@ func wait() {
 func nowait() {
 	println("non-blocking goroutine")
 }
+
+func nowaitPrint(s string) {
+	println(s)
+}
+
+func startGoroutine(fn func(string), s string) {
+	go fn(s)
+}
+
+type printer interface {
+	Print(s string)
+}
+
+type myPrinter struct {
+	prefix string
+}
+
+func (p *myPrinter) Print(s string) {
+	println(p.prefix, s)
+}
+
+type reader interface {
+	Read() int
+}
+
+type slowReader struct {
+	n int
+}
+
+func (r *slowReader) Read() int {
+	time.Sleep(time.Millisecond)
+	return r.n
+}
+
+type fastReader int
+
+func (r fastReader) Read() int {
+	return int(r)
+}
+
+type waiter interface {
+	Wait()
+}
+
+type sleeper struct {
+	msg string
+}
+
+func (s *sleeper) Wait() {
+	time.Sleep(time.Millisecond)
+	println(s.msg)
+}
@@ -0,0 +1,13 @@
+package main
+
+func main() {
+	ch1 := make(chan int)
+	ch2 := make(chan int)
+	go func() {
+		println("receiving in goroutine")
+		<-ch1
+	}()
+	println("sending in main")
+	ch2 <- 1
+	println("unreachable")
+}
@@ -0,0 +1,19 @@
+package main
+
+import "os"
+
+func main() {
+	println("ENV1:", os.Getenv("ENV1"))
+	value, ok := os.LookupEnv("ENV2")
+	println("ENV2:", value, ok)
+	value, ok = os.LookupEnv("TINYGO_TEST_UNSET")
+	println("unset:", value == "", ok)
+
+	found := false
+	for _, kv := range os.Environ() {
+		if kv == "ENV1=VALUE1" {
+			found = true
+		}
+	}
+	println("in environ:", found)
+}
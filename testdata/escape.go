@@ -0,0 +1,62 @@
+package main
+
+type point struct {
+	x, y int
+}
+
+var saved *point
+
+func main() {
+	// Does not escape: only read and written through other functions.
+	p := &point{1, 2}
+	move(p, 3)
+	println("moved:", p.x, p.y, sum(p))
+
+	// Escapes by being stored in a global.
+	q := &point{5, 6}
+	save(q)
+	q.x = 7
+	println("saved:", saved.x, saved.y)
+
+	// Escapes by being returned.
+	r := newPoint(8)
+	println("returned:", r.x, r.y)
+
+	// Escapes through an interface value.
+	var itf interface{} = point{9, 10}
+	println("interface:", itf.(point).x, itf.(point).y)
+
+	// Recursive function with a pointer parameter.
+	n := 0
+	count(&n, 5)
+	println("counted:", n)
+}
+
+func move(p *point, delta int) {
+	shift(&p.x, delta)
+	shift(&p.y, delta)
+}
+
+func shift(n *int, delta int) {
+	*n += delta
+}
+
+func sum(p *point) int {
+	return p.x + p.y
+}
+
+func save(p *point) {
+	saved = p
+}
+
+func newPoint(n int) *point {
+	return &point{n, n * 2}
+}
+
+func count(n *int, depth int) {
+	if depth == 0 {
+		return
+	}
+	*n++
+	count(n, depth-1)
+}
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"os"
+)
+
+func main() {
+	defer println("deferred call, should not run")
+	println("before exit")
+	exit()
+	println("after exit, should not run")
+}
+
+func exit() {
+	os.Exit(0)
+}
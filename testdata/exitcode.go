@@ -0,0 +1,10 @@
+package main
+
+import (
+	"os"
+)
+
+func main() {
+	println("exiting with code 3")
+	os.Exit(3)
+}
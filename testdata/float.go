@@ -56,4 +56,34 @@ func main() {
 	// cast complex
 	println(complex64(c128))
 	println(complex128(c64))
+
+	// complex64 arithmetic
+	c64b := complex64(complex(-3, 0.5))
+	println(c64 + c64b)
+	println(c64 - c64b)
+	println(c64 * c64b)
+	println(c64 / c64b)
+	println(-c64)
+	println(c64 == c64b, c64 != c64b, c64 == c64)
+
+	// complex128 arithmetic
+	c128b := complex(1.5, 4.0)
+	println(c128 + c128b)
+	println(c128 - c128b)
+	println(c128 * c128b)
+	println(c128 / c128b)
+	println(-c128)
+	println(c128 == c128b, c128 != c128b, c128 == c128)
+
+	// complex division by zero
+	var zero complex128
+	println(c128 / zero)
+	println(zero / zero)
+
+	// named complex types
+	type namedComplex complex64
+	nc := namedComplex(c64)
+	nc *= 2
+	println(complex64(nc))
+	println(complex128(nc))
 }
@@ -22,6 +22,25 @@ func main() {
 	println("Stringer.(*Thing).String():", itf.(Stringer).String())
 
 	println("nested switch:", nestedSwitch('v', 3))
+
+	// Equality of interfaces, arrays and structs.
+	println("interface equality")
+	println(interface{}(3) == interface{}(3), interface{}(3) == interface{}(int8(3)), interface{}("foo") == interface{}("foo"))
+	println(interface{}(thing) == interface{}(thing), interface{}(thing) == interface{}(&Thing{"foo"}))
+	println(interface{}(*thing) == interface{}(Thing{"foo"}), interface{}(*thing) == interface{}(Thing{"bar"}))
+	println(interface{}(array) == interface{}(Array{1, 7, 11, 13}), interface{}(array) == interface{}(Array{1, 7, 11, 14}))
+	println(interface{}(SmallPair{3, 5}) == interface{}(SmallPair{3, 5}), interface{}(SmallPair{3, 5}) != interface{}(SmallPair{5, 3}))
+	println(interface{}([2]byte{1, 2}) == interface{}([2]byte{1, 2}), interface{}([2]byte{1, 2}) == interface{}([2]byte{2, 1}))
+	println(interface{}(struct{}{}) == interface{}(struct{}{}), interface{}(nil) == interface{}(struct{}{}))
+	println(Stringer(thing) == Stringer(thing), Stringer(thing) == nil)
+	println("nested equality")
+	n1 := Nested{"a", 1.5, [2]interface{}{1, "x"}, SmallPair{1, 2}}
+	n2 := Nested{"a", 1.5, [2]interface{}{1, "x"}, SmallPair{1, 2}}
+	n3 := Nested{"a", 1.5, [2]interface{}{1, "y"}, SmallPair{1, 2}}
+	println(n1 == n2, n1 == n3, n1 != n3)
+	println(interface{}(n1) == interface{}(n2), interface{}(n1) == interface{}(n3))
+	println([2]Nested{n1, n2} == [2]Nested{n2, n1}, [2]Nested{n1, n2} == [2]Nested{n1, n3})
+	println(interface{}(Nested{itfs: [2]interface{}{Array{1}, nil}}) == interface{}(Nested{itfs: [2]interface{}{Array{1}, nil}}))
 }
 
 func printItf(val interface{}) {
@@ -134,3 +153,10 @@ func (p SmallPair) Print() {
 type Unmatched interface {
 	NeverImplementedMethod()
 }
+
+type Nested struct {
+	name string
+	f    float64
+	itfs [2]interface{}
+	pair SmallPair
+}
@@ -24,6 +24,27 @@ var testMapArrayKey = map[ArrayKey]int{
 }
 var testmapIntInt = map[int]int{1: 1, 2: 4, 3: 9}
 
+var testMapFloatKey = map[float64]string{1.5: "one and a half", 0: "zero"}
+var testMapInterfaceKey = map[interface{}]int{1: 1, "two": 2, nil: 3}
+var testMapCompositeInterfaceKey = map[interface{}]int{
+	ArrayKey{1, 2, 3, 4}: 1,
+	namedKey{"foo", 1}:   2,
+	1.5:                  3,
+}
+
+type celsius float64
+
+var testMapNamedFloatKey = map[celsius]string{0: "zero", 100: "boiling"}
+
+type namedKey struct {
+	name string
+	n    int
+}
+
+type pointerKey struct {
+	n int
+}
+
 func main() {
 	m := map[string]int{"answer": 42, "foo": 3}
 	readMap(m, "answer")
@@ -47,6 +68,127 @@ func main() {
 	println(testMapArrayKey[arrKey])
 	testMapArrayKey[arrKey] = 5555
 	println(testMapArrayKey[arrKey])
+
+	// keys of other comparable types
+	floatKeys()
+	interfaceKeys()
+	structKeys()
+
+	// test map growth
+	growMap()
+	growWhileIterating()
+}
+
+func floatKeys() {
+	negZero := 0.0
+	negZero = -negZero
+	println("float key:", testMapFloatKey[1.5], testMapFloatKey[negZero])
+	testMapFloatKey[2.5] = "two and a half"
+	delete(testMapFloatKey, 1.5)
+	println("float key:", len(testMapFloatKey), testMapFloatKey[1.5] == "", testMapFloatKey[2.5])
+
+	nan := 0.0
+	nan = nan / nan
+	nanMap := map[float64]int{}
+	nanMap[nan] = 1
+	nanMap[nan] = 2
+	_, ok := nanMap[nan]
+	println("NaN key:", len(nanMap), ok)
+
+	complexMap := map[complex128]int{complex(1, 2): 3}
+	println("complex key:", complexMap[complex(1, 2)], complexMap[complex(2, 1)])
+}
+
+func interfaceKeys() {
+	println("interface key:", testMapInterfaceKey[1], testMapInterfaceKey["two"], testMapInterfaceKey[nil], testMapInterfaceKey[int8(1)])
+	testMapInterfaceKey[1.5] = 4
+	testMapInterfaceKey[true] = 5
+	delete(testMapInterfaceKey, "two")
+	_, ok := testMapInterfaceKey["two"]
+	println("interface key:", len(testMapInterfaceKey), ok, testMapInterfaceKey[1.5], testMapInterfaceKey[true])
+
+	// Struct, array and float values in interface keys are compared by
+	// value, and only with values of the same type.
+	name := "f"
+	name += "oo"
+	println("composite interface key:", testMapCompositeInterfaceKey[ArrayKey{1, 2, 3, 4}], testMapCompositeInterfaceKey[namedKey{name, 1}], testMapCompositeInterfaceKey[1.5], testMapCompositeInterfaceKey[[4]byte{1, 2, 3, 4}], testMapCompositeInterfaceKey[float32(1.5)])
+	println("named float key:", testMapNamedFloatKey[celsius(100)], testMapNamedFloatKey[celsius(-0.0)])
+}
+
+func structKeys() {
+	m := map[namedKey]int{}
+	m[namedKey{"foo", 1}] = 1
+	m[namedKey{"foo", 2}] = 2
+	m[namedKey{"f" + "oo", 1}] = 3
+	println("struct key:", len(m), m[namedKey{"foo", 1}], m[namedKey{"foo", 2}], m[namedKey{"bar", 1}])
+
+	arrayMap := map[[2]string]int{{"a", "b"}: 1}
+	println("string array key:", arrayMap[[2]string{"a", "b"}], arrayMap[[2]string{"b", "a"}])
+
+	p1 := &pointerKey{1}
+	p2 := &pointerKey{1}
+	pointerMap := map[*pointerKey]int{p1: 1, p2: 2}
+	println("pointer key:", len(pointerMap), pointerMap[p1], pointerMap[p2], pointerMap[nil])
+}
+
+func growMap() {
+	m := make(map[int]int)
+	for i := 0; i < 1000; i++ {
+		m[i] = i * 2
+	}
+	delete(m, 500)
+	sum := 0
+	for k, v := range m {
+		if v != k*2 {
+			println("wrong value for key", k)
+		}
+		sum += v
+	}
+	println("grown map:", len(m), sum, m[0], m[999], m[500])
+
+	s := make(map[string]int)
+	for _, k := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l", "m", "n", "o", "p", "q", "r", "s", "t"} {
+		s[k+k] = len(s)
+	}
+	println("grown string map:", len(s), s["aa"], s["tt"], s["zz"])
+}
+
+func growWhileIterating() {
+	m := map[int]int{0: 0, 1: 1, 2: 2, 3: 3, 4: 4, 5: 5}
+	seen := map[int]bool{}
+	deleted := map[int]bool{}
+	ok := true
+	for k, v := range m {
+		if k >= 6 {
+			// added during the iteration
+			continue
+		}
+		if seen[k] || deleted[k] {
+			ok = false
+		}
+		seen[k] = true
+		if len(seen) == 1 {
+			// Grow the map, then delete or update the keys that haven't been
+			// visited yet.
+			for i := 100; i < 150; i++ {
+				m[i] = i
+			}
+			for i := 0; i < 6; i++ {
+				if seen[i] {
+					continue
+				}
+				if i%2 == 0 {
+					delete(m, i)
+					deleted[i] = true
+				} else {
+					m[i] = i * 10
+				}
+			}
+		} else if v != k*10 {
+			ok = false
+		}
+	}
+	println("grow while iterating:", ok, len(seen)+len(deleted), len(m)+len(deleted))
 }
 
 func readMap(m map[string]int, key string) {
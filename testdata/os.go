@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io"
+	"os"
+)
+
+// This test only uses file operations that are supported by every target with
+// a filesystem. Directories are tested in osdir.go.
+
+func main() {
+	const name = "os-test.txt"
+	const other = "os-test-other.txt"
+
+	// Create a file and write to it.
+	f, err := os.Create(name)
+	check("create", err)
+	n, err := f.Write([]byte("hello world\n"))
+	check("write", err)
+	println("written:", n)
+	check("close", f.Close())
+
+	// Read it back.
+	f, err = os.Open(name)
+	check("open", err)
+	buf := make([]byte, 32)
+	n, err = f.Read(buf)
+	check("read", err)
+	println("read:", n, string(buf[:n-1]))
+	n, err = f.Read(buf)
+	println("read at end:", n, err == io.EOF)
+	pos, err := f.Seek(6, os.SEEK_SET)
+	check("seek", err)
+	n, err = f.Read(buf)
+	check("read", err)
+	println("read after seek:", pos, string(buf[:n-1]))
+	info, err := f.Stat()
+	check("fstat", err)
+	println("fstat:", info.Name(), info.Size())
+	check("close", f.Close())
+
+	// Stat the file.
+	info, err = os.Stat(name)
+	check("stat", err)
+	println("stat:", info.Name(), info.Size(), info.IsDir(), info.Mode().IsRegular(), info.Mode().Perm()&0600 == 0600)
+
+	// Create a file only if it doesn't exist yet.
+	f, err = os.OpenFile(other, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	check("openfile", err)
+	check("close", f.Close())
+
+	// Errors.
+	_, err = os.Open("os-test-missing.txt")
+	println("open missing:", os.IsNotExist(err))
+	if err, ok := err.(*os.PathError); ok {
+		println("path error:", err.Op, err.Path)
+	}
+	_, err = os.OpenFile(other, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	println("create existing:", os.IsExist(err))
+	_, err = os.Stat("os-test-missing.txt")
+	println("stat missing:", os.IsNotExist(err))
+
+	// Rename and remove.
+	check("rename", os.Rename(name, "os-test-renamed.txt"))
+	_, err = os.Stat(name)
+	println("stat after rename:", os.IsNotExist(err))
+	check("remove", os.Remove("os-test-renamed.txt"))
+	check("remove", os.Remove(other))
+	_, err = os.Stat(other)
+	println("stat after remove:", os.IsNotExist(err))
+}
+
+func check(op string, err error) {
+	if err != nil {
+		println("error:", op, err.Error())
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io"
+	"os"
+	"sort"
+)
+
+func main() {
+	const dir = "osdir-test.dir"
+	check("mkdir", os.Mkdir(dir, 0755))
+
+	// Create some files in the directory.
+	f, err := os.Create(dir + "/file.txt")
+	check("create", err)
+	_, err = f.Write([]byte("hello world\n"))
+	check("write", err)
+	check("close", f.Close())
+	f, err = os.OpenFile(dir+"/other.txt", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	check("openfile", err)
+	check("close", f.Close())
+
+	// Stat the directory.
+	info, err := os.Lstat(dir)
+	check("lstat", err)
+	println("lstat:", info.Name(), info.IsDir(), info.Mode().IsRegular(), info.Mode().Perm() != 0)
+
+	// List the directory.
+	f, err = os.Open(dir)
+	check("open dir", err)
+	names, err := f.Readdirnames(-1)
+	check("readdirnames", err)
+	sort.Strings(names)
+	for _, name := range names {
+		println("readdirnames:", name)
+	}
+	check("close", f.Close())
+	f, err = os.Open(dir)
+	check("open dir", err)
+	infos, err := f.Readdir(0)
+	check("readdir", err)
+	if len(infos) == 2 && infos[0].Name() > infos[1].Name() {
+		infos[0], infos[1] = infos[1], infos[0]
+	}
+	for _, info := range infos {
+		println("readdir:", info.Name(), info.Size())
+	}
+	infos, err = f.Readdir(1)
+	println("readdir at end:", len(infos), err == io.EOF)
+	check("close", f.Close())
+
+	// Errors.
+	_, err = os.Open(dir + "/missing.txt")
+	println("open missing:", err.Error(), os.IsNotExist(err))
+	err = os.Remove(dir)
+	println("remove non-empty directory:", os.IsExist(err))
+
+	// Remove the directory.
+	check("remove", os.Remove(dir+"/file.txt"))
+	check("remove", os.Remove(dir+"/other.txt"))
+	check("remove", os.Remove(dir))
+	_, err = os.Stat(dir)
+	println("stat after remove:", os.IsNotExist(err))
+
+	wd, err := os.Getwd()
+	check("getwd", err)
+	println("getwd:", len(wd) > 0 && wd[0] == '/')
+}
+
+func check(op string, err error) {
+	if err != nil {
+		println("error:", op, err.Error())
+	}
+}
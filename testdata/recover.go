@@ -0,0 +1,302 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+func main() {
+	println("recover outside panic:", recover() == nil)
+
+	recoverPanic()
+	recoverRuntimeError()
+	recoverIndexError()
+	println("named result:", recoverNamedResult())
+	recoverUnwind()
+	recoverRepanic()
+	recoverSendClosed()
+	recoverSelectSendClosed()
+	recoverCloseNil()
+	recoverCloseClosed()
+	recoverMakeChan(-1)
+	recoverMakeChanOverflow(^uint(0) >> 1)
+	recoverUnhashableKey()
+	recoverUncomparable()
+	recoverInHelper()
+	recoverAfterPrint()
+	recoverInterfaceMethod(recoverer{"interface"})
+	recoverBoundMethod(recoverer{"bound"})
+
+	// Panics in blocking functions.
+	go recoverInGoroutine()
+	time.Sleep(2 * time.Millisecond)
+	recoverBlocking()
+	recoverInterleaved()
+
+	println("done")
+}
+
+func recoverPanic() {
+	defer func() {
+		println("recovered:", recover())
+	}()
+	panic("simple panic")
+}
+
+func recoverRuntimeError() {
+	defer func() {
+		err := recover().(error)
+		println("recovered:", err.Error())
+	}()
+	var p *int
+	println("unreachable:", *p)
+}
+
+func recoverIndexError() {
+	defer func() {
+		err := recover().(error)
+		println("recovered:", err.Error())
+	}()
+	println("unreachable:", index([]int{1, 2, 3}, 5))
+}
+
+func index(s []int, i int) int {
+	return s[i]
+}
+
+func recoverNamedResult() (n int) {
+	defer func() {
+		recover()
+		n *= 2
+	}()
+	n = 3
+	panic(n)
+}
+
+func recoverUnwind() {
+	defer func() {
+		println("recovered:", recover())
+	}()
+	defer deferred("recoverUnwind")
+	unwindOuter()
+	println("unreachable")
+}
+
+func unwindOuter() {
+	defer deferred("unwindOuter")
+	unwindInner()
+	println("unreachable")
+}
+
+func unwindInner() {
+	defer deferred("unwindInner")
+	panic("panic through several functions")
+}
+
+func deferred(name string) {
+	println("deferred call in", name)
+}
+
+func recoverRepanic() {
+	defer func() {
+		println("recovered:", recover())
+	}()
+	defer func() {
+		panic("second panic")
+	}()
+	panic("first panic")
+}
+
+func recoverSendClosed() {
+	defer func() {
+		err := recover().(error)
+		println("recovered:", err.Error())
+	}()
+	ch := make(chan int, 1)
+	close(ch)
+	ch <- 3
+}
+
+func recoverSelectSendClosed() {
+	defer func() {
+		err := recover().(error)
+		println("recovered:", err.Error())
+	}()
+	ch := make(chan int)
+	close(ch)
+	var never chan int
+	select {
+	case ch <- 3:
+		println("unreachable")
+	case <-never:
+		println("unreachable")
+	}
+}
+
+func recoverCloseNil() {
+	defer func() {
+		err := recover().(error)
+		println("recovered:", err.Error())
+	}()
+	var ch chan int
+	close(ch)
+}
+
+func recoverCloseClosed() {
+	defer func() {
+		err := recover().(error)
+		println("recovered:", err.Error())
+	}()
+	ch := make(chan int)
+	close(ch)
+	close(ch)
+}
+
+func recoverMakeChan(size int) {
+	defer func() {
+		err := recover().(error)
+		println("recovered:", err.Error())
+	}()
+	ch := make(chan int, size)
+	println("unreachable:", cap(ch))
+}
+
+func recoverMakeChanOverflow(size uint) {
+	defer func() {
+		err := recover().(error)
+		println("recovered:", err.Error())
+	}()
+	ch := make(chan [1024]byte, size)
+	println("unreachable:", cap(ch))
+}
+
+func recoverUnhashableKey() {
+	defer func() {
+		err := recover().(error)
+		println("recovered:", err.Error())
+	}()
+	m := map[interface{}]int{}
+	m[[]int{1}] = 1
+	println("unreachable:", len(m))
+}
+
+func recoverUncomparable() {
+	defer func() {
+		err := recover().(error)
+		println("recovered:", err.Error())
+	}()
+	var x, y interface{} = []int{1}, []int{1}
+	println("unreachable:", x == y)
+}
+
+func recoverInHelper() {
+	defer func() {
+		println("recovered:", recover())
+	}()
+	defer func() {
+		// The helper is not called directly by the panicking function, so it
+		// can't stop the panic.
+		helperRecover()
+	}()
+	panic("panic with recover in helper")
+}
+
+func helperRecover() {
+	println("recover in helper:", recover() == nil)
+}
+
+// printer has a String method, which package fmt calls with a deferred
+// recover() to catch panics in it.
+type printer struct{}
+
+func (printer) String() string {
+	return "printer"
+}
+
+func recoverAfterPrint() {
+	defer func() {
+		println("recovered:", recover())
+	}()
+	defer func() {
+		fmt.Println("print while panicking:", printer{})
+	}()
+	panic("panic while printing")
+}
+
+type recoverer struct {
+	name string
+}
+
+func (r recoverer) Recover() {
+	println("recovered in method:", r.name, recover())
+}
+
+type recovererInterface interface {
+	Recover()
+}
+
+func recoverInterfaceMethod(r recovererInterface) {
+	defer r.Recover()
+	panic("interface method panic")
+}
+
+func recoverBoundMethod(r recoverer) {
+	f := r.Recover
+	defer f()
+	panic("bound method panic")
+}
+
+func recoverInGoroutine() {
+	defer func() {
+		println("recovered in goroutine:", recover())
+	}()
+	sleepAndPanic("panic in goroutine")
+}
+
+func recoverBlocking() {
+	defer func() {
+		println("recovered:", recover())
+	}()
+	sleepAndPanic("panic in blocking function")
+}
+
+func sleepAndPanic(msg string) {
+	time.Sleep(time.Millisecond)
+	panic(msg)
+}
+
+// Two goroutines that panic in a blocking function and recover, while the
+// panic of the other goroutine is still in progress.
+var interleavedRecovered [2]interface{}
+
+func recoverInterleaved() {
+	a := make(chan int)
+	b := make(chan int)
+	c := make(chan int)
+	done := make(chan int)
+	go recoverAfterReceive(0, a, b, done)
+	go recoverAfterReceive(1, b, c, done)
+	a <- 1
+	<-c
+	<-done
+	<-done
+	println("recovered in goroutines:", interleavedRecovered[0], interleavedRecovered[1])
+}
+
+func recoverAfterReceive(i int, in, out, done chan int) {
+	recoverAfterReceiveInner(i, in, out)
+	done <- 1
+}
+
+func recoverAfterReceiveInner(i int, in, out chan int) {
+	defer func() {
+		interleavedRecovered[i] = recover()
+	}()
+	receiveAndPanic(i, in, out)
+}
+
+func receiveAndPanic(i int, in, out chan int) {
+	<-in
+	out <- 1
+	panic(i)
+}
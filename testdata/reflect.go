@@ -9,6 +9,29 @@ type (
 	myint    int
 	myslice  []byte
 	myslice2 []myint
+	mychan   chan int
+	myptr    *int
+	myarray  [2]uint8
+	mystruct struct {
+		A int
+		b string
+		C [2]uint8
+	}
+	mypoint struct {
+		X, Y int16
+	}
+	mapKey struct {
+		a string
+		b int
+	}
+	linkedList struct {
+		Value int
+		Next  *linkedList
+	}
+	label   string
+	labeled struct {
+		Label label
+	}
 )
 
 func main() {
@@ -70,17 +93,29 @@ func main() {
 		[]float64{1, 1.64},
 		[]complex64{1, 1.64 + 0.3i},
 		[]complex128{1, 1.128 + 0.4i},
+		myslice{5, 3},
+		myslice2{7},
+		// named chan and pointer types
+		mychan(nil),
+		myptr(&n),
+		new(**[]*int),
 		// array
 		[4]int{1, 2, 3, 4},
+		[3]int8{1, -2, 3},
+		[2]string{"a", "bc"},
 		// functions
 		zeroFunc,
 		emptyFunc,
 		// maps
 		zeroMap,
 		map[string]int{},
+		map[int8]string{3: "three"},
 		// structs
 		struct{}{},
 		struct{ error }{},
+		struct{ A, B int8 }{3, -5},
+		mystruct{A: 5, b: "foo", C: [2]uint8{7, 8}},
+		&mypoint{3, -4},
 	} {
 		showValue(reflect.ValueOf(v), "")
 	}
@@ -217,6 +252,105 @@ func main() {
 	if rv.Len() != 2 || rv.Index(0).Int() != 3 {
 		panic("slice was changed while setting part of it")
 	}
+
+	// Set struct field and array element
+	point := mypoint{1, 2}
+	rv = reflect.ValueOf(&point).Elem()
+	rv.Field(1).SetInt(-20)
+	arr := [3]int{5, 6, 7}
+	rv = reflect.ValueOf(&arr).Elem()
+	rv.Index(2).SetInt(70)
+	println("\nsetting fields and elements:", point.X, point.Y, arr[0], arr[1], arr[2])
+
+	// Slice, Bytes
+	println("\nslicing:")
+	b := reflect.ValueOf([]byte{1, 2, 3, 4, 5}).Slice(1, 3).Bytes()
+	println(len(b), cap(b), b[0], b[1])
+	rv = reflect.ValueOf(&arr).Elem().Slice(1, 3)
+	println(rv.Len(), rv.Cap(), rv.Index(0).Int(), rv.Index(1).Int())
+	rv.Index(0).SetInt(60)
+	println(arr[1])
+	println(reflect.ValueOf("foobar").Slice(1, 4).String())
+
+	// Addr, CanAddr, IsValid
+	println("\naddressing:")
+	rv = reflect.ValueOf(&n).Elem()
+	println(rv.CanAddr(), reflect.ValueOf(n).CanAddr())
+	println(rv.Addr().Interface().(*int) == &n)
+	println(rv.IsValid(), reflect.ValueOf(new(*int)).Elem().Elem().IsValid())
+
+	// Type properties
+	println("\ntypes:")
+	println(reflect.TypeOf(int16(0)).Bits(), reflect.TypeOf(uint64(0)).Bits(), reflect.TypeOf(complex64(0)).Bits())
+	println(reflect.TypeOf([3]int8{}).Len(), reflect.TypeOf([3]int8{}).Elem().Kind().String())
+	println(reflect.TypeOf(&mypoint{}).Elem().NumField(), reflect.TypeOf(&mypoint{}).Elem().Field(1).Name)
+	println(reflect.TypeOf(mypoint{}).Size() == unsafe.Sizeof(mypoint{}), reflect.TypeOf(mystruct{}).Size() == unsafe.Sizeof(mystruct{}))
+	println(reflect.TypeOf(mystruct{}).Field(2).Offset == unsafe.Offsetof(mystruct{}.C))
+
+	// Maps
+	println("\nmaps:")
+	m := map[string]int{"one": 1, "two": 2}
+	rv = reflect.ValueOf(m)
+	println(rv.MapIndex(reflect.ValueOf("two")).Int(), rv.MapIndex(reflect.ValueOf("three")).IsValid())
+	rv.SetMapIndex(reflect.ValueOf("three"), reflect.ValueOf(3))
+	rv.SetMapIndex(reflect.ValueOf("one"), reflect.Value{})
+	println(len(m), m["three"], m["one"])
+	sum := 0
+	for _, key := range rv.MapKeys() {
+		sum += int(rv.MapIndex(key).Int())
+	}
+	println(sum)
+	iter := rv.MapRange()
+	count := 0
+	for iter.Next() {
+		count += len(iter.Key().String()) + int(iter.Value().Int())
+	}
+	println(count)
+	fm := reflect.MakeMap(reflect.TypeOf(map[float64]string{}))
+	fm.SetMapIndex(reflect.ValueOf(1.5), reflect.ValueOf("x"))
+	m2 := fm.Interface().(map[float64]string)
+	m2[2.5] = "y"
+	println(len(m2), m2[1.5], fm.MapIndex(reflect.ValueOf(2.5)).String())
+	sm := map[mapKey]int{}
+	rsm := reflect.ValueOf(sm)
+	rsm.SetMapIndex(reflect.ValueOf(mapKey{"a", 1}), reflect.ValueOf(10))
+	sm[mapKey{"b", 2}] = 20
+	println(sm[mapKey{"a", 1}], rsm.MapIndex(reflect.ValueOf(mapKey{"b", 2})).Int(), rsm.Len())
+	im := map[interface{}]myint{"one": 1}
+	rim := reflect.ValueOf(im)
+	rim.SetMapIndex(reflect.ValueOf(2), reflect.ValueOf(myint(2)))
+	println(im[2], rim.MapIndex(reflect.ValueOf("one")).Int(), rim.MapIndex(reflect.ValueOf(myint(2))).IsValid())
+	am := map[[2]uint8]mychan{}
+	ram := reflect.ValueOf(am)
+	ram.SetMapIndex(reflect.ValueOf(myarray{1, 2}), reflect.ValueOf(make(chan int)))
+	println(len(am), ram.MapIndex(reflect.ValueOf([2]uint8{1, 2})).IsNil(), ram.MapIndex(reflect.ValueOf(myarray{2, 1})).IsValid())
+
+	// Unexported fields can be read, but not set.
+	println("\nunexported fields:")
+	ms := mystruct{A: 5, b: "foo"}
+	rv = reflect.ValueOf(&ms).Elem()
+	println(rv.Field(0).CanSet(), rv.Field(1).CanSet(), rv.Field(1).CanInterface(), rv.Field(1).String())
+	println(rv.Field(2).Index(0).CanSet(), rv.Field(2).Index(0).CanAddr())
+	nested := struct{ list linkedList }{}
+	rv = reflect.ValueOf(&nested).Elem().Field(0).Field(0)
+	println(rv.CanSet(), rv.CanAddr(), rv.CanInterface(), rv.Int())
+
+	// Recursive types
+	println("\nrecursive types:")
+	list := &linkedList{Value: 1, Next: &linkedList{Value: 2}}
+	rv = reflect.ValueOf(list).Elem()
+	println(rv.Type().Field(1).Type == reflect.TypeOf(list), rv.Field(1).Elem().Field(0).Int())
+	rv.Field(1).Elem().Field(0).SetInt(3)
+	println(list.Next.Value, rv.Field(1).Elem().Field(1).IsNil())
+
+	// Types that are only put in an interface by the reflect package.
+	println("\nreflected interfaces:")
+	x := reflect.ValueOf(&labeled{Label: label([]byte("foo"))}).Elem().Field(0).Interface()
+	y := reflect.ValueOf(&labeled{Label: label([]byte("foo"))}).Elem().Field(0).Interface()
+	_, ok := x.(label)
+	println(x == y, ok)
+	labels := map[interface{}]int{x: 1}
+	println(labels[y])
 }
 
 func emptyFunc() {
@@ -251,7 +385,10 @@ func showValue(rv reflect.Value, indent string) {
 	case reflect.UnsafePointer:
 		println(indent+"  pointer:", rv.Pointer() != 0)
 	case reflect.Array:
-		println(indent + "  array")
+		println(indent+"  array:", rt.Len(), rt.Elem().Kind().String())
+		for i := 0; i < rv.Len(); i++ {
+			showValue(rv.Index(i), indent+"  ")
+		}
 	case reflect.Chan:
 		println(indent+"  chan:", rt.Elem().Kind().String())
 		println(indent+"  nil:", rv.IsNil())
@@ -262,8 +399,14 @@ func showValue(rv reflect.Value, indent string) {
 		println(indent + "  interface")
 		println(indent+"  nil:", rv.IsNil())
 	case reflect.Map:
-		println(indent + "  map")
+		println(indent+"  map:", rt.Key().Kind().String(), rt.Elem().Kind().String(), rv.Len())
 		println(indent+"  nil:", rv.IsNil())
+		// Only used for maps with at most one entry, as the order of the keys
+		// is unspecified.
+		for _, key := range rv.MapKeys() {
+			showValue(key, indent+"  ")
+			showValue(rv.MapIndex(key), indent+"  ")
+		}
 	case reflect.Ptr:
 		println(indent+"  pointer:", rv.Pointer() != 0, rt.Elem().Kind().String())
 		println(indent+"  nil:", rv.IsNil())
@@ -279,7 +422,12 @@ func showValue(rv reflect.Value, indent string) {
 			showValue(rv.Index(i), indent+"  ")
 		}
 	case reflect.Struct:
-		println(indent + "  struct")
+		println(indent+"  struct:", rt.NumField())
+		for i := 0; i < rv.NumField(); i++ {
+			field := rt.Field(i)
+			println(indent+"  field:", i, field.Name, field.PkgPath, field.Anonymous)
+			showValue(rv.Field(i), indent+"  ")
+		}
 	default:
 		println(indent + "  unknown type kind!")
 	}
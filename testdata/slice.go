@@ -65,6 +65,19 @@ func main() {
 	assert(len(arr[uint64(1):uint64(3)]) == 2)
 	assert(len(arr[uintptr(1):uintptr(3)]) == 2)
 
+	// full slice expressions
+	printslice("foo[1:2:3]", foo[1:2:3])
+	printslice("foo[:0:1]", foo[:0:1])
+	printslice("arr[1:3:4]", arr[1:3:4])
+	assert(cap(foo[int8(1):int16(2):uint32(3)]) == 2)
+	assert(cap(arr[uint8(0):int64(1):uintptr(2)]) == 2)
+
+	// appending to a full slice expression must not overwrite the original
+	limited := foo[0:2:2]
+	limited = append(limited, 42)
+	printslice("limited", limited)
+	printslice("foo", foo)
+
 	// copy
 	println("copy foo -> bar:", copy(bar, foo))
 	printslice("bar", bar)
@@ -0,0 +1,22 @@
+package main
+
+import "runtime"
+
+func main() {
+	outer()
+	println("nil func:", runtime.FuncForPC(0) == nil, runtime.FuncForPC(0).Name() == "")
+}
+
+//go:noinline
+func outer() {
+	inner()
+	println("outer: done")
+}
+
+//go:noinline
+func inner() {
+	pc, file, line, ok := runtime.Caller(0)
+	println("caller 0:", ok, runtime.FuncForPC(pc).Name(), file, line)
+	pc, file, line, ok = runtime.Caller(1)
+	println("caller 1:", ok, runtime.FuncForPC(pc).Name(), file, line)
+}
@@ -0,0 +1,26 @@
+package main
+
+// This program panics to check the stack trace that is printed, including the
+// line of each call.
+
+//go:noinline
+func main() {
+	println("start")
+	outer()
+	println("unreachable")
+}
+
+//go:noinline
+func outer() {
+	inner(false)
+	inner(true)
+	println("unreachable")
+}
+
+//go:noinline
+func inner(fail bool) {
+	println("inner:", fail)
+	if fail {
+		panic("stack trace")
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+type myRune rune
+
+func main() {
+	// string -> []rune
+	runes := []rune("abcü¢€𐍈°x")
+	println("runes:", len(runes))
+	for i, r := range runes {
+		println(i, r)
+	}
+
+	// []rune -> string
+	runes[0] = 'A'
+	runes[3] = 'Ü'
+	s := string(runes)
+	println("string:", s, len(s))
+	println("empty:", string([]rune(nil)) == "", len([]rune("")))
+	println("invalid:", string([]rune{-1, 0xd800, 0x110000, 'x'}))
+	println("named:", string([]myRune{'h', 'é'}))
+
+	// integer -> string
+	var b byte = 200
+	var i8 int8 = -1
+	var u uint = 0x20ac
+	var i64 int64 = 0x100000041
+	println("from integer:", string(b), string(i8), string(u), string(i64))
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+func main() {
+	testMutex()
+	testMutexFastPath()
+	testWaitGroup()
+	testCond()
+}
+
+func testMutex() {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	counter := 0
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			for j := 0; j < 2; j++ {
+				mu.Lock()
+				// Hold the lock while blocking, so that the other goroutines
+				// have to wait for it.
+				n := counter
+				time.Sleep(time.Millisecond)
+				counter = n + 1
+				mu.Unlock()
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	println("mutex counter:", counter)
+}
+
+func testMutexFastPath() {
+	var mu sync.Mutex
+	ch := make(chan int, 1)
+	ran := false
+	go func() {
+		ch <- 1
+		ran = true
+	}()
+	// Locking a mutex that isn't locked must not yield to the scheduler, so
+	// the goroutine above doesn't get a chance to run.
+	mu.Lock()
+	println("mutex fast path did not yield:", !ran)
+	mu.Unlock()
+	<-ch
+}
+
+func testWaitGroup() {
+	var wg sync.WaitGroup
+	wg.Wait() // must not block
+	results := make([]int, 4)
+	for i := range results {
+		wg.Add(1)
+		go square(&wg, results, i)
+	}
+	wg.Wait()
+	println("waitgroup results:", results[0], results[1], results[2], results[3])
+}
+
+func square(wg *sync.WaitGroup, results []int, i int) {
+	defer wg.Done()
+	time.Sleep(time.Millisecond)
+	results[i] = i * i
+}
+
+func testCond() {
+	var mu sync.Mutex
+	cond := sync.NewCond(&mu)
+	var wg sync.WaitGroup
+	ready := false
+	woken := 0
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			mu.Lock()
+			for !ready {
+				cond.Wait()
+			}
+			woken++
+			mu.Unlock()
+			wg.Done()
+		}()
+	}
+	time.Sleep(time.Millisecond)
+	mu.Lock()
+	ready = true
+	cond.Broadcast()
+	mu.Unlock()
+	wg.Wait()
+	println("cond woken:", woken)
+}